@@ -0,0 +1,195 @@
+package compiler
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+	"github.com/alphadose/haxmap"
+)
+
+// AssetManifestFile is the name of the manifest file added to the Static dir
+//
+// the manifest maps the url of each source file to the url of its content hashed file (ie: "/app.ts": "/app.min.1a2b3c4d5e.js")
+const AssetManifestFile = "turbx-manifest.json"
+
+// staticManifest maps source urls to content hashed urls
+var staticManifest *haxmap.Map[string, string] = haxmap.New[string, string]()
+
+var staticManifestMU sync.Mutex
+
+// hashedFileGraceTime is the number of minutes an old content hashed file is kept after it is replaced
+//
+// this gives pages that still link to the old file (ie: in a browser or cdn cache) time to expire
+const hashedFileGraceTime = 60
+
+// staleHashedFiles maps old content hashed files to the minute they were replaced
+var staleHashedFiles *haxmap.Map[string, int] = haxmap.New[string, int]()
+
+// HashedAsset returns the content hashed url for a static source url
+//
+// hashed files never change, so they can safely be served with `Cache-Control: immutable`
+func HashedAsset(url string) (string, bool) {
+	return staticManifest.Get(url)
+}
+
+// isHashedAsset returns true if a url is a content hashed file in the manifest
+func isHashedAsset(url string) bool {
+	found := false
+	staticManifest.ForEach(func(src string, hashUrl string) bool {
+		if hashUrl == url {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// staticUrlPath returns the url of a file in the Static dir
+func staticUrlPath(path string) string {
+	return filepath.ToSlash(strings.Replace(path, compilerConfig.Static, "", 1))
+}
+
+// hashStaticFile copies a minified file to a content hashed file, and adds it to the manifest
+//
+// example: .min.js -> .min.1a2b3c4d5e.js
+//
+// @srcPath: the original source file
+//
+// @resPath: the minified result of the source file
+func hashStaticFile(srcPath string, resPath string) {
+	res, err := os.ReadFile(resPath)
+	if err != nil {
+		return
+	}
+
+	sum := md5.Sum(res)
+	hashPath := string(regex.Comp(`(\.[\w_-]+)$`).RepStrComp([]byte(resPath), []byte("."+hex.EncodeToString(sum[:])[:10]+"$1")))
+
+	url := staticUrlPath(srcPath)
+	hashUrl := staticUrlPath(hashPath)
+
+	oldUrl, ok := staticManifest.Get(url)
+	if ok && oldUrl == hashUrl {
+		if stat, err := os.Stat(hashPath); err == nil && !stat.IsDir() {
+			return
+		}
+	}
+
+	if err := writeFileAtomic(hashPath, res, 0775); err != nil {
+		return
+	}
+	precompressStaticFile(hashPath)
+	staleHashedFiles.Del(hashPath)
+
+	staticManifest.Set(url, hashUrl)
+	writeStaticManifest()
+
+	if ok && oldUrl != hashUrl {
+		removeHashedFile(oldUrl)
+		invalidateAssetPages(oldUrl)
+	}
+}
+
+// removeHashedAsset removes a source file from the manifest, and queues its content hashed file to be removed
+func removeHashedAsset(srcPath string) {
+	url := staticUrlPath(srcPath)
+	if hashUrl, ok := staticManifest.Get(url); ok {
+		staticManifest.Del(url)
+		writeStaticManifest()
+
+		removeHashedFile(hashUrl)
+		invalidateAssetPages(hashUrl)
+	}
+}
+
+// removeHashedFile queues a content hashed file to be removed after the hashedFileGraceTime
+func removeHashedFile(hashUrl string) {
+	if hashPath, err := goutil.FS.JoinPath(compilerConfig.Static, hashUrl); err == nil {
+		staleHashedFiles.Set(hashPath, int(time.Now().UnixMilli()/60000))
+	}
+}
+
+// expireHashedFiles removes the old content hashed files that have been replaced for longer than the hashedFileGraceTime
+func expireHashedFiles(now int) {
+	staleHashedFiles.ForEach(func(hashPath string, replaced int) bool {
+		if now-replaced >= hashedFileGraceTime {
+			staleHashedFiles.Del(hashPath)
+			removeStaticFile(hashPath)
+		}
+		return true
+	})
+}
+
+// writeStaticManifest writes the manifest to the Static dir
+func writeStaticManifest() {
+	staticManifestMU.Lock()
+	defer staticManifestMU.Unlock()
+
+	manifest := map[string]string{}
+	staticManifest.ForEach(func(url string, hashUrl string) bool {
+		manifest[url] = hashUrl
+		return true
+	})
+
+	if path, err := goutil.FS.JoinPath(compilerConfig.Static, AssetManifestFile); err == nil {
+		if res, err := json.MarshalIndent(manifest, "", "  "); err == nil {
+			writeFileAtomic(path, res, 0775)
+		}
+	}
+}
+
+// loadStaticManifest loads an existing manifest from the Static dir
+//
+// this allows old hashed files to be replaced when a source file changes between restarts
+func loadStaticManifest() {
+	if path, err := goutil.FS.JoinPath(compilerConfig.Static, AssetManifestFile); err == nil {
+		if res, err := os.ReadFile(path); err == nil {
+			manifest := map[string]string{}
+			if err := json.Unmarshal(res, &manifest); err == nil {
+				for url, hashUrl := range manifest {
+					staticManifest.Set(url, hashUrl)
+				}
+			}
+		}
+	}
+}
+
+// invalidateAssetPages removes any cached html pages that link to an asset url
+//
+// the compiled pages are checked directly, so pages that never reference the asset will stay in the cache
+func invalidateAssetPages(url string) {
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		if len(data.cachePath) == 0 {
+			return true
+		}
+
+		html, _, err := compilerConfig.CacheStore.Get(data.cachePath[0])
+		if err != nil {
+			return true
+		}
+
+		if strings.HasSuffix(data.cachePath[0], ".br") {
+			html, err = goutil.BROTLI.UnZip(html)
+		} else if strings.HasSuffix(data.cachePath[0], ".gz") {
+			html, err = goutil.GZIP.UnZip(html)
+		}
+		if err != nil || !strings.Contains(string(html), url) {
+			return true
+		}
+
+		purgePreCache(path, data)
+
+		return true
+	})
+
+	regenerateInlinedPages(url)
+}
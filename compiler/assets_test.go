@@ -0,0 +1,117 @@
+package compiler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHashStaticFile(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.PreCompressMinSize = 1024
+	defer staticManifest.Del("/app.ts")
+
+	srcPath := filepath.Join(compilerConfig.Static, "app.ts")
+	resPath := filepath.Join(compilerConfig.Static, "app.min.js")
+	if err := os.WriteFile(resPath, []byte("console.log(1);"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	hashStaticFile(srcPath, resPath)
+
+	hashUrl, ok := HashedAsset("/app.ts")
+	if !ok || !regexp.MustCompile(`^/app\.min\.[0-9a-f]{10}\.js$`).MatchString(hashUrl) {
+		t.Fatalf("unexpected hashed url: %q", hashUrl)
+	}
+	if res, err := os.ReadFile(filepath.Join(compilerConfig.Static, hashUrl)); err != nil || string(res) != "console.log(1);" {
+		t.Errorf("expected the hashed file to match the minified file: %q %v", res, err)
+	}
+
+	manifest := map[string]string{}
+	if res, err := os.ReadFile(filepath.Join(compilerConfig.Static, AssetManifestFile)); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(res, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest["/app.ts"] != hashUrl {
+		t.Errorf("unexpected manifest: %v", manifest)
+	}
+
+	// the same content keeps the same url
+	hashStaticFile(srcPath, resPath)
+	if url, _ := HashedAsset("/app.ts"); url != hashUrl {
+		t.Errorf("expected the url to stay the same: %q != %q", url, hashUrl)
+	}
+
+	// new content gets a new url
+	if err := os.WriteFile(resPath, []byte("console.log(2);"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	hashStaticFile(srcPath, resPath)
+	newUrl, _ := HashedAsset("/app.ts")
+	if newUrl == hashUrl || !regexp.MustCompile(`^/app\.min\.[0-9a-f]{10}\.js$`).MatchString(newUrl) {
+		t.Errorf("expected a new hashed url: %q", newUrl)
+	}
+
+	// the old file is kept for a grace period, for pages that still link to it
+	now := int(time.Now().UnixMilli() / 60000)
+	oldPath := filepath.Join(compilerConfig.Static, hashUrl)
+	expireHashedFiles(now)
+	if _, err := os.Stat(oldPath); err != nil {
+		t.Errorf("expected the old hashed file to be kept: %v", err)
+	}
+	expireHashedFiles(now + hashedFileGraceTime)
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("expected the old hashed file to be removed after the grace period: %v", err)
+	}
+
+	// the manifest is loaded on restart
+	staticManifest.Del("/app.ts")
+	loadStaticManifest()
+	if url, _ := HashedAsset("/app.ts"); url != newUrl {
+		t.Errorf("expected the manifest to be loaded: %q != %q", url, newUrl)
+	}
+
+	removeHashedAsset(srcPath)
+	if _, ok := HashedAsset("/app.ts"); ok {
+		t.Error("expected the url to be removed from the manifest")
+	}
+	expireHashedFiles(now + hashedFileGraceTime + 1)
+	if _, err := os.Stat(filepath.Join(compilerConfig.Static, newUrl)); !os.IsNotExist(err) {
+		t.Errorf("expected the hashed file to be removed: %v", err)
+	}
+}
+
+func TestInvalidateAssetPages(t *testing.T) {
+	testConfig(t)
+
+	linkPath := filepath.Join(compilerConfig.StaticHTML, "link.html.html")
+	otherPath := filepath.Join(compilerConfig.StaticHTML, "other.html.html")
+	if err := compilerConfig.CacheStore.Put(linkPath, []byte(`<script src="/app.min.1a2b3c4d5e.js"></script>`), CacheMeta{Static: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := compilerConfig.CacheStore.Put(otherPath, []byte(`<h1>Hello</h1>`), CacheMeta{Static: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	htmlPreCache.Set("test/link", cacheObj{cachePath: []string{linkPath}, static: true})
+	htmlPreCache.Set("test/other", cacheObj{cachePath: []string{otherPath}, static: true})
+	defer htmlPreCache.Del("test/link")
+	defer htmlPreCache.Del("test/other")
+
+	invalidateAssetPages("/app.min.1a2b3c4d5e.js")
+
+	if _, ok := htmlPreCache.Get("test/link"); ok {
+		t.Error("expected the page that links to the asset to be removed")
+	}
+	if _, _, err := compilerConfig.CacheStore.Get(linkPath); err == nil {
+		t.Error("expected the cache file of the page to be removed from the CacheStore")
+	}
+	if _, ok := htmlPreCache.Get("test/other"); !ok {
+		t.Error("expected the other page to be kept")
+	}
+}
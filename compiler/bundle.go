@@ -0,0 +1,683 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+	"github.com/alphadose/haxmap"
+)
+
+// bundleDeps maps each bundle entry file to the files it imports
+var bundleDeps *haxmap.Map[string, []string] = haxmap.New[string, []string]()
+
+// list of file extensions to try when resolving a js import without an extension
+var jsImportExts []string = []string{"", ".js", ".ts", ".mjs", ".mts", ".jsx", ".tsx", "/index.js", "/index.ts"}
+
+// jsModule is a single module inlined into a js bundle
+type jsModule struct {
+	path string
+	code []byte
+
+	// names exported by the module (used for `export * from`)
+	exports []string
+
+	// getters for the module exports
+	getters []string
+
+	// weather or not the module is still loading its imports (used to detect circular imports)
+	loading bool
+}
+
+type jsBundler struct {
+	modules []*jsModule
+	byPath  map[string]*jsModule
+
+	// external imports hoisted to the top of the bundle
+	external      map[string]string
+	externalOrder []string
+
+	deps []string
+	ind  int
+
+	// the chain of modules that are currently loading
+	stack []string
+}
+
+// bundleJS inlines the relative es module imports of an entry file
+//
+// each imported module is only added once.
+// named and default imports are copied when the import runs, so an export that is reassigned later will not update them
+// (a namespace import (ie: `import * as mod`) reads the current value of each export).
+// circular imports return an error, because the modules would receive each other before they finish loading
+//
+// @[]string: list of files the bundle depends on
+func bundleJS(path string) ([]byte, []string, error) {
+	bundler := jsBundler{
+		byPath:   map[string]*jsModule{},
+		external: map[string]string{},
+	}
+
+	entry, err := bundler.load(path, true)
+	if err != nil {
+		return nil, bundler.deps, err
+	}
+
+	if len(bundler.modules) == 0 && len(bundler.externalOrder) == 0 {
+		return entry.code, nil, nil
+	}
+
+	res := []byte{}
+	for _, spec := range bundler.externalOrder {
+		res = append(res, regex.JoinBytes([]byte("import * as "), bundler.external[spec], []byte(" from "), tsQuote([]byte(spec)), []byte(";\n"))...)
+	}
+
+	res = append(res, []byte("const __turbx_modules = {};\nfunction __turbx_export(e, g) { for (const k in g) { Object.defineProperty(e, k, {enumerable: true, get: g[k]}); } }\n")...)
+
+	for _, m := range bundler.modules {
+		res = append(res, regex.JoinBytes(
+			[]byte("__turbx_modules["), tsQuote([]byte(staticUrlPath(m.path))), []byte("] = (function(){ const __exports = {}; __turbx_export(__exports, {"), strings.Join(m.getters, ", "), []byte("});\n"),
+			m.code,
+			[]byte("\nreturn __exports; })();\n"),
+		)...)
+	}
+
+	res = append(res, entry.code...)
+
+	return res, bundler.deps, nil
+}
+
+// resolve returns the path of a relative import
+func (bundler *jsBundler) resolve(from string, spec string) (string, error) {
+	var full string
+	if strings.HasPrefix(spec, "/") {
+		full = filepath.Join(compilerConfig.Static, spec)
+	} else {
+		full = filepath.Join(filepath.Dir(from), spec)
+	}
+
+	if !strings.HasPrefix(full, compilerConfig.Static) {
+		return "", errors.New("import '" + spec + "' is outside the static root")
+	}
+
+	for _, ext := range jsImportExts {
+		if stat, err := os.Stat(full + ext); err == nil && !stat.IsDir() {
+			return full + ext, nil
+		}
+	}
+
+	// add the missing paths to the deps, so the bundle will be rebuilt when the file is added
+	for _, ext := range jsImportExts {
+		if !goutil.Contains(bundler.deps, full+ext) {
+			bundler.deps = append(bundler.deps, full+ext)
+		}
+	}
+
+	return "", errors.New("import '" + spec + "' could not be found")
+}
+
+// ref returns the js reference to an imported module
+//
+// relative modules are loaded into the bundle, and external modules are hoisted to the top of the bundle
+func (bundler *jsBundler) ref(from string, spec string, isEntry bool) ([]byte, *jsModule, error) {
+	if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") && !strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "//") {
+		if isEntry {
+			return nil, nil, nil
+		}
+
+		alias, ok := bundler.external[spec]
+		if !ok {
+			alias = "__turbx_ext" + strconv.Itoa(len(bundler.externalOrder))
+			bundler.external[spec] = alias
+			bundler.externalOrder = append(bundler.externalOrder, spec)
+		}
+		return []byte(alias), nil, nil
+	}
+
+	path, err := bundler.resolve(from, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := bundler.load(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return regex.JoinBytes([]byte("__turbx_modules["), tsQuote([]byte(staticUrlPath(path))), ']'), m, nil
+}
+
+// load reads a module, and rewrites its imports and exports
+func (bundler *jsBundler) load(path string, isEntry bool) (*jsModule, error) {
+	if m, ok := bundler.byPath[path]; ok {
+		if m.loading {
+			chain := []string{}
+			for _, p := range append(bundler.stack, path) {
+				chain = append(chain, staticUrlPath(p))
+			}
+			return nil, errors.New("circular import: " + strings.Join(chain, " -> "))
+		}
+		return m, nil
+	}
+
+	m := &jsModule{path: path, loading: true}
+	bundler.byPath[path] = m
+	bundler.stack = append(bundler.stack, path)
+	defer func() {
+		m.loading = false
+		bundler.stack = bundler.stack[:len(bundler.stack)-1]
+	}()
+	if !isEntry {
+		bundler.deps = append(bundler.deps, path)
+	}
+
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if regex.Comp(`\.(ts|tsx|mts|jsx)$`).Match([]byte(path)) {
+		code, err = transpileTypeScript(code, strings.HasSuffix(path, "x"))
+		if err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+	}
+
+	toks, _, err := tsTokenize(code, 0, false, false)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+
+	next := func(i int) int {
+		for i++; i < len(toks); i++ {
+			if toks[i].kind > 1 {
+				return i
+			}
+		}
+		return len(toks)
+	}
+
+	is := func(i int, val string) bool {
+		return i < len(toks) && toks[i].kind > 1 && toks[i].kind != 4 && toks[i].kind != 5 && string(toks[i].val) == val
+	}
+
+	matchClose := func(i int) int {
+		level := 0
+		for ; i < len(toks); i++ {
+			if is(i, "(") || is(i, "[") || is(i, "{") {
+				level++
+			} else if is(i, ")") || is(i, "]") || is(i, "}") {
+				level--
+				if level == 0 {
+					return i
+				}
+			}
+		}
+		return len(toks) - 1
+	}
+
+	// stmtEnd returns the index after an optional semicolon
+	stmtEnd := func(i int) int {
+		if n := next(i); is(n, ";") {
+			return n + 1
+		}
+		return i + 1
+	}
+
+	addGetter := func(name string, val []byte) {
+		m.exports = append(m.exports, name)
+		m.getters = append(m.getters, string(regex.JoinBytes(tsQuote([]byte(name)), []byte(": () => "), val)))
+	}
+
+	// parseSpecifiers parses a list of import or export specifiers (ie: { a, b as c, default as d })
+	//
+	// @[0]: the original name
+	// @[1]: the local name
+	parseSpecifiers := func(open int) ([][2]string, int) {
+		end := matchClose(open)
+		list := [][2]string{}
+		for i := next(open); i < end; i = next(i) {
+			if is(i, ",") {
+				continue
+			}
+
+			name := string(toks[i].val)
+			local := name
+			if n := next(i); is(n, "as") {
+				i = next(n)
+				local = string(toks[i].val)
+			}
+
+			// string names (ie: "my-name" as myName)
+			if toks[i].kind == 4 {
+				local = string(bytes.Trim(toks[i].val, `"'`))
+			}
+			name = string(bytes.Trim([]byte(name), `"'`))
+
+			list = append(list, [2]string{name, local})
+		}
+		return list, end
+	}
+
+	// bindingNames returns the names of variables declared by a binding pattern
+	var bindingNames func(i int) ([]string, int)
+	bindingNames = func(i int) ([]string, int) {
+		if toks[i].kind == 2 {
+			return []string{string(toks[i].val)}, next(i)
+		}
+
+		names := []string{}
+		end := matchClose(i)
+		isObj := is(i, "{")
+		for j := next(i); j < end; {
+			if is(j, ",") || is(j, "...") {
+				j = next(j)
+				continue
+			}
+
+			if is(j, "{") || is(j, "[") {
+				list, n := bindingNames(j)
+				names = append(names, list...)
+				j = n
+			} else if toks[j].kind == 2 && !(isObj && is(next(j), ":")) {
+				names = append(names, string(toks[j].val))
+				j = next(j)
+			} else if isObj && is(next(j), ":") {
+				j = next(next(j))
+				continue
+			} else {
+				j = next(j)
+			}
+
+			// skip default values
+			if is(j, "=") {
+				level := 0
+				for j < end && !(level == 0 && is(j, ",")) {
+					if is(j, "(") || is(j, "[") || is(j, "{") {
+						level++
+					} else if is(j, ")") || is(j, "]") || is(j, "}") {
+						level--
+					}
+					j = next(j)
+				}
+			}
+		}
+		return names, next(end)
+	}
+
+	res := []byte{}
+	insert := map[int][]byte{}
+	depth := 0
+	last := -1
+
+	for i := 0; i < len(toks); i++ {
+		if ins, ok := insert[i]; ok {
+			res = append(res, ins...)
+		}
+
+		tok := toks[i]
+		if tok.kind <= 1 {
+			res = append(res, tok.val...)
+			continue
+		}
+
+		stmtStart := last == -1 || is(last, ";") || is(last, "}") || is(last, "{")
+		if !stmtStart {
+			for j := last + 1; j < i; j++ {
+				if toks[j].nl {
+					stmtStart = true
+					break
+				}
+			}
+		}
+		last = i
+
+		if is(i, "{") || is(i, "(") || is(i, "[") {
+			depth++
+		} else if is(i, "}") || is(i, ")") || is(i, "]") {
+			depth--
+		}
+
+		if depth != 0 || !stmtStart || tok.kind != 2 {
+			res = append(res, tok.val...)
+			continue
+		}
+
+		if is(i, "import") && !is(next(i), "(") && !is(next(i), ".") {
+			j := next(i)
+
+			var def, ns []byte
+			var specs [][2]string
+			hasSpecs := false
+			for j < len(toks) && toks[j].kind != 4 && !is(j, "from") {
+				if is(j, "*") && is(next(j), "as") {
+					j = next(next(j))
+					ns = toks[j].val
+				} else if is(j, "{") {
+					specs, j = parseSpecifiers(j)
+					hasSpecs = true
+				} else if toks[j].kind == 2 {
+					def = toks[j].val
+				}
+				j = next(j)
+			}
+			if is(j, "from") {
+				j = next(j)
+			}
+			if j >= len(toks) {
+				return nil, errors.New(path + ": invalid import")
+			}
+
+			ref, dep, err := bundler.ref(path, string(bytes.Trim(toks[j].val, `"'`)), isEntry)
+			if err != nil {
+				return nil, errors.New(path + ": " + err.Error())
+			}
+			if ref == nil {
+				// keep external imports in the entry file
+				res = append(res, tok.val...)
+				continue
+			}
+			_ = dep
+
+			end := stmtEnd(j)
+			if def != nil {
+				res = append(res, regex.JoinBytes([]byte("const "), def, []byte(" = "), ref, []byte(".default;"))...)
+			}
+			if ns != nil {
+				res = append(res, regex.JoinBytes([]byte("const "), ns, []byte(" = "), ref, ';')...)
+			}
+			if hasSpecs && len(specs) != 0 {
+				list := []string{}
+				for _, spec := range specs {
+					list = append(list, string(tsQuote([]byte(spec[0])))+": "+spec[1])
+				}
+				res = append(res, regex.JoinBytes([]byte("const {"), strings.Join(list, ", "), []byte("} = "), ref, ';')...)
+			}
+
+			last = end - 1
+			i = end - 1
+			continue
+		}
+
+		if !is(i, "export") {
+			res = append(res, tok.val...)
+			continue
+		}
+
+		j := next(i)
+
+		// export * from './mod'
+		// export * as ns from './mod'
+		// export { a, b as c } from './mod'
+		if is(j, "*") || (is(j, "{") && is(next(matchClose(j)), "from")) {
+			var ns []byte
+			var specs [][2]string
+			isAll := is(j, "*")
+			if isAll && is(next(j), "as") {
+				ns = toks[next(next(j))].val
+				j = next(next(j))
+			} else if !isAll {
+				specs, j = parseSpecifiers(j)
+			}
+			j = next(next(j))
+			if j >= len(toks) {
+				return nil, errors.New(path + ": invalid export")
+			}
+
+			ref, dep, err := bundler.ref(path, string(bytes.Trim(toks[j].val, `"'`)), isEntry)
+			if err != nil {
+				return nil, errors.New(path + ": " + err.Error())
+			}
+			if ref == nil {
+				res = append(res, tok.val...)
+				continue
+			}
+
+			if isAll && ns == nil {
+				if dep == nil {
+					return nil, errors.New(path + ": cannot use `export *` with an external module")
+				}
+				specs = [][2]string{}
+				for _, name := range dep.exports {
+					if name != "default" {
+						specs = append(specs, [2]string{name, name})
+					}
+				}
+			}
+
+			if isEntry {
+				tmp := []string{}
+				list := []string{}
+				if ns != nil {
+					res = append(res, regex.JoinBytes([]byte("const __turbx_re"), strconv.Itoa(bundler.ind), []byte(" = "), ref, []byte("; export {__turbx_re"), strconv.Itoa(bundler.ind), []byte(" as "), ns, []byte("};"))...)
+					bundler.ind++
+				} else {
+					for _, spec := range specs {
+						name := "__turbx_re" + strconv.Itoa(bundler.ind)
+						bundler.ind++
+						tmp = append(tmp, string(tsQuote([]byte(spec[0])))+": "+name)
+						list = append(list, name+" as "+spec[1])
+					}
+					res = append(res, regex.JoinBytes([]byte("const {"), strings.Join(tmp, ", "), []byte("} = "), ref, []byte("; export {"), strings.Join(list, ", "), []byte("};"))...)
+				}
+			} else if ns != nil {
+				addGetter(string(ns), ref)
+			} else {
+				for _, spec := range specs {
+					addGetter(spec[1], regex.JoinBytes(ref, '[', tsQuote([]byte(spec[0])), ']'))
+				}
+			}
+
+			end := stmtEnd(j)
+			last = end - 1
+			i = end - 1
+			continue
+		}
+
+		// the entry file keeps all of its local exports
+		if isEntry {
+			res = append(res, tok.val...)
+			continue
+		}
+
+		switch {
+		case is(j, "{"):
+			// export { a, b as c }
+			specs, end := parseSpecifiers(j)
+			for _, spec := range specs {
+				addGetter(spec[1], []byte(spec[0]))
+			}
+			end = stmtEnd(end)
+			last = end - 1
+			i = end - 1
+			continue
+		case is(j, "default"):
+			n := next(j)
+			isAsync := is(n, "async")
+			if isAsync {
+				n = next(n)
+			}
+
+			if is(n, "function") || is(n, "class") {
+				name := next(n)
+				if is(name, "*") {
+					name = next(name)
+				}
+
+				if toks[name].kind == 2 && !is(name, "extends") {
+					// named declaration
+					addGetter("default", toks[name].val)
+					i = j
+					last = j
+					continue
+				}
+
+				// anonymous declaration (add a semicolon after the body)
+				body := name
+				for body < len(toks) && !is(body, "{") {
+					if is(body, "(") {
+						body = matchClose(body)
+					}
+					body = next(body)
+				}
+				insert[matchClose(body)+1] = append(insert[matchClose(body)+1], ';')
+			}
+
+			addGetter("default", []byte("__turbx_default"))
+			res = append(res, []byte("const __turbx_default =")...)
+			i = j
+			last = j
+			continue
+		case is(j, "const") || is(j, "let") || is(j, "var"):
+			// export const a = 1, { b, c: d } = obj
+			k := next(j)
+			for k < len(toks) {
+				names, n := bindingNames(k)
+				for _, name := range names {
+					addGetter(name, []byte(name))
+				}
+
+				// skip the initializer
+				level := 0
+				for n < len(toks) && !(level == 0 && (is(n, ",") || is(n, ";"))) {
+					if is(n, "(") || is(n, "[") || is(n, "{") {
+						level++
+					} else if is(n, ")") || is(n, "]") || is(n, "}") {
+						level--
+						if level < 0 {
+							break
+						}
+					}
+					n = next(n)
+				}
+				if !is(n, ",") {
+					break
+				}
+				k = next(n)
+			}
+		case is(j, "function") || is(j, "class") || is(j, "async"):
+			// export function name() {}
+			n := j
+			if is(n, "async") {
+				n = next(n)
+			}
+			n = next(n)
+			if is(n, "*") {
+				n = next(n)
+			}
+			if n < len(toks) {
+				addGetter(string(toks[n].val), toks[n].val)
+			}
+		}
+
+		// remove the `export` keyword, and keep the declaration
+		last = i
+	}
+
+	if ins, ok := insert[len(toks)]; ok {
+		res = append(res, ins...)
+	}
+
+	m.code = res
+	if !isEntry {
+		bundler.modules = append(bundler.modules, m)
+	}
+
+	return m, nil
+}
+
+// bundleCSS inlines the local `@import` rules of a css file
+//
+// each file is only added once, and relative `url()` paths in imported files are updated to the static url
+//
+// @[]string: list of files the bundle depends on
+func bundleCSS(path string) ([]byte, []string, error) {
+	seen := map[string]bool{path: true}
+	deps := []string{}
+
+	var load func(path string, isEntry bool) ([]byte, error)
+	load = func(path string, isEntry bool) ([]byte, error) {
+		code, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isEntry {
+			// update relative urls
+			dir := filepath.Dir(path)
+			code = regex.Comp(`url\(\s*(["']?)([^"'\)]+)\1\s*\)`).RepFunc(code, func(data func(int) []byte) []byte {
+				url := data(2)
+				if url[0] == '/' || url[0] == '#' || regex.Comp(`^[\w_-]+:`).Match(url) {
+					return data(0)
+				}
+
+				full := filepath.Join(dir, string(url))
+				if !strings.HasPrefix(full, compilerConfig.Static) {
+					return data(0)
+				}
+				return regex.JoinBytes([]byte("url("), data(1), compilerConfig.StaticUrl, staticUrlPath(full), data(1), ')')
+			})
+		}
+
+		var loadErr error
+		code = regex.Comp(`@import\s+(?:url\(\s*)?(["']?)([^"'\)\s;]+)\1\s*\)?\s*([^;]*);`).RepFunc(code, func(data func(int) []byte) []byte {
+			spec := string(data(2))
+			if strings.Contains(spec, "://") || strings.HasPrefix(spec, "//") || strings.HasPrefix(spec, "data:") {
+				return data(0)
+			}
+
+			var full string
+			if strings.HasPrefix(spec, "/") {
+				full = filepath.Join(compilerConfig.Static, spec)
+			} else {
+				full = filepath.Join(filepath.Dir(path), spec)
+			}
+			if !strings.HasPrefix(full, compilerConfig.Static) {
+				loadErr = errors.New(path + ": import '" + spec + "' is outside the static root")
+				return []byte{}
+			}
+
+			if seen[full] {
+				return []byte{}
+			}
+			seen[full] = true
+			deps = append(deps, full)
+
+			res, err := load(full, false)
+			if err != nil {
+				loadErr = err
+				return []byte{}
+			}
+
+			if media := bytes.TrimSpace(data(3)); len(media) != 0 {
+				return regex.JoinBytes([]byte("@media "), media, '{', res, '}')
+			}
+			return res
+		})
+
+		return code, loadErr
+	}
+
+	res, err := load(path, true)
+	return res, deps, err
+}
+
+// rebuildBundles runs tryMinifyFile on every bundle that imports a file
+func rebuildBundles(path string) {
+	entries := []string{}
+	bundleDeps.ForEach(func(entry string, deps []string) bool {
+		if entry != path && goutil.Contains(deps, path) {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+
+	for _, entry := range entries {
+		tryMinifyFile(entry)
+	}
+}
@@ -0,0 +1,149 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/AspieSoft/goutil/v5"
+)
+
+func TestBundleJS(t *testing.T) {
+	testConfig(t)
+	dir := compilerConfig.Static
+
+	files := map[string]string{
+		"app.js":      "import { add } from './lib/math';\nimport def from './lib/def.js';\nimport * as ext from 'https://example.com/ext.js';\nconsole.log(add(1, 2), def, ext);\n",
+		"lib/math.js": "export function add(a, b) { return a + b; }\nexport const PI = 3.14;\n",
+		"lib/def.js":  "import { PI } from './math.js';\nexport default PI * 2;\n",
+		"missing.js":  "import { a } from './lib/missing.js';\n",
+	}
+	for name, code := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0775)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, deps, err := bundleJS(filepath.Join(dir, "app.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(deps, []string{filepath.Join(dir, "lib/math.js"), filepath.Join(dir, "lib/def.js")}) {
+		t.Errorf("unexpected deps: %v", deps)
+	}
+
+	for _, want := range []string{
+		`const {"add": add} = __turbx_modules["/lib/math.js"];`,
+		`const def = __turbx_modules["/lib/def.js"].default;`,
+		`const {"PI": PI} = __turbx_modules["/lib/math.js"];`,
+		`import * as ext from 'https://example.com/ext.js';`,
+		"console.log(add(1, 2), def, ext);",
+	} {
+		if !bytes.Contains(res, []byte(want)) {
+			t.Errorf("expected %s in bundle:\n%s", want, res)
+		}
+	}
+
+	// each module is only added once
+	if n := bytes.Count(res, []byte(`__turbx_modules["/lib/math.js"] = `)); n != 1 {
+		t.Errorf("expected math.js to be added once, found %d:\n%s", n, res)
+	}
+	if bytes.Contains(res, []byte("from './lib/")) {
+		t.Errorf("expected relative imports to be removed:\n%s", res)
+	}
+
+	if _, deps, err := bundleJS(filepath.Join(dir, "missing.js")); err == nil {
+		t.Error("expected an error for a missing import")
+	} else if !goutil.Contains(deps, filepath.Join(dir, "lib/missing.js")) {
+		t.Errorf("expected the missing import to be a dep, so it rebuilds when the file is added: %v", deps)
+	}
+}
+
+func TestBundleJSBindings(t *testing.T) {
+	testConfig(t)
+	dir := compilerConfig.Static
+
+	files := map[string]string{
+		"app.js":     "import * as counter from './counter.js';\nimport { count, inc } from './counter.js';\ninc();\nconsole.log(counter.count, count);\n",
+		"counter.js": "export let count = 0;\nexport function inc() { count++; }\n",
+		"a.js":       "import { b } from './b.js';\nexport const a = 1;\n",
+		"b.js":       "import { a } from './a.js';\nexport const b = a + 1;\n",
+	}
+	for name, code := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, _, err := bundleJS(filepath.Join(dir, "app.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the exports object reads the current value of a reassigned export, and named imports are copied when the import runs
+	for _, want := range []string{
+		`"count": () => count`,
+		`const counter = __turbx_modules["/counter.js"];`,
+		`const {"count": count, "inc": inc} = __turbx_modules["/counter.js"];`,
+	} {
+		if !bytes.Contains(res, []byte(want)) {
+			t.Errorf("expected %s in bundle:\n%s", want, res)
+		}
+	}
+
+	if _, _, err := bundleJS(filepath.Join(dir, "a.js")); err == nil || !bytes.Contains([]byte(err.Error()), []byte("circular import: /a.js -> /b.js -> /a.js")) {
+		t.Errorf("expected an error for a circular import: %v", err)
+	}
+}
+
+func TestBundleCSS(t *testing.T) {
+	testConfig(t)
+	dir := compilerConfig.Static
+
+	files := map[string]string{
+		"style.css":     "@import 'lib/base.css';\n@import url(\"lib/base.css\");\n@import 'print.css' print;\n@import 'https://example.com/font.css';\nbody { color: red; }\n",
+		"lib/base.css":  "@import './reset.css';\nh1 { background: url(img/bg.png); }\n",
+		"lib/reset.css": "* { margin: 0; }\n",
+		"print.css":     "nav { display: none; }\n",
+		"outside.css":   "@import '../other.css';\n",
+	}
+	for name, code := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0775)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, deps, err := bundleCSS(filepath.Join(dir, "style.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(deps, []string{filepath.Join(dir, "lib/base.css"), filepath.Join(dir, "lib/reset.css"), filepath.Join(dir, "print.css")}) {
+		t.Errorf("unexpected deps: %v", deps)
+	}
+
+	for _, want := range []string{
+		"* { margin: 0; }",
+		"h1 { background: url(/lib/img/bg.png); }",
+		"@media print{nav { display: none; }\n}",
+		"@import 'https://example.com/font.css';",
+		"body { color: red; }",
+	} {
+		if !bytes.Contains(res, []byte(want)) {
+			t.Errorf("expected %s in bundle:\n%s", want, res)
+		}
+	}
+
+	if n := bytes.Count(res, []byte("h1 {")); n != 1 {
+		t.Errorf("expected base.css to be added once, found %d:\n%s", n, res)
+	}
+
+	if _, _, err := bundleCSS(filepath.Join(dir, "outside.css")); err == nil {
+		t.Error("expected an error for an import outside of the static root")
+	}
+}
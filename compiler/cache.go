@@ -0,0 +1,779 @@
+package compiler
+
+import (
+	"container/list"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AspieSoft/go-liveread"
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// CacheStore stores the precompiled html files (and their md5sum files) for the compiler
+//
+// keys are the file paths inside the StaticHTML and CacheDir directories
+//
+// a store can be shared by multiple instances of an app (ie: a directory on a network mount, or a key-value server),
+// so pages precompiled by one instance can be used by the others.
+type CacheStore interface {
+	// Get should return an error that matches os.ErrNotExist for missing (or evicted) files,
+	// so the compiler knows to run PreCompile again
+	Get(key string) ([]byte, CacheMeta, error)
+
+	// Put should return an error that matches ErrCacheTooLarge if a file will never fit in the store,
+	// so the compiler knows to serve the page without caching it
+	Put(key string, data []byte, meta CacheMeta) error
+
+	Delete(key string) error
+
+	// List returns the metadata of every file in the store by key
+	List() (map[string]CacheMeta, error)
+}
+
+// ErrCacheTooLarge is returned by a CacheStore when a file is larger than the store can hold
+//
+// pages that are too large for the store are compiled on each request, instead of returning an error
+var ErrCacheTooLarge = errors.New("file is larger than the cache store")
+
+// CacheMeta is the metadata stored with each cache file
+type CacheMeta struct {
+	// The view the file was compiled from, relative to the Root dir and without the extention (ie: "blog/post")
+	Page string `json:"page"`
+
+	// Weather or not the file is static html (dynamic files still need to be compiled)
+	Static bool `json:"static"`
+
+	// The compression of the file ("br", "gz", or "" for uncompressed)
+	Encoding string `json:"encoding"`
+
+	// The base64 md5 checksum of the file content (may be empty if unknown)
+	CheckSum string `json:"checksum"`
+}
+
+// FileCacheStore stores cache files on the filesystem (default)
+//
+// the metadata is read from the file names, so files from an older version of turbx can still be used
+type FileCacheStore struct{}
+
+func (store FileCacheStore) Get(key string) ([]byte, CacheMeta, error) {
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return nil, CacheMeta{}, err
+	}
+
+	meta, _ := fileCacheMeta(key)
+	meta.CheckSum = checkSumMD5(data)
+	return data, meta, nil
+}
+
+func (store FileCacheStore) Put(key string, data []byte, meta CacheMeta) error {
+	return writeFileAtomic(key, data, 0775)
+}
+
+func (store FileCacheStore) Delete(key string) error {
+	return os.Remove(key)
+}
+
+func (store FileCacheStore) List() (map[string]CacheMeta, error) {
+	list := map[string]CacheMeta{}
+
+	for _, dir := range []string{compilerConfig.StaticHTML, compilerConfig.CacheDir} {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+
+			if key, err := goutil.FS.JoinPath(dir, file.Name()); err == nil {
+				if meta, ok := fileCacheMeta(key); ok {
+					list[key] = meta
+				}
+			}
+		}
+	}
+
+	return list, nil
+}
+
+// fileCacheMeta reads the metadata of a cache file from its path
+//
+// @bool: false if the file is not a cache file
+func fileCacheMeta(key string) (CacheMeta, bool) {
+	meta := CacheMeta{}
+
+	var fileName []byte
+	if dir, name := filepath.Split(key); filepath.Clean(dir) == compilerConfig.StaticHTML {
+		meta.Static = true
+		fileName = []byte(name)
+	} else if filepath.Clean(dir) == compilerConfig.CacheDir {
+		fileName = []byte(name)
+	} else {
+		return meta, false
+	}
+
+	if regex.Comp(`\.(%1)\.html\.br$`, compilerConfig.Ext).Match(fileName) {
+		meta.Encoding = "br"
+	} else if regex.Comp(`\.(%1)\.html\.gz$`, compilerConfig.Ext).Match(fileName) {
+		meta.Encoding = "gz"
+	}
+
+	if meta.Static && !regex.Comp(`\.(%1)(?:\.html(?:\.br|\.gz|)|\.cache\.md5sum)$`, compilerConfig.Ext).Match(fileName) {
+		return meta, false
+	} else if !meta.Static && !regex.Comp(`\.(%1)(?:\.html\.cache|\.cache\.md5sum)$`, compilerConfig.Ext).Match(fileName) {
+		return meta, false
+	}
+
+	fileName = regex.Comp(`\.(%1)(?:\.html(?:\.br|\.gz|\.cache|)|\.cache\.md5sum)$`, compilerConfig.Ext).RepStr(fileName, []byte{})
+	meta.Page = string(regex.Comp(`\._\.`).RepStr(fileName, []byte{'/'}))
+
+	return meta, true
+}
+
+// MemoryCacheStore stores cache files in memory, and removes the least recently used files when it runs out of space
+//
+// this allows hot templates to be served without touching the disk, and the compiler to run on a read-only filesystem
+type MemoryCacheStore struct {
+	maxSize int64
+	size    int64
+	list    *list.List
+	items   map[string]*list.Element
+	mu      sync.Mutex
+}
+
+type memoryCacheItem struct {
+	key  string
+	data []byte
+	meta CacheMeta
+}
+
+// NewMemoryCacheStore creates an in memory CacheStore
+//
+// @maxSize: the maximum size (in bytes) of all the files in the store (0 = no limit)
+func NewMemoryCacheStore(maxSize int64) *MemoryCacheStore {
+	if maxSize < 0 {
+		maxSize = 0
+	}
+
+	return &MemoryCacheStore{
+		maxSize: maxSize,
+		list:    list.New(),
+		items:   map[string]*list.Element{},
+	}
+}
+
+func (store *MemoryCacheStore) Get(key string) ([]byte, CacheMeta, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if elm, ok := store.items[key]; ok {
+		store.list.MoveToFront(elm)
+		item := elm.Value.(*memoryCacheItem)
+		return item.data, item.meta, nil
+	}
+	return nil, CacheMeta{}, os.ErrNotExist
+}
+
+func (store *MemoryCacheStore) Put(key string, data []byte, meta CacheMeta) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.maxSize != 0 && int64(len(data)) > store.maxSize {
+		// the old file is removed, so it cannot be served in place of the new one
+		if elm, ok := store.items[key]; ok {
+			store.list.Remove(elm)
+			delete(store.items, key)
+			store.size -= int64(len(elm.Value.(*memoryCacheItem).data))
+		}
+		return fmt.Errorf("%w: '%s'", ErrCacheTooLarge, key)
+	}
+
+	if elm, ok := store.items[key]; ok {
+		item := elm.Value.(*memoryCacheItem)
+		store.size += int64(len(data) - len(item.data))
+		item.data = data
+		item.meta = meta
+		store.list.MoveToFront(elm)
+	} else {
+		store.items[key] = store.list.PushFront(&memoryCacheItem{key: key, data: data, meta: meta})
+		store.size += int64(len(data))
+	}
+
+	// evict the least recently used files
+	for store.maxSize != 0 && store.size > store.maxSize {
+		elm := store.list.Back()
+		if elm == nil {
+			break
+		}
+
+		item := elm.Value.(*memoryCacheItem)
+		store.list.Remove(elm)
+		delete(store.items, item.key)
+		store.size -= int64(len(item.data))
+	}
+
+	return nil
+}
+
+func (store *MemoryCacheStore) Delete(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if elm, ok := store.items[key]; ok {
+		store.list.Remove(elm)
+		delete(store.items, elm.Value.(*memoryCacheItem).key)
+		store.size -= int64(len(elm.Value.(*memoryCacheItem).data))
+		return nil
+	}
+	return os.ErrNotExist
+}
+
+func (store *MemoryCacheStore) List() (map[string]CacheMeta, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	list := map[string]CacheMeta{}
+	for key, elm := range store.items {
+		list[key] = elm.Value.(*memoryCacheItem).meta
+	}
+	return list, nil
+}
+
+// Size returns the total size (in bytes) of the files in the store
+func (store *MemoryCacheStore) Size() int64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.size
+}
+
+// DirCacheStore stores cache files in a directory, with the metadata in a `.meta` file next to each one
+//
+// this can be used to share precompiled pages between multiple instances of an app (ie: a directory on a network mount)
+//
+// files are stored relative to the StaticHTML and CacheDir directories, so each instance can use different local paths
+type DirCacheStore struct {
+	dir string
+}
+
+// NewDirCacheStore creates a CacheStore in a directory
+func NewDirCacheStore(dir string) (*DirCacheStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sub := range []string{"static", "cache"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0775); err != nil {
+			return nil, err
+		}
+		removeTempFiles(filepath.Join(dir, sub))
+	}
+
+	return &DirCacheStore{dir: dir}, nil
+}
+
+// path returns the path of a key in the store directory
+func (store *DirCacheStore) path(key string) (string, error) {
+	for sub, dir := range map[string]string{"static": compilerConfig.StaticHTML, "cache": compilerConfig.CacheDir} {
+		if name, ok := strings.CutPrefix(key, dir+string(filepath.Separator)); ok {
+			return goutil.FS.JoinPath(store.dir, sub, name)
+		}
+	}
+	return "", errors.New("cache key is not in the StaticHTML or CacheDir directory: '" + key + "'")
+}
+
+func (store *DirCacheStore) Get(key string) ([]byte, CacheMeta, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, CacheMeta{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, CacheMeta{}, err
+	}
+
+	meta := CacheMeta{}
+	if buf, err := os.ReadFile(path + ".meta"); err == nil {
+		json.Unmarshal(buf, &meta)
+	}
+
+	return data, meta, nil
+}
+
+func (store *DirCacheStore) Put(key string, data []byte, meta CacheMeta) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, data, 0775); err != nil {
+		return err
+	}
+	return writeFileAtomic(path+".meta", buf, 0775)
+}
+
+func (store *DirCacheStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	os.Remove(path + ".meta")
+	return os.Remove(path)
+}
+
+func (store *DirCacheStore) List() (map[string]CacheMeta, error) {
+	list := map[string]CacheMeta{}
+
+	for sub, dir := range map[string]string{"static": compilerConfig.StaticHTML, "cache": compilerConfig.CacheDir} {
+		files, err := os.ReadDir(filepath.Join(store.dir, sub))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			name, ok := strings.CutSuffix(file.Name(), ".meta")
+			if file.IsDir() || !ok {
+				continue
+			}
+
+			buf, err := os.ReadFile(filepath.Join(store.dir, sub, file.Name()))
+			if err != nil {
+				continue
+			}
+
+			meta := CacheMeta{}
+			if json.Unmarshal(buf, &meta) == nil {
+				list[filepath.Join(dir, name)] = meta
+			}
+		}
+	}
+
+	return list, nil
+}
+
+// writeFileAtomic writes a file to a temp file in the same directory, and then renames it into place
+//
+// readers will either get the old file or the new one, and a crash cannot leave a partly written file behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// removeTempFiles removes the temp files left behind in a directory by writeFileAtomic (ie: if the app crashed during a write)
+//
+// only files older than an hour are removed, in case another instance is still writing to a shared directory
+func removeTempFiles(dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), ".") || !strings.HasSuffix(file.Name(), ".tmp") {
+			continue
+		}
+
+		if info, err := file.Info(); err == nil && time.Since(info.ModTime()) > time.Hour {
+			os.Remove(filepath.Join(dir, file.Name()))
+		}
+	}
+}
+
+// checkSumMD5 returns the base64 md5 checksum of some data (the same format as getCheckSumMD5)
+func checkSumMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// viewName returns the name of a view in the Root dir, without the extention (ie: "/views/blog/post.html" -> "blog/post")
+func viewName(path string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(path, compilerConfig.Root), "/"), "."+compilerConfig.Ext)
+}
+
+// cacheStorePath returns the key of a page in the CacheStore, without the file type (ie: "/html.static/blog._.post.html")
+func cacheStorePath(page string, static bool) (string, error) {
+	dir := compilerConfig.CacheDir
+	if static {
+		dir = compilerConfig.StaticHTML
+	}
+	return goutil.FS.JoinPath(dir, string(regex.Comp(`[\\\/]+`).RepStr([]byte(page), []byte{'.', '_', '.'}))+"."+compilerConfig.Ext)
+}
+
+// loadCacheStore adds the pages in the CacheStore to the htmlPreCache
+//
+// this includes pages precompiled by a previous run, and by other instances sharing the same store
+func loadCacheStore() {
+	list, err := compilerConfig.CacheStore.List()
+	if err != nil {
+		LogErr(err)
+		return
+	}
+
+	pages := map[string]cacheObj{}
+	for key, meta := range list {
+		if meta.Page == "" || strings.HasSuffix(key, ".cache.md5sum") {
+			continue
+		}
+
+		id := meta.Page
+		if meta.Static {
+			id = "static:" + id
+		}
+
+		cache := pages[id]
+		cache.static = meta.Static
+		cache.cachePath = append(cache.cachePath, key)
+		pages[id] = cache
+	}
+
+	for id, cache := range pages {
+		addCachePage(strings.TrimPrefix(id, "static:"), cache)
+	}
+}
+
+// loadCachePage adds a page to the htmlPreCache, if it was already precompiled into the CacheStore
+//
+// @bool: true if the page was found
+func loadCachePage(page string) bool {
+	for _, static := range []bool{true, false} {
+		storePath, err := cacheStorePath(page, static)
+		if err != nil {
+			return false
+		}
+
+		cache := cacheObj{static: static}
+		if static {
+			for _, ext := range []string{".html.br", ".html.gz", ".html"} {
+				if _, _, err := compilerConfig.CacheStore.Get(storePath + ext); err == nil {
+					cache.cachePath = append(cache.cachePath, storePath+ext)
+				}
+			}
+		} else if _, _, err := compilerConfig.CacheStore.Get(storePath + ".html.cache"); err == nil {
+			cache.cachePath = append(cache.cachePath, storePath+".html.cache")
+		}
+
+		if len(cache.cachePath) != 0 && addCachePage(page, cache) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addCachePage adds the cache files of a page to the htmlPreCache
+//
+// static files are skipped if the md5sum of the page (or one of its dependencies) has changed.
+// cache files are also skipped if they do not match the checksums in the md5sum file (ie: a write was interrupted),
+// so they are never served
+//
+// @bool: true if the page was added
+func addCachePage(page string, cache cacheObj) bool {
+	path, err := goutil.FS.JoinPath(compilerConfig.Root, page+"."+compilerConfig.Ext)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := htmlPreCache.Get(path); ok {
+		return false
+	}
+
+	// sort files by compression (br, gz, html)
+	sort.SliceStable(cache.cachePath, func(i, j int) bool {
+		return cacheEncodingOrder(cache.cachePath[i]) < cacheEncodingOrder(cache.cachePath[j])
+	})
+
+	sumPath := string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(cache.cachePath[0]), []byte(".cache.md5sum")))
+	sum, valid := readCacheSum(sumPath)
+	if sum == nil && !cache.static {
+		// the md5sum file may still be written by another instance sharing the CacheStore
+		return false
+	}
+
+	// the cache files need to match the checksums written by PreCompile
+	corrupt := false
+	cache.checksum = make([]string, len(cache.cachePath))
+	for i, file := range cache.cachePath {
+		if fileSum, err := cacheFileSum(file); err == nil && sum != nil && fileSum == sum.files[cacheFileExt(file)] {
+			cache.checksum[i] = fileSum
+		} else {
+			corrupt = true
+		}
+	}
+
+	// the files are not removed, since the CacheStore may be shared with instances that can still use them,
+	// and PreCompile will replace them when the page is compiled again
+	if corrupt || (cache.static && !valid) {
+		return false
+	}
+
+	// the dependencies of dynamic pages are only needed for invalidating the cache, so an old md5sum file is not removed here
+	cache.deps = sum.deps
+	cache.tags = sum.tags
+	cache.accessed = int(time.Now().UnixMilli() / 60000)
+	cache.modTime = sum.modTime
+	if cache.modTime.IsZero() {
+		cache.modTime = sourceModTime(append([]string{path}, sum.deps...))
+	}
+	cache.cacheTime = sum.cacheTime
+	cache.size = sum.size
+	if cache.size == 0 {
+		cache.size = cacheFileSize(cache.cachePath)
+	}
+
+	htmlPreCacheMU.Lock()
+	defer htmlPreCacheMU.Unlock()
+
+	// the page may have been compiled while the md5sum was read
+	if _, ok := htmlPreCache.Get(path); ok {
+		return false
+	}
+
+	htmlPreCache.Set(path, cache)
+	return true
+}
+
+// cacheFileExt returns the file type of a cache file (ie: ".html.br")
+func cacheFileExt(key string) string {
+	for _, ext := range []string{".html.br", ".html.gz", ".html.cache", ".html"} {
+		if strings.HasSuffix(key, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// cacheFileSum returns the base64 md5 checksum of a file in the CacheStore
+func cacheFileSum(key string) (string, error) {
+	if usesFileStore() {
+		sum, err := getCheckSumMD5(key)
+		return string(sum), err
+	}
+
+	data, _, err := compilerConfig.CacheStore.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return checkSumMD5(data), nil
+}
+
+// cacheEncodingOrder returns the order to list the cache files of a page in
+func cacheEncodingOrder(key string) int {
+	if strings.HasSuffix(key, ".html.br") {
+		return 0
+	} else if strings.HasSuffix(key, ".html.gz") {
+		return 1
+	}
+	return 2
+}
+
+// usesFileStore returns true if the CacheStore keeps cache files on the filesystem
+func usesFileStore() bool {
+	switch compilerConfig.CacheStore.(type) {
+	case FileCacheStore, *FileCacheStore:
+		return true
+	}
+	return false
+}
+
+// uncachedPage is returned by PreCompile when a page is too large for the CacheStore,
+// so the compiled output can still be served without caching it
+type uncachedPage struct {
+	html      []byte
+	static    bool
+	cachePath string
+}
+
+func (page *uncachedPage) Error() string {
+	return ErrCacheTooLarge.Error() + ": '" + page.cachePath + "'"
+}
+
+func (page *uncachedPage) Unwrap() error {
+	return ErrCacheTooLarge
+}
+
+// skipCache removes the old cache of a page that is too large for the CacheStore, and returns an uncachedPage error
+//
+// @path: the path of the view in the Root dir
+func skipCache(path string, html []byte, static bool, cachePath string) error {
+	if oldCache, ok := htmlPreCache.Get(path); ok {
+		purgePreCache(path, oldCache)
+	}
+
+	return &uncachedPage{html: html, static: static, cachePath: cachePath}
+}
+
+// compile compiles an uncached page for a request
+//
+// static pages only need to be compressed, and dynamic pages are compiled from memory
+func (page *uncachedPage) compile(options *map[string]interface{}, compType uint8) ([]byte, uint8, error) {
+	if !page.static {
+		res, _, resCompType, err := compileReader(&bytesReader{data: page.html}, page.cachePath, options, compType)
+		return res, resCompType, err
+	}
+
+	if compType == 1 {
+		if res, err := goutil.BROTLI.Zip(page.html, compilerConfig.Compress); err == nil {
+			return res, 1, nil
+		}
+	} else if compType == 2 {
+		if res, err := goutil.GZIP.Zip(page.html, compilerConfig.gzipCompress); err == nil {
+			return res, 2, nil
+		}
+	}
+
+	return page.html, 0, nil
+}
+
+// cacheReader is the part of a liveread.Reader used by the compile and Markdown methods
+type cacheReader interface {
+	Peek(size uint) ([]byte, error)
+	Get(start uint, size uint) ([]byte, error)
+	Discard(size uint) (int, error)
+	Save()
+	Restore()
+	RestoreReset()
+	DelSave()
+}
+
+// openCacheReader opens a cache file from the CacheStore
+//
+// files on the filesystem are streamed with liveread, and other stores are read from memory
+func openCacheReader(key string) (cacheReader, error) {
+	if usesFileStore() {
+		reader, err := liveread.Read[uint8](key)
+		if err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	data, _, err := compilerConfig.CacheStore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return &bytesReader{data: data}, nil
+}
+
+// bytesReader is a cacheReader for a file that is already in memory
+type bytesReader struct {
+	data []byte
+	pos  uint
+	save []uint
+}
+
+// get returns the bytes after the current position
+//
+// if the end of the file is reached, the result is padded with zeros and io.EOF is returned
+func (reader *bytesReader) get(start uint, size uint) ([]byte, error) {
+	start += reader.pos
+	if start >= uint(len(reader.data)) {
+		return []byte{}, io.EOF
+	}
+
+	if start+size > uint(len(reader.data)) {
+		b := make([]byte, size)
+		copy(b, reader.data[start:])
+		return b, io.EOF
+	}
+
+	return reader.data[start : start+size], nil
+}
+
+func (reader *bytesReader) Peek(size uint) ([]byte, error) {
+	return reader.get(0, size)
+}
+
+func (reader *bytesReader) Get(start uint, size uint) ([]byte, error) {
+	return reader.get(start, size)
+}
+
+func (reader *bytesReader) Discard(size uint) (int, error) {
+	if reader.pos+size > uint(len(reader.data)) {
+		n := len(reader.data) - int(reader.pos)
+		reader.pos = uint(len(reader.data))
+		return n, io.EOF
+	}
+
+	reader.pos += size
+	return int(size), nil
+}
+
+// Save adds the current position to the save list
+func (reader *bytesReader) Save() {
+	reader.save = append(reader.save, reader.pos)
+}
+
+// Restore moves back to the last saved position
+func (reader *bytesReader) Restore() {
+	if len(reader.save) != 0 {
+		reader.pos = reader.save[len(reader.save)-1]
+	}
+}
+
+// RestoreReset moves back to the last saved position
+//
+// a bytesReader does not need to reset its buffer, so this is the same as Restore
+func (reader *bytesReader) RestoreReset() {
+	reader.Restore()
+}
+
+// DelSave removes the last saved position
+func (reader *bytesReader) DelSave() {
+	if len(reader.save) != 0 {
+		reader.save = reader.save[:len(reader.save)-1]
+	}
+}
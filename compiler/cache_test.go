@@ -0,0 +1,371 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirCacheStore(t *testing.T) {
+	testConfig(t)
+
+	// a temp dir is used as a stand-in for a network mount shared by multiple instances
+	shared := t.TempDir()
+
+	// the first instance precompiles a page
+	compilerConfig.StaticHTML = filepath.Join(t.TempDir(), "html.static")
+	compilerConfig.CacheDir = filepath.Join(t.TempDir(), "html.cache")
+
+	store1, err := NewDirCacheStore(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html := []byte("<h1>Hello, World</h1>")
+	meta := CacheMeta{Page: "blog/post", Static: true, Encoding: "br", CheckSum: checkSumMD5(html)}
+	if err := store1.Put(filepath.Join(compilerConfig.StaticHTML, "blog._.post.html.html.br"), html, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store1.Put(filepath.Join(shared, "outside.html"), html, meta); err == nil {
+		t.Error("expected an error for a key outside of the cache directories")
+	}
+
+	// the second instance uses different local paths
+	compilerConfig.StaticHTML = filepath.Join(t.TempDir(), "html.static")
+	compilerConfig.CacheDir = filepath.Join(t.TempDir(), "html.cache")
+
+	store2, err := NewDirCacheStore(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := filepath.Join(compilerConfig.StaticHTML, "blog._.post.html.html.br")
+
+	list, err := store2.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[key] != meta {
+		t.Errorf("unexpected list: %v", list)
+	}
+
+	data, resMeta, err := store2.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, html) || resMeta != meta {
+		t.Errorf("unexpected file: %q %v", data, resMeta)
+	}
+
+	if err := store2.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store1.Get(key); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist after delete, got: %v", err)
+	}
+}
+
+func TestPurgePrefix(t *testing.T) {
+	testConfig(t)
+
+	store := NewMemoryCacheStore(0)
+	compilerConfig.CacheStore = store
+
+	for _, page := range []string{"blog/post", "blog/news/today", "blogroll", "about"} {
+		if err := store.Put("/html.static/"+page+".html.html", []byte(page), CacheMeta{Page: page, Static: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := PurgePrefix("blog/"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list["/html.static/blogroll.html.html"].Page != "blogroll" || list["/html.static/about.html.html"].Page != "about" {
+		t.Errorf("unexpected pages after purge: %v", list)
+	}
+
+	if err := Purge("about"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := store.List(); len(list) != 1 {
+		t.Errorf("unexpected pages after purge: %v", list)
+	}
+}
+
+func TestAddCachePageShared(t *testing.T) {
+	testConfig(t)
+
+	page := filepath.Join(compilerConfig.Root, "index.html")
+	if err := os.WriteFile(page, []byte("<h1>Hello</h1>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer htmlPreCache.Del(page)
+
+	html := []byte("<h1>Hello</h1>")
+	key := filepath.Join(compilerConfig.StaticHTML, "index.html.html")
+	sumPath := filepath.Join(compilerConfig.StaticHTML, "index.html.cache.md5sum")
+	if err := compilerConfig.CacheStore.Put(key, html, CacheMeta{Page: "index", Static: true, CheckSum: checkSumMD5(html)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCacheSum(page, cacheObj{cachePath: []string{key}, checksum: []string{checkSumMD5(html)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// another instance with a different version of the view does not load the page, or remove it from the shared store
+	if err := os.WriteFile(page, []byte("<h1>Changed</h1>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if addCachePage("index", cacheObj{static: true, cachePath: []string{key}}) {
+		t.Error("expected a page with a changed view not to be loaded")
+	}
+	for _, file := range []string{key, sumPath} {
+		if _, _, err := compilerConfig.CacheStore.Get(file); err != nil {
+			t.Errorf("expected %s to be kept in the shared store: %v", file, err)
+		}
+	}
+
+	if err := os.WriteFile(page, []byte("<h1>Hello</h1>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if !addCachePage("index", cacheObj{static: true, cachePath: []string{key}}) {
+		t.Error("expected the page to be loaded")
+	}
+}
+
+func TestMemoryCacheStoreMaxSize(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+
+	if err := store.Put("a", []byte("12345"), CacheMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("b", []byte("12345"), CacheMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the least recently used file is evicted
+	store.Get("a")
+	if err := store.Put("c", []byte("123"), CacheMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Get("b"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the least recently used file to be evicted: %v", err)
+	}
+
+	// a file that is too large removes the old version, so it is not served in place of the new one
+	if err := store.Put("a", []byte("12345678901"), CacheMeta{}); !errors.Is(err, ErrCacheTooLarge) {
+		t.Errorf("expected an error for a file larger than the store: %v", err)
+	}
+	if _, _, err := store.Get("a"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the old file to be removed: %v", err)
+	}
+	if store.size != 3 {
+		t.Errorf("unexpected store size: %d", store.size)
+	}
+}
+
+func TestCompileUncached(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.CacheStore = NewMemoryCacheStore(16)
+
+	views := map[string]string{
+		"static":  "<h1>A static page that is larger than the cache store</h1>",
+		"dynamic": "<h1>A dynamic page for {{name}}, that is larger than the cache store</h1>",
+	}
+	for name, src := range views {
+		page := filepath.Join(compilerConfig.Root, name+".html")
+		if err := os.WriteFile(page, []byte(src), 0775); err != nil {
+			t.Fatal(err)
+		}
+		defer htmlPreCache.Del(page)
+	}
+
+	// pages that are too large for the CacheStore are compiled without caching them
+	html, _, _, err := Compile("static", map[string]interface{}{"@layout": "null"})
+	if err != nil || string(html) != views["static"] {
+		t.Errorf("expected the static page to be served uncached: %s %v", html, err)
+	}
+
+	html, _, _, err = Compile("dynamic", map[string]interface{}{"@layout": "null", "name": "you"})
+	if err != nil || string(html) != "<h1>A dynamic page for you, that is larger than the cache store</h1>" {
+		t.Errorf("expected the dynamic page to be served uncached: %s %v", html, err)
+	}
+
+	if _, ok := htmlPreCache.Get(filepath.Join(compilerConfig.Root, "dynamic.html")); ok {
+		t.Error("expected the page not to be cached")
+	}
+
+	if err := PreCompile("static", map[string]interface{}{"@layout": "null"}); !errors.Is(err, ErrCacheTooLarge) {
+		t.Errorf("expected PreCompile to report that the page was not cached: %v", err)
+	}
+}
+
+// evictingCacheStore is a CacheStore that always evicts dynamic pages before they can be read
+type evictingCacheStore struct {
+	*MemoryCacheStore
+}
+
+func (store evictingCacheStore) Get(key string) ([]byte, CacheMeta, error) {
+	if strings.HasSuffix(key, ".html.cache") {
+		return nil, CacheMeta{}, os.ErrNotExist
+	}
+	return store.MemoryCacheStore.Get(key)
+}
+
+func TestCompileEvictedRetry(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.CacheStore = evictingCacheStore{NewMemoryCacheStore(0)}
+
+	page := filepath.Join(compilerConfig.Root, "index.html")
+	if err := os.WriteFile(page, []byte("<h1>{{name}}</h1>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer htmlPreCache.Del(page)
+
+	if err := PreCompile("index", map[string]interface{}{"@layout": "null"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the page is only precompiled again once, instead of retrying until the store keeps it
+	done := make(chan error)
+	go func() {
+		_, _, _, err := Compile("index", map[string]interface{}{"@layout": "null", "name": "you"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected an error for a page the store keeps evicting: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the compiler to stop retrying")
+	}
+}
+
+func TestAddCachePageMeta(t *testing.T) {
+	testConfig(t)
+
+	page := filepath.Join(compilerConfig.Root, "index.html")
+	if err := os.WriteFile(page, []byte("<h1>{{title}}</h1>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer htmlPreCache.Del(page)
+
+	html := []byte("<h1>{{title}}</h1>")
+	key := filepath.Join(compilerConfig.CacheDir, "index.html.html.cache")
+	if err := compilerConfig.CacheStore.Put(key, html, CacheMeta{Page: "index", CheckSum: checkSumMD5(html)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCacheSum(page, cacheObj{cachePath: []string{key}, checksum: []string{checkSumMD5(html)}, cacheTime: 5, size: int64(len(html))}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache time and size of the page are restored from the md5sum, since the size of a file in the store is unknown
+	if !addCachePage("index", cacheObj{cachePath: []string{key}}) {
+		t.Fatal("expected the page to be loaded")
+	}
+	if cache, _ := htmlPreCache.Get(page); cache.cacheTime != 5 || cache.size != int64(len(html)) {
+		t.Errorf("unexpected cache time and size: %d %d", cache.cacheTime, cache.size)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "page.html")
+
+	if err := writeFileAtomic(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new" {
+		t.Errorf("unexpected file: %q %v", data, err)
+	}
+	if stat, err := os.Stat(path); err != nil || stat.Mode().Perm() != 0644 {
+		t.Errorf("unexpected file mode: %v %v", stat, err)
+	}
+
+	// the temp file is renamed into place, so nothing is left behind
+	if files, err := os.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Errorf("expected only the written file: %v %v", files, err)
+	}
+
+	// a failed write returns an error
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "page.html"), []byte("new"), 0644); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+
+	// old temp files from an interrupted write are removed
+	tmp := filepath.Join(dir, ".page.html.123.tmp")
+	newTmp := filepath.Join(dir, ".page.html.456.tmp")
+	for _, file := range []string{tmp, newTmp} {
+		if err := os.WriteFile(file, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(tmp, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	removeTempFiles(dir)
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("expected the old temp file to be removed: %v", err)
+	}
+	if _, err := os.Stat(newTmp); err != nil {
+		t.Errorf("expected a recent temp file to be kept, in case it is still being written: %v", err)
+	}
+}
+
+func TestAddCachePageChecksum(t *testing.T) {
+	testConfig(t)
+
+	page := filepath.Join(compilerConfig.Root, "index.html")
+	if err := os.WriteFile(page, []byte("<h1>Hello</h1>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer htmlPreCache.Del(page)
+
+	html := []byte("<h1>Hello</h1>")
+	brKey := filepath.Join(compilerConfig.StaticHTML, "index.html.html.br")
+	key := filepath.Join(compilerConfig.StaticHTML, "index.html.html")
+	if err := writeCacheSum(page, cacheObj{cachePath: []string{brKey, key}, checksum: []string{checkSumMD5([]byte("br")), checkSumMD5(html)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file that does not match its checksum (ie: from an interrupted write) is never served
+	if err := compilerConfig.CacheStore.Put(brKey, []byte("b"), CacheMeta{Page: "index", Static: true, Encoding: "br"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := compilerConfig.CacheStore.Put(key, html, CacheMeta{Page: "index", Static: true}); err != nil {
+		t.Fatal(err)
+	}
+	if addCachePage("index", cacheObj{static: true, cachePath: []string{key, brKey}}) {
+		t.Error("expected a page with a corrupt cache file not to be loaded")
+	}
+
+	if err := compilerConfig.CacheStore.Put(brKey, []byte("br"), CacheMeta{Page: "index", Static: true, Encoding: "br"}); err != nil {
+		t.Fatal(err)
+	}
+	if !addCachePage("index", cacheObj{static: true, cachePath: []string{key, brKey}}) {
+		t.Fatal("expected the page to be loaded")
+	}
+
+	// the files are sorted by compression, with their checksums for ETags
+	if cache, _ := htmlPreCache.Get(page); len(cache.cachePath) != 2 || cache.cachePath[0] != brKey || cache.checksum[0] != checkSumMD5([]byte("br")) || cache.checksum[1] != checkSumMD5(html) {
+		t.Errorf("unexpected cache: %v %v", cache.cachePath, cache.checksum)
+	}
+}
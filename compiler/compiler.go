@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -26,7 +27,6 @@ import (
 	"github.com/bep/golibsass/libsass"
 	"github.com/kib357/less-go"
 	"github.com/tdewolff/minify/v2/minify"
-	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
 type Config struct {
@@ -56,11 +56,21 @@ type Config struct {
 	// A dir path to cache dynamic html files, for when the precompiler produces dynamically changing content
 	CacheDir string
 
+	// Where to store the precompiled html files (StaticHTML and CacheDir)
+	//
+	// NewMemoryCacheStore can be used to keep hot templates off the disk, or to run on a read-only filesystem
+	// default: FileCacheStore{}
+	CacheStore CacheStore
+
 	// Brotli compression level for precompressed static files (0-11)
 	//
 	// Gzip will be set to this number capped between 1-9 (if val > 6 {val -= 1})
 	PreCompress int
 
+	// The minimum size (in bytes) of a minified static file to precompress to .br and .gz files
+	// default: 1024
+	PreCompressMinSize int
+
 	// Brotli compression level for live compressed files (0-11)
 	//
 	// Gzip will be set to this number capped between 1-9 (if val > 6 {val -= 1})
@@ -73,13 +83,112 @@ type Config struct {
 	CompileMaxFlush uint
 
 	// Cache Time In Minutes
+	//
+	// pages that have not been accessed for this long are removed from the cache.
+	// a page can override this with the `@cacheTime` option (in minutes, or a duration string like "5m")
 	CacheTime int
 
+	// The maximum total size (in bytes) of the files in the StaticHTML and CacheDir directories
+	//
+	// the least recently used pages are removed when the cache goes over this size
+	// default: 0 (no limit)
+	CacheMaxSize int64
+
+	// The maximum total size (in bytes) of the fragments cached by `<_cache>` tags
+	//
+	// the least recently used fragments are removed when they go over this size.
+	// fragments also count towards the CacheMaxSize, and are removed before any pages are
+	// default: 16MB (-1 = no limit)
+	FragmentMaxSize int64
+
 	// Weather or not to include .md files along with the default Ext value.
 	//
 	// turbx will compile markdown regardless of whether or not it is in a .md file.
 	IncludeMD bool
 
+	// Weather or not to compile markdown in html files
+	//
+	// markdown will always compile in .md files.
+	// a file can override this with the `@markdown` option, and `<_md>` or `<_nomd>` blocks can enable or disable markdown for part of a file.
+	// default: true (nil)
+	MarkdownInHTML *bool
+
+	// Weather or not to run the syntax highlighter on fenced code blocks in markdown
+	//
+	// a themable `turbx-highlight.css` file will be added to the Static dir when enabled
+	HighlightCode bool
+
+	// Weather or not to add line numbers to highlighted code blocks
+	//
+	// a code block can also set this with `ln` or `nln` (ie: ```go {ln 3,5-7})
+	CodeLineNumbers bool
+
+	// File extentions to convert to .webp and .avif images (without the dot)
+	// default: ["png", "jpg", "jpeg", "gif", "bmp", "tiff", "tif"]
+	ImageExts []string
+
+	// File extentions to convert to .webm videos (without the dot)
+	// default: ["mp4", "mov", "mkv", "avi", "wmv", "flv", "m4v", "mpg", "mpeg"]
+	VideoExts []string
+
+	// File extentions to convert to .weba audio (without the dot)
+	// default: ["mp3", "wav", "ogg", "flac", "m4a", "aac", "wma", "aiff"]
+	AudioExts []string
+
+	// ffmpeg encoder settings for each output type ("webp", "avif", "webm", "weba", "poster")
+	//
+	// profiles are merged with the existing profiles by output type
+	MediaProfiles map[string]MediaProfile
+
+	// Image widths (in pixels) to create responsive .webp and .avif variants for
+	//
+	// local `<img>` tags will have a `srcset` and `sizes` added for these widths
+	// default: [320, 640, 1280]
+	ImageWidths []int
+
+	// Weather or not to wrap responsive images in a `<picture>` element with avif and webp sources
+	//
+	// by default, a webp `srcset` is added to the `<img>` tag instead
+	ImagePicture bool
+
+	// Weather or not to bundle static js and css files
+	//
+	// relative es module imports and local css `@import` rules will be inlined into the minified file of each entry (ie: app.js -> app.min.js)
+	Bundle bool
+
+	// Source maps to create for minified static files
+	//
+	// "off": no source maps
+	// "external": a `.map` file next to each `.min.*` file
+	// "inline": embed the source map into each `.min.*` file
+	// "debug": external source maps, only in DebugMode
+	//
+	// experimental: the minifier does not create source maps, so js, ts, and css maps are matched to the sources by their tokens,
+	// and may point to the wrong line when a token repeats. sass files use the source map from libsass (unless PruneCSS is enabled),
+	// and less files do not get a source map
+	// default: "off"
+	SourceMaps string
+
+	// Weather or not to remove unused css rules from minified css files
+	//
+	// the class names, ids, and tag names used by the views (and strings in static js files) are collected from the Root dir
+	PruneCSS bool
+
+	// Class names, ids, and tag names to keep when pruning css (for names added dynamically by js)
+	//
+	// a `*` can be used as a wildcard (ie: "modal-*")
+	CSSSafelist []string
+
+	// The maximum size (in bytes) of a css or js file to inline into a static page
+	//
+	// files are inlined with the `@inline` option, or with an `inline` arg on the `<link>` or `<script>` tag
+	// default: 4096
+	InlineMaxSize int
+
+	// The number of views to precompile at the same time with PrecompileAll
+	// default: the number of cpus
+	PrecompileWorkers int
+
 	// A folder level to consider a root domain, to prevent use of components outside a specific root folder
 	DomainFolder uint
 
@@ -94,6 +203,26 @@ type cacheObj struct {
 	cachePath []string
 	static    bool
 	accessed  int
+
+	// components, layouts, and markdown files the page was compiled from
+	deps []string
+
+	// md5 checksums of the cache files (in the same order as cachePath), for ETag headers
+	//
+	// an empty checksum has not been calculated yet
+	checksum []string
+
+	// the time the page was compiled, for Last-Modified headers
+	modTime time.Time
+
+	// the `@cacheTime` option of the page in minutes (0 = use the CacheTime config)
+	cacheTime int
+
+	// the total size of the cache files (0 if unknown)
+	size int64
+
+	// the tags added by the `@tags` option and `<_tag>` functions, for InvalidateTag
+	tags []string
 }
 
 var compilerConfig Config
@@ -152,6 +281,16 @@ func SetConfig(config Config) error {
 		compilerConfig.CacheDir = path
 	}
 
+	if config.CacheStore != nil {
+		// files from the old store cannot be read from the new one
+		// (InitDefault will add the cache files back from the filesystem)
+		htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+			htmlPreCache.Del(path)
+			return true
+		})
+		compilerConfig.CacheStore = config.CacheStore
+	}
+
 	if config.Ext != "" {
 		if strings.HasPrefix(config.Ext, ".") {
 			config.Ext = config.Ext[1:]
@@ -186,6 +325,13 @@ func SetConfig(config Config) error {
 		compilerConfig.gzipPreCompress = c
 	}
 
+	if config.PreCompressMinSize != 0 {
+		if config.PreCompressMinSize < 0 {
+			config.PreCompressMinSize = 0
+		}
+		compilerConfig.PreCompressMinSize = config.PreCompressMinSize
+	}
+
 	if config.Compress != 0 {
 		if config.Compress < 0 {
 			config.Compress = 0
@@ -213,6 +359,20 @@ func SetConfig(config Config) error {
 		compilerConfig.CacheTime = config.CacheTime
 	}
 
+	if config.CacheMaxSize != 0 {
+		if config.CacheMaxSize < 0 {
+			config.CacheMaxSize = 0
+		}
+		compilerConfig.CacheMaxSize = config.CacheMaxSize
+	}
+
+	if config.FragmentMaxSize != 0 {
+		if config.FragmentMaxSize < 0 {
+			config.FragmentMaxSize = -1
+		}
+		compilerConfig.FragmentMaxSize = config.FragmentMaxSize
+	}
+
 	compilerConfig.DebugMode = config.DebugMode
 
 	compilerConfig.CompileMaxFlush = config.CompileMaxFlush
@@ -221,6 +381,66 @@ func SetConfig(config Config) error {
 
 	compilerConfig.IncludeMD = config.IncludeMD
 
+	if config.MarkdownInHTML != nil {
+		markdownInHTML := *config.MarkdownInHTML
+		compilerConfig.MarkdownInHTML = &markdownInHTML
+	}
+
+	compilerConfig.HighlightCode = config.HighlightCode
+
+	compilerConfig.CodeLineNumbers = config.CodeLineNumbers
+
+	if len(config.ImageExts) != 0 {
+		compilerConfig.ImageExts = config.ImageExts
+		imageRE = mediaExtRE(config.ImageExts)
+	}
+
+	if len(config.VideoExts) != 0 {
+		compilerConfig.VideoExts = config.VideoExts
+		videoRE = mediaExtRE(config.VideoExts)
+	}
+
+	if len(config.AudioExts) != 0 {
+		compilerConfig.AudioExts = config.AudioExts
+		audioRE = mediaExtRE(config.AudioExts)
+	}
+
+	for outType, profile := range config.MediaProfiles {
+		compilerConfig.MediaProfiles[outType] = profile
+	}
+
+	if len(config.ImageWidths) != 0 {
+		compilerConfig.ImageWidths = config.ImageWidths
+	}
+
+	compilerConfig.ImagePicture = config.ImagePicture
+
+	compilerConfig.Bundle = config.Bundle
+
+	if config.SourceMaps != "" {
+		compilerConfig.SourceMaps = config.SourceMaps
+	}
+
+	compilerConfig.PruneCSS = config.PruneCSS
+
+	if config.InlineMaxSize != 0 {
+		if config.InlineMaxSize < 0 {
+			config.InlineMaxSize = 0
+		}
+		compilerConfig.InlineMaxSize = config.InlineMaxSize
+	}
+
+	if config.PrecompileWorkers != 0 {
+		if config.PrecompileWorkers < 0 {
+			config.PrecompileWorkers = 0
+		}
+		compilerConfig.PrecompileWorkers = config.PrecompileWorkers
+	}
+
+	if len(config.CSSSafelist) != 0 {
+		compilerConfig.CSSSafelist = config.CSSSafelist
+	}
+
 	if compilerConfig.RecursionLimit != 0 {
 		compilerConfig.RecursionLimit = config.RecursionLimit
 	}
@@ -235,112 +455,23 @@ func InitDefault() {
 	// ensure directories exist
 	os.MkdirAll(compilerConfig.Root, 0775)
 	os.MkdirAll(compilerConfig.Static, 0775)
-	os.MkdirAll(compilerConfig.StaticHTML, 0775)
-	os.MkdirAll(compilerConfig.CacheDir, 0775)
-
-	// add possible cache files to list
-	if files, err := os.ReadDir(compilerConfig.StaticHTML); err == nil {
-		for _, file := range files {
-			if !file.IsDir() {
-				fileName := []byte(file.Name())
-				if regex.Comp(`\.(%1)\.html(?:\.br|\.gz|)$`, compilerConfig.Ext).MatchRef(&fileName) {
-					fileName = regex.Comp(`\.(%1)\.html(?:\.br|\.gz|)$`, compilerConfig.Ext).RepStrCompRef(&fileName, []byte(".$1"))
-					origName := fileName
-					fileName = regex.Comp(`\._\.(?!%1$)`, compilerConfig.Ext).RepStrRef(&fileName, []byte{'/'})
-
-					if path, err := goutil.FS.JoinPath(compilerConfig.Root, string(fileName)); err == nil {
-						if _, ok := htmlPreCache.Get(path); ok {
-							continue
-						}
 
-						if staticPath, err := goutil.FS.JoinPath(compilerConfig.StaticHTML, string(origName)); err == nil {
-							cachePath := []string{}
-							if stat, err := os.Stat(staticPath + ".html.br"); err == nil && !stat.IsDir() {
-								cachePath = append(cachePath, staticPath+".html.br")
-							}
-							if stat, err := os.Stat(staticPath + ".html.gz"); err == nil && !stat.IsDir() {
-								cachePath = append(cachePath, staticPath+".html.gz")
-							}
-							if stat, err := os.Stat(staticPath + ".html"); err == nil && !stat.IsDir() {
-								cachePath = append(cachePath, staticPath+".html")
-							}
-
-							if len(cachePath) == 0 {
-								continue
-							}
-
-							sumPath := string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(cachePath[0]), []byte(".cache.md5sum")))
-							sum, err := os.ReadFile(sumPath)
-							if err != nil {
-								for _, cPath := range cachePath {
-									os.Remove(cPath)
-								}
-								os.Remove(sumPath)
-								continue
-							}
-
-							sumData := bytes.SplitN(sum, []byte{'\n'}, 2)
-
-							if resSum, err := getCheckSumMD5(string(sumData[0])); err != nil || !bytes.Equal(sumData[1], resSum) {
-								for _, cPath := range cachePath {
-									os.Remove(cPath)
-								}
-								os.Remove(sumPath)
-								continue
-							}
-
-							htmlPreCache.Set(path, cacheObj{
-								cachePath: cachePath,
-								static:    true,
-								accessed:  int(time.Now().UnixMilli() / 60000),
-							})
-						}
-					}
-				}
-			}
-		}
+	// other cache stores do not need the cache directories
+	if usesFileStore() {
+		os.MkdirAll(compilerConfig.StaticHTML, 0775)
+		os.MkdirAll(compilerConfig.CacheDir, 0775)
+		removeTempFiles(compilerConfig.StaticHTML)
+		removeTempFiles(compilerConfig.CacheDir)
 	}
 
-	if files, err := os.ReadDir(compilerConfig.CacheDir); err == nil {
-		for _, file := range files {
-			if !file.IsDir() {
-				fileName := []byte(file.Name())
-				if regex.Comp(`\.(%1)\.html\.cache$`, compilerConfig.Ext).MatchRef(&fileName) {
-					fileName = regex.Comp(`\.(%1)\.html\.cache$`, compilerConfig.Ext).RepStrCompRef(&fileName, []byte(".$1"))
-					origName := fileName
-					fileName = regex.Comp(`\._\.(?!%1$)`, compilerConfig.Ext).RepStrRef(&fileName, []byte{'/'})
-
-					if path, err := goutil.FS.JoinPath(compilerConfig.Root, string(fileName)); err == nil {
-						if _, ok := htmlPreCache.Get(path); ok {
-							continue
-						}
-
-						if staticPath, err := goutil.FS.JoinPath(compilerConfig.CacheDir, string(origName)); err == nil {
-							cachePath := []string{}
-							if stat, err := os.Stat(staticPath + ".html.cache"); err == nil && !stat.IsDir() {
-								cachePath = append(cachePath, staticPath+".html.cache")
-							}
-
-							if oldCache, ok := htmlPreCache.Get(path); ok {
-								for _, file := range oldCache.cachePath {
-									if oldCache.static && strings.HasPrefix(file, compilerConfig.StaticHTML) {
-										os.Remove(file)
-									}
-								}
-							}
+	// add possible cache files to list
+	loadCacheStore()
 
-							htmlPreCache.Set(path, cacheObj{
-								cachePath: cachePath,
-								static:    false,
-								accessed:  int(time.Now().UnixMilli() / 60000),
-							})
-						}
-					}
-				}
-			}
-		}
+	if compilerConfig.HighlightCode {
+		writeHighlightTheme()
 	}
 
+	loadStaticManifest()
 	tryMinifyDir(compilerConfig.Static)
 }
 
@@ -376,20 +507,19 @@ var emptyContentTags []tagData = []tagData{
 	{[]byte("iframe"), nil},
 }
 
-//todo: add more image, video, and audio file types
+// regex selector for files that can be minified (skips .min and content hashed files)
+var minifyRE *regex.Regexp = regex.Comp(`(?<!\.min)(?<!\.min\.[0-9a-f]{10})\.([jt]sx?|mts|css|less|s[ac]ss|svg)$`)
 
 // regex selectors for image, video, and audio files
-var imageRE *regex.Regexp = regex.Comp(`\.(png|jpe?g)$`)
-var videoRE *regex.Regexp = regex.Comp(`\.(mp4|mov)$`)
-var audioRE *regex.Regexp = regex.Comp(`\.(mp3|wav|ogg)$`)
+var imageRE *regex.Regexp = mediaExtRE(defaultImageExts)
+var videoRE *regex.Regexp = mediaExtRE(defaultVideoExts)
+var audioRE *regex.Regexp = mediaExtRE(defaultAudioExts)
 
 // regex to determine if a comment should be kept (for copyright or internet explore support)
 var keepCommentRE *regex.Regexp = regex.Comp(`(?i)(^\s*(?:\!|\([cr]\))|^\s*\[?[\w_\-\s]+]?\s*>.*<!\s*\[?[\w_\-\s]+\]?\s*$)`)
 
 var runningCompiler bool = true
 
-var cacheFileReloader chan []string
-
 func init() {
 	root, err := filepath.Abs("views")
 	if err != nil {
@@ -414,24 +544,26 @@ func init() {
 	cacheWatcher = goutil.FS.FileWatcher()
 	cacheWatcher.OnFileChange = func(path, op string) {
 		if data, ok := htmlPreCache.Get(path); ok {
-			htmlPreCache.Del(path)
-			for _, file := range data.cachePath {
-				if (data.static && strings.HasPrefix(file, compilerConfig.StaticHTML)) || (!data.static && strings.HasPrefix(file, compilerConfig.CacheDir)) {
-					os.Remove(file)
-				}
-			}
-			os.Remove(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(data.cachePath[0]), []byte(".cache.md5sum"))))
+			purgePreCache(path, data)
+		}
+
+		// remove pages that use this file as a component, layout, or markdown file
+		invalidateDependents(path)
+
+		if compilerConfig.PruneCSS && (strings.HasSuffix(path, "."+compilerConfig.Ext) || strings.HasSuffix(path, ".md")) {
+			updateUsedSelectors(path)
 		}
 	}
 	cacheWatcher.OnRemove = func(path, op string) bool {
 		if data, ok := htmlPreCache.Get(path); ok {
-			htmlPreCache.Del(path)
-			for _, file := range data.cachePath {
-				if (data.static && strings.HasPrefix(file, compilerConfig.StaticHTML)) || (!data.static && strings.HasPrefix(file, compilerConfig.CacheDir)) {
-					os.Remove(file)
-				}
-			}
-			os.Remove(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(data.cachePath[0]), []byte(".cache.md5sum"))))
+			purgePreCache(path, data)
+		}
+
+		// remove pages that use this file as a component, layout, or markdown file
+		invalidateDependents(path)
+
+		if compilerConfig.PruneCSS && (strings.HasSuffix(path, "."+compilerConfig.Ext) || strings.HasSuffix(path, ".md")) {
+			updateUsedSelectors(path)
 		}
 		return true
 	}
@@ -440,16 +572,31 @@ func init() {
 
 	staticWatcher = goutil.FS.FileWatcher()
 	staticWatcher.OnFileChange = func(path, op string) {
-		if regex.Comp(`(?<!\.min)\.([jt]s|css|less|s[ac]ss)$`).Match([]byte(path)) || imageRE.Match([]byte(path)) || videoRE.Match([]byte(path)) || audioRE.Match([]byte(path)) {
+		if minifyRE.Match([]byte(path)) || imageRE.Match([]byte(path)) || videoRE.Match([]byte(path)) || audioRE.Match([]byte(path)) {
 			staticChangeQueue.Set(path, time.Now().UnixMilli())
 		}
 	}
 	staticWatcher.OnRemove = func(path, op string) (removeWatcher bool) {
-		if regex.Comp(`(?<!\.min)\.([jt]s|css|less|s[ac]ss)$`).Match([]byte(path)) {
+		if minifyRE.Match([]byte(path)) {
+			staticChangeQueue.Del(path)
+			removeStaticFile(minifiedPath(path))
+			removeStaticFile(minifiedPath(path) + ".map")
+			removeHashedAsset(path)
+			bundleDeps.Del(path)
+			rebuildBundles(path)
+
+			if compilerConfig.PruneCSS && regex.Comp(`\.([jt]sx?|mts)$`).Match([]byte(path)) {
+				updateUsedSelectors(path)
+			}
+		} else if imageRE.Match([]byte(path)) {
 			staticChangeQueue.Del(path)
-			os.Remove(string(regex.Comp(`(?<!\.min)\.([jt]s|css|less|s[ac]ss)$`).RepStrComp([]byte(path), []byte(".min.$1"))))
-		} else if imageRE.Match([]byte(path)) || videoRE.Match([]byte(path)) || audioRE.Match([]byte(path)) {
+			removeImageVariants(path)
+		} else if videoRE.Match([]byte(path)) {
 			staticChangeQueue.Del(path)
+			removeVideoVariants(path)
+		} else if audioRE.Match([]byte(path)) {
+			staticChangeQueue.Del(path)
+			removeHashedAsset(path)
 		}
 		return true
 	}
@@ -457,24 +604,39 @@ func init() {
 	staticWatcher.WatchDir(static)
 
 	compilerConfig = Config{
-		Root:            root,
-		Ext:             "html",
-		Static:          static,
-		StaticUrl:       "",
-		StaticHTML:      staticHTML,
-		CacheDir:        cacheDir,
-		PreCompress:     7,
-		Compress:        5,
-		gzipPreCompress: 6,
-		gzipCompress:    5,
-		CompileMaxFlush: 100,
-		CacheTime:       120, // minutes: 2 hours
-		DomainFolder:    0,
-		RecursionLimit:  100,
-		DebugMode:       false,
-	}
-
-	cacheFileReloader = make(chan []string)
+		Root:               root,
+		Ext:                "html",
+		Static:             static,
+		StaticUrl:          "",
+		StaticHTML:         staticHTML,
+		CacheDir:           cacheDir,
+		CacheStore:         FileCacheStore{},
+		PreCompress:        7,
+		PreCompressMinSize: 1024,
+		Compress:           5,
+		gzipPreCompress:    6,
+		gzipCompress:       5,
+		CompileMaxFlush:    100,
+		CacheTime:          120, // minutes: 2 hours
+		FragmentMaxSize:    16 * 1024 * 1024,
+		DomainFolder:       0,
+		SourceMaps:         SourceMapsOff,
+		InlineMaxSize:      4096,
+		PrecompileWorkers:  runtime.NumCPU(),
+		RecursionLimit:     100,
+		ImageWidths:        []int{320, 640, 1280},
+		ImageExts:          defaultImageExts,
+		VideoExts:          defaultVideoExts,
+		AudioExts:          defaultAudioExts,
+		MediaProfiles: map[string]MediaProfile{
+			"webp":   {Quality: 80},
+			"avif":   {Codec: "libaom-av1", Quality: 30},
+			"webm":   {Codec: "libvpx-vp9", Quality: 32, Bitrate: "0"},
+			"weba":   {Codec: "libopus", Bitrate: "128k"},
+			"poster": {Quality: 80},
+		},
+		DebugMode: false,
+	}
 
 	// clear cache items as needed
 	go func() {
@@ -487,28 +649,17 @@ func init() {
 				break
 			}
 
-			if compilerConfig.CacheTime == 0 {
-				continue
-			}
-
+			// run once per minute
 			now := int(time.Now().UnixMilli() / 60000)
-			if now-lastRun < 10 {
+			if now == lastRun {
 				continue
 			}
 			lastRun = now
 
-			htmlPreCache.ForEach(func(path string, data cacheObj) bool {
-				if now-data.accessed > compilerConfig.CacheTime {
-					htmlPreCache.Del(path)
-					for _, file := range data.cachePath {
-						if (data.static && strings.HasPrefix(file, compilerConfig.StaticHTML)) || (!data.static && strings.HasPrefix(file, compilerConfig.CacheDir)) {
-							os.Remove(file)
-						}
-					}
-					os.Remove(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(data.cachePath[0]), []byte(".cache.md5sum"))))
-				}
-				return true
-			})
+			expirePreCache(now)
+			expireFragments(time.Now())
+			expireHashedFiles(now)
+			limitCacheSize("")
 		}
 	}()
 
@@ -526,37 +677,22 @@ func init() {
 				if now-modified > 1000 {
 					staticChangeQueue.Del(path)
 					tryMinifyFile(path)
+					rebuildBundles(path)
+
+					if compilerConfig.PruneCSS && regex.Comp(`\.([jt]sx?|mts)$`).Match([]byte(path)) {
+						updateUsedSelectors(path)
+					}
 				}
 				return true
 			})
 		}
 	}()
-
-	go func() {
-		for {
-			data := <- cacheFileReloader
-			if data == nil {
-				break
-			}
-
-			if regex.Comp(`[\r\n]`).Match([]byte(data[0])) {
-				continue
-			}
-
-			data[1] = string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(data[1]), []byte(".cache.md5sum")))
-
-			if sum, err := getCheckSumMD5(data[0]); err == nil {
-				os.WriteFile(data[1], regex.JoinBytes([]byte(data[0]), '\n', sum), 0775)
-			}
-		}
-	}()
 }
 
 func Close() {
 	runningCompiler = false
 	cacheWatcher.CloseWatcher("*")
 	staticWatcher.CloseWatcher("*")
-	cacheFileReloader <-nil
 }
 
 func LogErr(err error) {
@@ -610,6 +746,8 @@ type handleHtmlData struct {
 
 	localRoot *string
 
+	deps *pageDeps
+
 	stopChan bool
 }
 
@@ -633,12 +771,27 @@ type handleHtmlData struct {
 //
 // note: putting any extra '.' in a filename (apart from the extention name) may cause conflicts with restoring old cache files
 func Compile(path string, opts map[string]interface{}) ([]byte, string, uint8, error) {
+	res, staticPath, compType, _, err := CompileMeta(path, opts)
+	return res, staticPath, compType, err
+}
+
+// CompileMeta is the same as Compile, and also returns the Metadata of the result
+//
+// the Metadata can be passed to the NotModified method to handle conditional requests (ETag and Last-Modified headers)
+func CompileMeta(path string, opts map[string]interface{}) ([]byte, string, uint8, Metadata, error) {
+	return compileMeta(path, opts, true)
+}
+
+// compileMeta runs the CompileMeta method
+//
+// @retry: weather or not to precompile the page again if it was evicted from the CacheStore while compiling it
+func compileMeta(path string, opts map[string]interface{}, retry bool) ([]byte, string, uint8, Metadata, error) {
 	origPath := path
 
 	path, err := goutil.FS.JoinPath(compilerConfig.Root, path+"."+compilerConfig.Ext)
 	if err != nil {
 		LogErr(err)
-		return []byte{}, "", 0, err
+		return []byte{}, "", 0, Metadata{}, err
 	}
 
 	if opts == nil {
@@ -667,50 +820,118 @@ func Compile(path string, opts map[string]interface{}) ([]byte, string, uint8, e
 	}
 
 	var filePath string
+	fromCache := false
 
 	// get precompiled file from cache
 	if useCache {
 		if cache, ok := htmlPreCache.Get(path); ok {
 			if len(cache.cachePath) == 0 {
-				return []byte{}, "", 0, errors.New("cache does not contain any paths for this file")
+				return []byte{}, "", 0, Metadata{}, errors.New("cache does not contain any paths for this file")
 			}
 
-			if cache.static {
-				return getStaticPath(cache, compressRes)
-			} else {
+			if !cache.static {
 				filePath = cache.cachePath[0]
+				fromCache = true
+			} else if res, staticPath, resCompType, err := getStaticPath(cache, compressRes); !errors.Is(err, os.ErrNotExist) {
+				cacheHits.Add(1)
+				touchPreCache(path, cache)
+				return withMetadata(path, cache, res, staticPath, resCompType, err)
+			} else {
+				// the page was evicted from the CacheStore
+				purgePreCache(path, cache)
+				cacheEvictions.Add(1)
 			}
 		}
 	}
 
 	// precompile file if needed
 	if filePath == "" {
-		err := PreCompile(origPath, opts)
-		if err != nil {
-			return []byte{}, "", 0, err
+		// another instance sharing the CacheStore may have already precompiled the file
+		if !useCache || !loadCachePage(viewName(path)) {
+			// concurrent requests for the same page share a single PreCompile
+			shared, err := precompileOnce(origPath, opts)
+			if shared {
+				cacheHits.Add(1)
+			} else {
+				cacheMisses.Add(1)
+			}
+
+			// pages that are too large for the CacheStore are served without caching them
+			var uncached *uncachedPage
+			if errors.As(err, &uncached) {
+				res, resCompType, err := uncached.compile(&opts, compType)
+				return withMetadata(path, cacheObj{static: uncached.static, modTime: time.Now()}, res, "", resCompType, err)
+			}
+
+			if err != nil {
+				return []byte{}, "", 0, Metadata{}, err
+			}
+		} else {
+			cacheHits.Add(1)
 		}
 
 		if cache, ok := htmlPreCache.Get(path); ok {
 			if cache.static {
-				return getStaticPath(cache, compressRes)
+				res, staticPath, resCompType, err := getStaticPath(cache, compressRes)
+				return withMetadata(path, cache, res, staticPath, resCompType, err)
 			} else {
 				filePath = cache.cachePath[0]
 			}
 		} else {
-			return []byte{}, "", 0, errors.New("failed to precompile file")
+			return []byte{}, "", 0, Metadata{}, errors.New("failed to precompile file")
+		}
+	}
+
+	// the compiler may change the options, so the page is compiled again with a copy
+	var retryOpts map[string]interface{}
+	if fromCache && retry {
+		retryOpts = make(map[string]interface{}, len(opts))
+		for k, v := range opts {
+			retryOpts[k] = v
+		}
+	}
+
+	res, staticPath, resCompType, err := compile(filePath, &opts, compType)
+	if fromCache && errors.Is(err, os.ErrNotExist) {
+		// the page was evicted from the CacheStore, so it needs to be precompiled again
+		if cache, ok := htmlPreCache.Get(path); ok {
+			purgePreCache(path, cache)
+			cacheEvictions.Add(1)
+		}
+
+		// only retry once, in case the store keeps evicting the page
+		if retry {
+			return compileMeta(origPath, retryOpts, false)
 		}
+		return []byte{}, "", 0, Metadata{}, err
 	}
 
-	return compile(filePath, &opts, compType)
+	if cache, ok := htmlPreCache.Get(path); ok {
+		if fromCache {
+			cacheHits.Add(1)
+			touchPreCache(path, cache)
+		}
+		return withMetadata(path, cache, res, staticPath, resCompType, err)
+	}
+	return res, staticPath, resCompType, Metadata{}, err
 }
 
 func compile(path string, options *map[string]interface{}, compType uint8) ([]byte, string, uint8, error) {
 	// compile file
-	reader, err := liveread.Read[uint8](path)
+	reader, err := openCacheReader(path)
 	if err != nil {
 		return []byte{}, "", 0, err
 	}
 
+	return compileReader(reader, path, options, compType)
+}
+
+// compileReader compiles a precompiled page
+//
+// @path: the cache path of the page (used for the keys of cached fragments)
+func compileReader(reader cacheReader, path string, options *map[string]interface{}, compType uint8) ([]byte, string, uint8, error) {
+	var err error
+
 	htmlContTemp := [][]byte{}
 	htmlContTempTag := []htmlArgs{}
 
@@ -1176,6 +1397,59 @@ func compile(path string, options *map[string]interface{}, compType uint8) ([]by
 													}
 												}
 											}
+										} else if bytes.Equal(args.tag, []byte("tag")) {
+											// add the tags to the fragments of the cache tags this is inside of
+											if tags, _ := tagArgs(&args, options, &eachArgsList, false); len(tags) != 0 {
+												for _, cacheTag := range htmlContTempTag {
+													if bytes.Equal(cacheTag.tag, []byte("Cache")) {
+														cacheTag.args["tags"] = joinTags(mergeTags(splitTags(bytes.TrimPrefix(cacheTag.args["tags"], []byte{0})), tags))
+													}
+												}
+											}
+										} else if bytes.Equal(args.tag, []byte("cache")) && args.close == 3 && len(args.args["key"]) > 1 && args.args["key"][0] == 0 {
+											key := fragmentKey(path, args.args["key"][1:], options, &eachArgsList)
+
+											if frag, ok := getFragment(key); ok {
+												write(frag)
+
+												// skip cache content and the closing cache tag
+												ib, ie := reader.Peek(2)
+												cacheLevel := 0
+												for ie == nil {
+													if ib[0] == '{' && ib[1] == '{' {
+														ibTag, _ := reader.Peek(11)
+														ibTag = bytes.ToLower(ibTag)
+														if regex.Comp(`^\{\{\{?%/cache[\s/\}]`).MatchRef(&ibTag) {
+															if cacheLevel == 0 {
+																for ie == nil && !(ib[0] == '}' && ib[1] == '}') {
+																	reader.Discard(1)
+																	ib, ie = reader.Peek(2)
+																}
+																reader.Discard(2)
+																if ib, ie = reader.Peek(1); ie == nil && ib[0] == '}' {
+																	reader.Discard(1)
+																}
+																break
+															}
+															cacheLevel--
+														} else if regex.Comp(`^\{\{\{?%cache[\s/\}]`).MatchRef(&ibTag) {
+															cacheLevel++
+														}
+													}
+
+													reader.Discard(1)
+													ib, ie = reader.Peek(2)
+												}
+											} else {
+												tags, _ := tagValue(args.args["tags"], options, &eachArgsList, false)
+
+												// the Cache tag func stores the content when the tag closes
+												args.tag = []byte("Cache")
+												args.args["key"] = append([]byte{0}, key...)
+												args.args["tags"] = joinTags(tags)
+												htmlContTempTag = append(htmlContTempTag, args)
+												htmlContTemp = append(htmlContTemp, []byte{})
+											}
 										} else {
 											args.tag[0] = bytes.ToUpper([]byte{args.tag[0]})[0]
 
@@ -1441,11 +1715,29 @@ func compile(path string, options *map[string]interface{}, compType uint8) ([]by
 	return res.Bytes(), "", compType, nil
 }
 
+// getStaticPath returns the path to a static html file
+//
+// if the CacheStore is not on the filesystem, the file content is returned instead
 func getStaticPath(cache cacheObj, compressRes []string) ([]byte, string, uint8, error) {
+	fileStore := usesFileStore()
+
+	// getFile returns the path or the content of a cache file
+	getFile := func(p string, compType uint8) ([]byte, string, uint8, error) {
+		if fileStore {
+			return []byte{}, p, compType, nil
+		}
+
+		file, _, err := compilerConfig.CacheStore.Get(p)
+		if err != nil {
+			return []byte{}, "", 0, err
+		}
+		return file, "", compType, nil
+	}
+
 	if goutil.Contains(compressRes, "br") {
 		for _, p := range cache.cachePath {
 			if strings.HasSuffix(p, ".html.br") {
-				return []byte{}, p, 1, nil
+				return getFile(p, 1)
 			}
 		}
 	}
@@ -1453,19 +1745,19 @@ func getStaticPath(cache cacheObj, compressRes []string) ([]byte, string, uint8,
 	if goutil.Contains(compressRes, "gz") {
 		for _, p := range cache.cachePath {
 			if strings.HasSuffix(p, ".html.gz") {
-				return []byte{}, p, 2, nil
+				return getFile(p, 2)
 			}
 		}
 	}
 
 	for _, p := range cache.cachePath {
 		if strings.HasSuffix(p, ".html") {
-			return []byte{}, p, 0, nil
+			return getFile(p, 0)
 		}
 	}
 
 	p := cache.cachePath[0]
-	file, err := os.ReadFile(p)
+	file, _, err := compilerConfig.CacheStore.Get(p)
 	if err != nil {
 		return []byte{}, "", 0, err
 	}
@@ -1558,7 +1850,8 @@ func PreCompile(path string, opts map[string]interface{}) error {
 	htmlChan := newPreCompileChan()
 
 	html := []byte{0}
-	preCompile(path, &opts, &htmlArgs{}, &html, &err, &htmlChan, nil, nil, nil)
+	deps := &pageDeps{}
+	preCompile(path, &opts, &htmlArgs{}, &html, &err, &htmlChan, nil, nil, nil, deps)
 	if err != nil || len(html) == 0 || html[0] == 2 {
 		if err == nil {
 			err = errors.New("failed to precompile: '" + path + "'")
@@ -1573,6 +1866,7 @@ func PreCompile(path string, opts map[string]interface{}) error {
 
 	resType := html[0]
 	html = html[1:]
+	body := html
 
 	// get layout and merge with html
 	layoutPath := "layout"
@@ -1622,7 +1916,7 @@ func PreCompile(path string, opts map[string]interface{}) error {
 		if stat, err := os.Stat(layoutPath); err == nil && !stat.IsDir() {
 			opts["$body"] = html
 			html = []byte{0}
-			preCompile(layoutPath, &opts, &htmlArgs{}, &html, &err, nil, nil, nil, nil)
+			preCompile(layoutPath, &opts, &htmlArgs{}, &html, &err, nil, nil, nil, nil, deps)
 			if err != nil || len(html) == 0 || html[0] == 2 {
 				if err == nil {
 					err = errors.New("layout - failed to precompile: '" + path + "'")
@@ -1640,14 +1934,23 @@ func PreCompile(path string, opts map[string]interface{}) error {
 				resType = 1
 			}
 			html = html[1:]
+
+			// preload the assets used by the page
+			html = addPreloadHints(html, body)
 		}
 	}
 
-	origPath = string(regex.Comp(`[\\\/]+`).RepStr([]byte(origPath), []byte{'.', '_', '.'}))
+	// inline local css and js files into static pages (unless in debug mode)
+	if resType == 3 && !compilerConfig.DebugMode {
+		html = inlineAssets(html, body, origPath, opts)
+	}
+
+	page := viewName(origCachePath)
+	tags := mergeTags(optTags(opts), deps.tagList())
 
 	if resType == 3 {
 		// create static html file
-		staticPath, err := goutil.FS.JoinPath(compilerConfig.StaticHTML, origPath+"."+compilerConfig.Ext)
+		staticPath, err := cacheStorePath(page, true)
 		if err != nil {
 			if compilerConfig.DebugMode {
 				LogErr(err)
@@ -1657,20 +1960,33 @@ func PreCompile(path string, opts map[string]interface{}) error {
 		}
 
 		cachePath := []string{}
+		checksum := []string{}
+		var size int64
 		if br, err := goutil.BROTLI.Zip(html, compilerConfig.PreCompress); err == nil {
-			if err := os.WriteFile(staticPath+".html.br", br, 0775); err == nil {
+			sum := checkSumMD5(br)
+			if err := compilerConfig.CacheStore.Put(staticPath+".html.br", br, CacheMeta{Page: page, Static: true, Encoding: "br", CheckSum: sum}); err == nil {
 				cachePath = append(cachePath, staticPath+".html.br")
+				checksum = append(checksum, sum)
+				size += int64(len(br))
 			}
 		}
 
 		if gz, err := goutil.GZIP.Zip(html, compilerConfig.gzipPreCompress); err == nil {
-			if err := os.WriteFile(staticPath+".html.gz", gz, 0775); err == nil {
+			sum := checkSumMD5(gz)
+			if err := compilerConfig.CacheStore.Put(staticPath+".html.gz", gz, CacheMeta{Page: page, Static: true, Encoding: "gz", CheckSum: sum}); err == nil {
 				cachePath = append(cachePath, staticPath+".html.gz")
+				checksum = append(checksum, sum)
+				size += int64(len(gz))
 			}
 		}
 
 		if len(cachePath) == 0 {
-			if err = os.WriteFile(staticPath+".html", html, 0775); err != nil {
+			sum := checkSumMD5(html)
+			checksum = append(checksum, sum)
+			size += int64(len(html))
+			if err = compilerConfig.CacheStore.Put(staticPath+".html", html, CacheMeta{Page: page, Static: true, CheckSum: sum}); errors.Is(err, ErrCacheTooLarge) {
+				return skipCache(origCachePath, html, true, staticPath+".html")
+			} else if err != nil {
 				if compilerConfig.DebugMode {
 					LogErr(err)
 					html = append(html, regex.JoinBytes([]byte("<!--{{#error: "), regex.Comp(`%1`, compilerConfig.Root).RepStr([]byte(err.Error()), []byte{}), []byte("}}-->"))...)
@@ -1685,23 +2001,34 @@ func PreCompile(path string, opts map[string]interface{}) error {
 			if oldCache, ok := htmlPreCache.Get(origCachePath); ok {
 				for _, file := range oldCache.cachePath {
 					if !oldCache.static && strings.HasPrefix(file, compilerConfig.CacheDir) {
-						os.Remove(file)
+						compilerConfig.CacheStore.Delete(file)
 					}
 				}
-				os.Remove(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(oldCache.cachePath[0]), []byte(".cache.md5sum"))))
+				compilerConfig.CacheStore.Delete(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(oldCache.cachePath[0]), []byte(".cache.md5sum"))))
+				purgeFragments(oldCache.cachePath)
 			}
 
-			htmlPreCache.Set(origCachePath, cacheObj{
+			cache := cacheObj{
 				cachePath: cachePath,
 				static:    true,
 				accessed:  int(time.Now().UnixMilli() / 60000),
-			})
+				deps:      deps.list(origCachePath),
+				checksum:  checksum,
+				modTime:   time.Now(),
+				cacheTime: optCacheTime(opts),
+				size:      size,
+				tags:      tags,
+			}
+			setPreCache(origCachePath, cache)
+			limitCacheSize(origCachePath)
 
-			cacheFileReloader <-[]string{origCachePath, cachePath[0]}
+			if err := writeCacheSum(origCachePath, cache); err != nil {
+				LogErr(err)
+			}
 		}
 	} else {
 		// cache dynamic html file
-		staticPath, err := goutil.FS.JoinPath(compilerConfig.CacheDir, origPath+"."+compilerConfig.Ext)
+		staticPath, err := cacheStorePath(page, false)
 		if err != nil {
 			if compilerConfig.DebugMode {
 				LogErr(err)
@@ -1712,7 +2039,10 @@ func PreCompile(path string, opts map[string]interface{}) error {
 
 		cachePath := []string{}
 
-		if err = os.WriteFile(staticPath+".html.cache", html, 0775); err != nil {
+		sum := checkSumMD5(html)
+		if err = compilerConfig.CacheStore.Put(staticPath+".html.cache", html, CacheMeta{Page: page, CheckSum: sum}); errors.Is(err, ErrCacheTooLarge) {
+			return skipCache(origCachePath, html, false, staticPath+".html.cache")
+		} else if err != nil {
 			if compilerConfig.DebugMode {
 				LogErr(err)
 				html = append(html, regex.JoinBytes([]byte("<!--{{#error: "), regex.Comp(`%1`, compilerConfig.Root).RepStr([]byte(err.Error()), []byte{}), []byte("}}-->"))...)
@@ -1726,26 +2056,39 @@ func PreCompile(path string, opts map[string]interface{}) error {
 			if oldCache, ok := htmlPreCache.Get(origCachePath); ok {
 				for _, file := range oldCache.cachePath {
 					if oldCache.static && strings.HasPrefix(file, compilerConfig.StaticHTML) {
-						os.Remove(file)
+						compilerConfig.CacheStore.Delete(file)
 					}
 				}
-				os.Remove(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(oldCache.cachePath[0]), []byte(".cache.md5sum"))))
+				compilerConfig.CacheStore.Delete(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(oldCache.cachePath[0]), []byte(".cache.md5sum"))))
+				purgeFragments(oldCache.cachePath)
 			}
 
-			htmlPreCache.Set(origCachePath, cacheObj{
+			cache := cacheObj{
 				cachePath: cachePath,
 				static:    false,
 				accessed:  int(time.Now().UnixMilli() / 60000),
-			})
+				deps:      deps.list(origCachePath),
+				checksum:  []string{sum},
+				modTime:   time.Now(),
+				cacheTime: optCacheTime(opts),
+				size:      int64(len(html)),
+				tags:      tags,
+			}
+			setPreCache(origCachePath, cache)
+			limitCacheSize(origCachePath)
 
-			cacheFileReloader <-[]string{origCachePath, cachePath[0]}
+			if err := writeCacheSum(origCachePath, cache); err != nil {
+				LogErr(err)
+			}
 		}
 	}
 
 	return nil
 }
 
-func preCompile(path string, options *map[string]interface{}, arguments *htmlArgs, html *[]byte, compileError *error, htmlChan *htmlChanList, eachArgsList []EachArgs, componentList [][]byte, componentRecursionList map[string]uint) {
+func preCompile(path string, options *map[string]interface{}, arguments *htmlArgs, html *[]byte, compileError *error, htmlChan *htmlChanList, eachArgsList []EachArgs, componentList [][]byte, componentRecursionList map[string]uint, deps *pageDeps) {
+	deps.add(path)
+
 	reader, err := liveread.Read[uint8](path)
 	if err != nil {
 		*compileError = err
@@ -1931,6 +2274,26 @@ func preCompile(path string, options *map[string]interface{}, arguments *htmlArg
 		}
 	}
 
+	// markdown always runs in .md files, and runs in html files unless disabled
+	// <_md> and <_nomd> blocks can enable or disable markdown for part of a file
+	useMarkdown := []bool{strings.HasSuffix(path, ".md") || compilerConfig.MarkdownInHTML == nil || *compilerConfig.MarkdownInHTML}
+	if val, ok := (*options)["@markdown"]; ok && reflect.TypeOf(val) == goutil.VarType["bool"] {
+		useMarkdown[0] = val.(bool)
+	} else if val, ok := (*options)["@md"]; ok && reflect.TypeOf(val) == goutil.VarType["bool"] {
+		useMarkdown[0] = val.(bool)
+	}
+
+	// `$` math only runs in .md files and <_md> blocks, so a `$` in html (ie: jquery) is not compiled as math
+	// a file can enable it with the `@math` option
+	mathInMD := strings.HasSuffix(path, ".md")
+	if val, ok := (*options)["@math"]; ok && reflect.TypeOf(val) == goutil.VarType["bool"] {
+		mathInMD = val.(bool)
+	}
+	mdStore["math"] = mathInMD
+
+	// markdown does not run in the raw text of <script> and <style> tags
+	inRawText := false
+
 	var buf byte
 	for err == nil {
 		buf, err = reader.PeekByte(0)
@@ -2224,7 +2587,27 @@ func preCompile(path string, options *map[string]interface{}, arguments *htmlArg
 						// 2 = <tag/> (</tag/>)
 						// 3 = <tag>
 
-						if regex.Comp(`(?i)^_?(el(?:se|if)|if|else_?if)$`).MatchRef(&args.tag) {
+						if regex.Comp(`(?i)^_tag$`).MatchRef(&args.tag) {
+							if args.close != 1 {
+								if tags, ok := tagArgs(&args, options, &eachArgsList, true); ok {
+									deps.addTags(tags...)
+								} else {
+									// tags with vars are added by the compiler, to the fragments of the cache tags they are inside of
+									write(regex.JoinBytes([]byte("{{%tag "), compilerArgs(&args), []byte("/}}")))
+									hasUnhandledVars = true
+								}
+							}
+							removeLineBreak(reader)
+						} else if regex.Comp(`(?i)^_(md|nomd)$`).MatchRef(&args.tag) {
+							if args.close == 3 {
+								useMarkdown = append(useMarkdown, bytes.EqualFold(args.tag, []byte("_md")))
+								removeLineBreak(reader)
+							} else if args.close == 1 && len(useMarkdown) > 1 {
+								useMarkdown = useMarkdown[:len(useMarkdown)-1]
+								removeLineBreak(reader)
+							}
+							mdStore["math"] = mathInMD || goutil.Contains(useMarkdown[1:], true)
+						} else if regex.Comp(`(?i)^_?(el(?:se|if)|if|else_?if)$`).MatchRef(&args.tag) {
 							args.tag = bytes.ToLower(args.tag)
 
 							if args.close == 3 && (bytes.Equal(args.tag, []byte("_if")) || bytes.Equal(args.tag, []byte("if"))) { // open tag
@@ -2751,9 +3134,9 @@ func preCompile(path string, options *map[string]interface{}, arguments *htmlArg
 								htmlTagsErr = append(htmlTagsErr, &compErr)
 
 								if htmlChan != nil && !goutil.Contains(args.ind, "SYNC") {
-									htmlChan.comp <- handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot}
+									htmlChan.comp <- handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot, deps: deps}
 								} else {
-									handleHtmlComponent(handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot})
+									handleHtmlComponent(handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot, deps: deps})
 								}
 								write([]byte{0})
 							} else if args.close == 2 {
@@ -2763,9 +3146,9 @@ func preCompile(path string, options *map[string]interface{}, arguments *htmlArg
 								htmlTagsErr = append(htmlTagsErr, &compErr)
 
 								if htmlChan != nil && !goutil.Contains(args.ind, "SYNC") {
-									htmlChan.comp <- handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot}
+									htmlChan.comp <- handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot, deps: deps}
 								} else {
-									handleHtmlComponent(handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot})
+									handleHtmlComponent(handleHtmlData{html: &htmlCont, options: options, arguments: &args, eachArgs: cloneArr(eachArgsList), compileError: &compErr, componentList: componentList, componentRecursionList: componentRecursionList, hasUnhandledVars: &hasUnhandledVars, localRoot: &localRoot, deps: deps})
 								}
 								write([]byte{0})
 							}
@@ -2775,6 +3158,14 @@ func preCompile(path string, options *map[string]interface{}, arguments *htmlArg
 								args.close = 2
 							}
 
+							if regex.Comp(`(?i)^(script|style)$`).MatchRef(&args.tag) {
+								if args.close == 3 {
+									inRawText = true
+								} else if args.close == 1 {
+									inRawText = false
+								}
+							}
+
 							htmlCont := []byte{0}
 							var compErr error
 							if len(htmlContTemp) != 0 {
@@ -2920,7 +3311,7 @@ func preCompile(path string, options *map[string]interface{}, arguments *htmlArg
 		//todo: consider using 'AspieSoft/go-memshare' module if a funcs.go file is detected in the $PWD directory and link it to the TagFuncs.AddFN method
 
 		// handle markdown
-		if compileMarkdown(reader, &write, &firstChar, &spaces, &mdStore) {
+		if !inRawText && useMarkdown[len(useMarkdown)-1] && compileMarkdown(reader, &write, &firstChar, &spaces, &mdStore) {
 			continue
 		}
 
@@ -2929,6 +3320,9 @@ func preCompile(path string, options *map[string]interface{}, arguments *htmlArg
 		reader.Discard(1)
 	}
 
+	// close any open markdown and add footnotes
+	compileMarkdownEnd(&write, &mdStore)
+
 	// stop concurrent channels from running
 	if htmlChan != nil {
 		htmlChan.tag <- handleHtmlData{stopChan: true}
@@ -3065,6 +3459,14 @@ func handleHtmlTag(htmlData handleHtmlData) {
 		}
 	}
 
+	// add responsive image variants to local images, and poster frames to local videos (unless in debug mode)
+	var picture []byte
+	if !compilerConfig.DebugMode && bytes.Equal(htmlData.arguments.tag, []byte("img")) {
+		picture = responsiveImage(htmlData.arguments)
+	} else if !compilerConfig.DebugMode && bytes.Equal(htmlData.arguments.tag, []byte("video")) {
+		videoPoster(htmlData.arguments)
+	}
+
 	args := [][]byte{}
 	for _, v := range htmlData.arguments.ind {
 		if htmlData.arguments.args[v] != nil && len(htmlData.arguments.args[v]) != 0 {
@@ -3094,18 +3496,16 @@ func handleHtmlTag(htmlData handleHtmlData) {
 						return bytes.Join(bytes.Split(data(1), []byte{}), []byte{'\\'})
 					})
 
-					// check local js and css link args for .min files (unless in debug mode)
+					// check local js, css, and svg link args for .min files (unless in debug mode)
 					// also check for .webp, .webm, and .weba files
+					// content hashed files from the manifest are preferred when they exist
 					if !compilerConfig.DebugMode && (v == "src" || v == "href" || v == "url") && len(htmlData.arguments.args[v]) != 0 && htmlData.arguments.args[v][0] == '/' {
 						link := htmlData.arguments.args[v]
-						if regex.Comp(`(\.min|)\.([jt]s|css|less|s[ac]ss)$`).MatchRef(&link) {
-							link = regex.Comp(`(\.min|)\.([jt]s|css|less|s[ac]ss)$`).RepFuncRef(&link, func(data func(int) []byte) []byte {
+						src := string(link)
+						if regex.Comp(`(\.min|)\.([jt]sx?|mts|css|less|s[ac]ss|svg)$`).MatchRef(&link) {
+							link = regex.Comp(`(\.min|)\.([jt]sx?|mts|css|less|s[ac]ss|svg)$`).RepFuncRef(&link, func(data func(int) []byte) []byte {
 								ext := data(2)
-								if bytes.Equal(ext, []byte("ts")) {
-									//todo: add support for auto compiling typescript to javascript
-									// remove this first if condition when done (and let the file extension be updated to js)
-									htmlData.arguments.args["type"] = []byte("text/typescript")
-								} else if regex.Comp(`([jt]s)`).MatchRef(&ext) {
+								if regex.Comp(`([jt]sx?|mts)`).MatchRef(&ext) {
 									ext = []byte("js")
 								} else if regex.Comp(`(css|less|s[ac]ss)`).MatchRef(&ext) {
 									ext = []byte("css")
@@ -3140,6 +3540,15 @@ func handleHtmlTag(htmlData handleHtmlData) {
 								}
 							}
 						}
+
+						// use the content hashed file for cache-busting
+						if hashUrl, ok := staticManifest.Get(src); ok {
+							if linkPath, err := goutil.FS.JoinPath(compilerConfig.Static, hashUrl); err == nil {
+								if stat, err := os.Stat(linkPath); err == nil && !stat.IsDir() {
+									htmlData.arguments.args[v] = []byte(hashUrl)
+								}
+							}
+						}
 					}
 
 					args = append(args, regex.JoinBytes(v, []byte{'=', '"'}, goutil.HTML.EscapeArgs(htmlData.arguments.args[v], '"'), '"'))
@@ -3149,6 +3558,15 @@ func handleHtmlTag(htmlData handleHtmlData) {
 		}
 	}
 
+	// add subresource integrity to content hashed scripts and stylesheets (unless in debug mode)
+	if !compilerConfig.DebugMode {
+		if link := integrityLink(htmlData.arguments); link != nil {
+			if hash := integrityHash(string(link)); hash != "" {
+				args = append(args, regex.JoinBytes([]byte(`integrity="`), hash, '"'))
+			}
+		}
+	}
+
 	sort.Slice(args, func(i, j int) bool {
 		a := bytes.Split(args[i], []byte{'='})[0]
 		b := bytes.Split(args[j], []byte{'='})[0]
@@ -3170,6 +3588,10 @@ func handleHtmlTag(htmlData handleHtmlData) {
 		return bytes.Compare(a, b) == -1
 	})
 
+	if picture != nil {
+		(*htmlData.html) = append((*htmlData.html), picture...)
+	}
+
 	if len(args) == 0 {
 		(*htmlData.html) = append((*htmlData.html), regex.JoinBytes('<', htmlData.arguments.tag)...)
 	} else {
@@ -3186,6 +3608,10 @@ func handleHtmlTag(htmlData handleHtmlData) {
 		(*htmlData.html) = append((*htmlData.html), regex.JoinBytes('<', '/', htmlData.arguments.tag, '>')...)
 	}
 
+	if picture != nil {
+		(*htmlData.html) = append((*htmlData.html), []byte("</picture>")...)
+	}
+
 	(*htmlData.html)[0] = 1
 }
 
@@ -3196,6 +3622,11 @@ func handleHtmlFunc(htmlData handleHtmlData) {
 	if res != nil && len(res) != 0 {
 		if res[0] == 0 {
 			if htmlData.preComp {
+				// a `<_cache>` tag is only passed to the compiler when its content has vars, so the page is not static
+				if htmlData.hasUnhandledVars != nil && bytes.EqualFold(bytes.TrimPrefix(htmlData.arguments.tag, []byte{'_'}), []byte("cache")) {
+					*htmlData.hasUnhandledVars = true
+				}
+
 				if body, ok := htmlData.arguments.args["body"]; ok {
 					*htmlData.html = append(*htmlData.html, regex.JoinBytes([]byte("{{%"), htmlData.arguments.tag[1:], ' ', res[1:], []byte("}}"), body, []byte("{{%/"), htmlData.arguments.tag[1:], []byte("}}"))...)
 				} else {
@@ -3305,7 +3736,7 @@ func handleHtmlComponent(htmlData handleHtmlData) {
 	}
 
 	// precompile component
-	preCompile(path, &opts, htmlData.arguments, htmlData.html, htmlData.compileError, nil, htmlData.eachArgs, htmlData.componentList, htmlData.componentRecursionList)
+	preCompile(path, &opts, htmlData.arguments, htmlData.html, htmlData.compileError, nil, htmlData.eachArgs, htmlData.componentList, htmlData.componentRecursionList, htmlData.deps)
 	if *htmlData.compileError != nil {
 		(*htmlData.html)[0] = 2
 		return
@@ -3408,59 +3839,87 @@ func getCoreTagFunc(name []byte) (func(opts *map[string]interface{}, args *htmlA
 	return nil, false, errors.New("method '" + nameStr + "' does not return the expected args")
 }
 
-var ranTypeScriptNotice bool = false
-
 // tryMinifyFile attempts to minify files
 //
-// example: .js -> .min.js, .less -> .min.css, .png -> .webp
+// example: .js -> .min.js, .less -> .min.css, .png -> .webp (and responsive .avif/.webp variants)
 func tryMinifyFile(path string) {
 	if imageRE.Match([]byte(path)) {
-		resPath := string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte(".webp")))
-		if err := ffmpeg.Input(path).Output(resPath).OverWriteOutput().Run(); err != nil {
-			os.Remove(resPath)
-		}
+		tryMinifyImage(path)
 		return
 	} else if videoRE.Match([]byte(path)) {
-		resPath := string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte(".webm")))
-		if err := ffmpeg.Input(path).Output(resPath).OverWriteOutput().Run(); err != nil {
-			os.Remove(resPath)
-		}
+		tryMinifyVideo(path)
 		return
 	} else if audioRE.Match([]byte(path)) {
-		resPath := string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte(".weba")))
-		if err := ffmpeg.Input(path).Output(resPath).OverWriteOutput().Run(); err != nil {
-			os.Remove(resPath)
-		}
+		tryMinifyAudio(path)
 		return
 	}
 
-	resPath := string(regex.Comp(`(?<!\.min)\.([jt]s|css|less|s[ac]ss)$`).RepFunc([]byte(path), func(data func(int) []byte) []byte {
-		ext := data(1)
-		if regex.Comp(`^([jt]s)$`).MatchRef(&ext) {
-			return []byte(".min.js")
-		} else if regex.Comp(`^(css|less|s[ac]ss)$`).MatchRef(&ext) {
-			return []byte(".min.css")
-		}
-		return regex.JoinBytes([]byte(".min"), ext)
-	}))
+	// skip typescript declaration files
+	if strings.HasSuffix(path, ".d.ts") {
+		return
+	}
+
+	resPath := minifiedPath(path)
 	if code, err := os.ReadFile(path); err == nil {
-		if strings.HasSuffix(path, ".js") {
+		var sassMap []byte
+
+		if compilerConfig.Bundle && regex.Comp(`\.([jt]sx?|mts|css)$`).Match([]byte(path)) {
+			var res []byte
+			var deps []string
+			var err error
+			if strings.HasSuffix(path, ".css") {
+				res, deps, err = bundleCSS(path)
+			} else {
+				res, deps, err = bundleJS(path)
+			}
+
+			// keep track of the dependencies, even if the bundle failed, so a fix will rebuild it
+			bundleDeps.Set(path, deps)
+
+			if err != nil {
+				LogErr(errors.New(path + ": " + err.Error()))
+				os.Remove(resPath)
+				return
+			}
+
+			if strings.HasSuffix(path, ".css") {
+				if res, err := minify.CSS(string(res)); err == nil {
+					writeFileAtomic(resPath, []byte(res), 0775)
+				}
+			} else if res, err := minify.JS(string(res)); err == nil {
+				writeFileAtomic(resPath, []byte(";"+res+";"), 0775)
+			}
+		} else if strings.HasSuffix(path, ".js") {
 			if res, err := minify.JS(string(code)); err == nil {
-				os.WriteFile(resPath, []byte(";"+res+";"), 0775)
+				writeFileAtomic(resPath, []byte(";"+res+";"), 0775)
 			}
 		} else if strings.HasSuffix(path, ".css") {
 			if res, err := minify.CSS(string(code)); err == nil {
-				os.WriteFile(resPath, []byte(res), 0775)
+				writeFileAtomic(resPath, []byte(res), 0775)
 			}
-		} else if strings.HasSuffix(path, ".ts") {
-			//todo: add support for auto compiling typescript to javascript
-			// compile typescript here
-			if compilerConfig.DebugMode && !ranTypeScriptNotice {
-				ranTypeScriptNotice = true
-				LogErr(errors.New("notice: turbx compiler does not currently support auto compiling typescript to javascript in static files. (maybe this feature will be available in a future update)"))
+		} else if strings.HasSuffix(path, ".ts") || strings.HasSuffix(path, ".tsx") || strings.HasSuffix(path, ".mts") || strings.HasSuffix(path, ".jsx") {
+			res, err := transpileTypeScript(code, strings.HasSuffix(path, "x"))
+			if err != nil {
+				LogErr(errors.New(path + ": " + err.Error()))
+				os.Remove(resPath)
+				return
+			}
+			if res, err := minify.JS(string(res)); err == nil {
+				writeFileAtomic(resPath, []byte(";"+res+";"), 0775)
+			}
+		} else if strings.HasSuffix(path, ".svg") {
+			if res, err := minify.SVG(string(code)); err == nil {
+				writeFileAtomic(resPath, []byte(res), 0775)
 			}
 		} else if strings.HasSuffix(path, ".less") {
-			if err := less.RenderFile(path, resPath, map[string]interface{}{"compress": true}); err != nil {
+			// less writes the file itself, so it is rendered to a temp file and renamed into place (like writeFileAtomic)
+			tmpPath := filepath.Join(filepath.Dir(resPath), "."+filepath.Base(resPath)+".less.tmp")
+			if err := less.RenderFile(path, tmpPath, map[string]interface{}{"compress": true}); err != nil {
+				os.Remove(tmpPath)
+				os.Remove(resPath)
+			} else if err := os.Rename(tmpPath, resPath); err != nil {
+				// the temp file is not written if the less fails to compile
+				os.Remove(tmpPath)
 				os.Remove(resPath)
 			}
 		} else if strings.HasSuffix(path, ".sass") || strings.HasSuffix(path, ".scss") {
@@ -3472,15 +3931,76 @@ func tryMinifyFile(path string) {
 				return []byte{}
 			})
 
-			if transpiler, err := libsass.New(libsass.Options{OutputStyle: libsass.CompressedStyle, IncludePaths: []string{compilerConfig.Static}, SassSyntax: strings.HasSuffix(path, ".sass")}); err == nil {
+			opts := libsass.Options{OutputStyle: libsass.CompressedStyle, IncludePaths: []string{compilerConfig.Static}, SassSyntax: strings.HasSuffix(path, ".sass")}
+
+			// pruning css would move the rules after libsass maps them
+			if sourceMapMode() != SourceMapsOff && !compilerConfig.PruneCSS {
+				opts.SourceMapOptions = libsass.SourceMapOptions{Filename: resPath + ".map", InputPath: path, OutputPath: resPath, Contents: true, OmitURL: true}
+			}
+
+			if transpiler, err := libsass.New(opts); err == nil {
 				if res, err := transpiler.Execute(string(code)); err == nil {
-					os.WriteFile(resPath, []byte(res.CSS), 0775)
+					writeFileAtomic(resPath, []byte(res.CSS), 0775)
+					sassMap = []byte(res.SourceMapContent)
 				}
 			}
 		}
+
+		sources := []string{path}
+		if deps, ok := bundleDeps.Get(path); ok && compilerConfig.Bundle {
+			sources = append(sources, deps...)
+		}
+		if compilerConfig.PruneCSS && strings.HasSuffix(resPath, ".css") {
+			pruneCSSFile(resPath)
+		}
+
+		if len(sassMap) != 0 {
+			writeSassSourceMap(resPath, sassMap)
+		} else if sourceMapSupported(path) {
+			writeSourceMap(resPath, sources)
+		} else {
+			removeStaticFile(resPath + ".map")
+		}
+
+		precompressStaticFile(resPath)
+		precompressStaticFile(resPath + ".map")
+
+		hashStaticFile(path, resPath)
+		regenerateInlinedPages(staticUrlPath(resPath))
 	}
 }
 
+// minifiedPath returns the path a static file will be minified to
+//
+// example: .ts -> .min.js, .scss -> .min.css
+func minifiedPath(path string) string {
+	return string(minifyRE.RepFunc([]byte(path), func(data func(int) []byte) []byte {
+		ext := data(1)
+		if regex.Comp(`^([jt]sx?|mts)$`).MatchRef(&ext) {
+			return []byte(".min.js")
+		} else if regex.Comp(`^(css|less|s[ac]ss)$`).MatchRef(&ext) {
+			return []byte(".min.css")
+		}
+		return regex.JoinBytes([]byte(".min"), ext)
+	}))
+}
+
+// htmlTagArg returns the value of an arg in a compiled html tag
+//
+// @bool: true if the arg exists (args without a value return an empty value)
+func htmlTagArg(tag []byte, name string) ([]byte, bool) {
+	var val []byte
+	found := false
+	regex.Comp(`(?i)\s%1(?:\s*=\s*(["'])(.*?)\1|(?=[\s/>]))`, name).RepFunc(tag, func(data func(int) []byte) []byte {
+		if !found {
+			val = data(2)
+			found = true
+		}
+		return nil
+	}, true)
+	return val, found
+}
+
 // tryMinifyDir runs tryMinifyFile recursively on a directory
 func tryMinifyDir(dirPath string) {
 	if files, err := os.ReadDir(dirPath); err == nil {
@@ -3489,7 +4009,7 @@ func tryMinifyDir(dirPath string) {
 				if file.IsDir() {
 					tryMinifyDir(path)
 				} else {
-					if regex.Comp(`(?<!\.min)\.([jt]s|css|less|s[ac]ss)$`).Match([]byte(path)) || imageRE.Match([]byte(path)) || videoRE.Match([]byte(path)) || audioRE.Match([]byte(path)) {
+					if minifyRE.Match([]byte(path)) || imageRE.Match([]byte(path)) || videoRE.Match([]byte(path)) || audioRE.Match([]byte(path)) {
 						tryMinifyFile(path)
 					}
 				}
@@ -3499,7 +4019,7 @@ func tryMinifyDir(dirPath string) {
 }
 
 // removeLineBreak removes one extra line break from the compiler
-func removeLineBreak[T interface{ uint8 | uint16 }](reader *liveread.Reader[T]) bool {
+func removeLineBreak(reader cacheReader) bool {
 	b, e := reader.Peek(2)
 	if e == nil {
 		if b[0] == '\r' && b[1] == '\n' {
@@ -0,0 +1,150 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfig sets up the compilerConfig for a test, with temp dirs and an in memory CacheStore
+//
+// the original config is restored when the test finishes
+func testConfig(t *testing.T) {
+	origConfig := compilerConfig
+	t.Cleanup(func() {
+		compilerConfig = origConfig
+	})
+
+	compilerConfig.Root = t.TempDir()
+	compilerConfig.Static = t.TempDir()
+	compilerConfig.StaticUrl = ""
+	compilerConfig.Ext = "html"
+	compilerConfig.StaticHTML = filepath.Join(t.TempDir(), "html.static")
+	compilerConfig.CacheDir = filepath.Join(t.TempDir(), "html.cache")
+	compilerConfig.CacheStore = NewMemoryCacheStore(0)
+}
+
+// testHtmlTag runs handleHtmlTag on a tag with literal args, and returns the html
+func testHtmlTag(t *testing.T, tag string, args map[string]string) string {
+	html := []byte{0}
+	var compileError error
+	hasUnhandledVars := false
+	opts := map[string]interface{}{}
+
+	arguments := htmlArgs{tag: []byte(tag), args: map[string][]byte{}, ind: []string{}, close: 2}
+	for key, val := range args {
+		arguments.args[key] = append([]byte{0}, val...)
+		arguments.ind = append(arguments.ind, key)
+	}
+
+	handleHtmlTag(handleHtmlData{
+		html:             &html,
+		options:          &opts,
+		arguments:        &arguments,
+		compileError:     &compileError,
+		hasUnhandledVars: &hasUnhandledVars,
+		deps:             &pageDeps{},
+	})
+
+	if compileError != nil {
+		t.Fatal(compileError)
+	}
+	return string(html[1:])
+}
+
+func TestMinifiedLinks(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.DebugMode = false
+
+	if err := os.WriteFile(filepath.Join(compilerConfig.Static, "icon.min.svg"), []byte("<svg/>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tag  string
+		args map[string]string
+		want string
+	}{
+		{"img", map[string]string{"src": "/icon.svg", "alt": "icon"}, `<img alt="icon" src="/icon.min.svg"/>`},
+		{"img", map[string]string{"src": "/missing.svg"}, `<img src="/missing.svg"/>`},
+		{"a", map[string]string{"href": "https://example.com/icon.svg"}, `<a href="https://example.com/icon.svg"/>`},
+	}
+
+	for _, test := range tests {
+		if res := testHtmlTag(t, test.tag, test.args); res != test.want {
+			t.Errorf("unexpected html:\n got: %s\nwant: %s", res, test.want)
+		}
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.DebugMode = false
+	defer staticManifest.Del("/app.js")
+
+	for _, name := range []string{"app.min.js", "app.min.1a2b3c4d5e.js", "vendor.min.js"} {
+		if err := os.WriteFile(filepath.Join(compilerConfig.Static, name), []byte("console.log(1);"), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+	staticManifest.Set("/app.js", "/app.min.1a2b3c4d5e.js")
+
+	hash := integrityHash("/app.min.1a2b3c4d5e.js")
+	if !strings.HasPrefix(hash, "sha384-") {
+		t.Fatalf("unexpected hash: %s", hash)
+	}
+
+	// content hashed urls get an integrity arg
+	if res := testHtmlTag(t, "script", map[string]string{"src": "/app.js"}); res != `<script integrity="`+hash+`" src="/app.min.1a2b3c4d5e.js"></script>` {
+		t.Errorf("unexpected html: %s", res)
+	}
+
+	// unhashed urls can change without the page being recompiled, so they do not get an integrity arg
+	if res := testHtmlTag(t, "script", map[string]string{"src": "/vendor.min.js"}); res != `<script src="/vendor.min.js"></script>` {
+		t.Errorf("unexpected html: %s", res)
+	}
+}
+
+func TestHtmlFuncUnhandledVars(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		body string
+		want bool
+	}{
+		// a cache tag with vars needs the compiler to store the fragment
+		{"cache with vars", "_Cache", "<p>{{name}}</p>", true},
+		{"cache without vars", "_Cache", "<p>hi</p>", false},
+
+		// other functions passed to the compiler keep the page static, as they did before the cache tag was added
+		{"json", "_Json", "", false},
+	}
+
+	for _, test := range tests {
+		fn, _, err := getCoreTagFunc([]byte(test.tag))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		html := []byte{0}
+		var compileError error
+		hasUnhandledVars := false
+		opts := map[string]interface{}{}
+
+		arguments := htmlArgs{tag: []byte(test.tag), args: map[string][]byte{"key": append([]byte{0}, "k"...), "0": append([]byte{0}, "obj"...)}, ind: []string{"key", "0"}, close: 3}
+		if test.body != "" {
+			arguments.args["body"] = []byte(test.body)
+		}
+
+		handleHtmlFunc(handleHtmlData{fn: &fn, preComp: true, html: &html, options: &opts, arguments: &arguments, compileError: &compileError, hasUnhandledVars: &hasUnhandledVars})
+
+		if compileError != nil {
+			t.Errorf("%s: %v", test.name, compileError)
+		} else if hasUnhandledVars != test.want {
+			t.Errorf("%s: expected hasUnhandledVars to be %v: %s", test.name, test.want, html)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package compiler
+
+import (
+	"errors"
+	"os"
+
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// precompressStaticFile writes .br and .gz files next to a static text file
+//
+// files smaller than PreCompressMinSize will not be compressed, and any old compressed files will be removed
+func precompressStaticFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < compilerConfig.PreCompressMinSize {
+		os.Remove(path + ".br")
+		os.Remove(path + ".gz")
+		return
+	}
+
+	// the files are written atomically, so a request cannot read a partly written file
+	if br, err := goutil.BROTLI.Zip(data, compilerConfig.PreCompress); err == nil {
+		if err := writeFileAtomic(path+".br", br, 0775); err != nil {
+			os.Remove(path + ".br")
+		}
+	}
+
+	if gz, err := goutil.GZIP.Zip(data, compilerConfig.gzipPreCompress); err == nil {
+		if err := writeFileAtomic(path+".gz", gz, 0775); err != nil {
+			os.Remove(path + ".gz")
+		}
+	}
+}
+
+// removeStaticFile removes a static file, and its precompressed files
+func removeStaticFile(path string) {
+	os.Remove(path)
+	os.Remove(path + ".br")
+	os.Remove(path + ".gz")
+}
+
+// StaticFile returns the path of a file in the Static dir, preferring a precompressed file
+//
+// @acceptEncodings: the compression types the client accepts (ie: []string{"br", "gz"})
+//
+// uint8: compression type:
+//
+// - 0: uncompressed file
+//
+// - 1: compressed to brotli
+//
+// - 2: compressed to gzip
+func StaticFile(url string, acceptEncodings []string) (string, uint8, error) {
+	path, err := goutil.FS.JoinPath(compilerConfig.Static, url)
+	if err != nil {
+		return "", 0, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	} else if stat.IsDir() {
+		return "", 0, errors.New("path is a directory")
+	}
+
+	// only use compressed files that are up to date with the original file
+	fresh := func(compPath string) bool {
+		compStat, err := os.Stat(compPath)
+		return err == nil && !compStat.IsDir() && !compStat.ModTime().Before(stat.ModTime())
+	}
+
+	if goutil.Contains(acceptEncodings, "br") && fresh(path+".br") {
+		return path + ".br", 1, nil
+	} else if (goutil.Contains(acceptEncodings, "gz") || goutil.Contains(acceptEncodings, "gzip")) && fresh(path+".gz") {
+		return path + ".gz", 2, nil
+	}
+
+	return path, 0, nil
+}
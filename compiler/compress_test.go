@@ -0,0 +1,58 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrecompressStaticFile(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.PreCompress = 7
+	compilerConfig.gzipPreCompress = 6
+	compilerConfig.PreCompressMinSize = 1024
+
+	path := filepath.Join(compilerConfig.Static, "app.min.js")
+	data := bytes.Repeat([]byte("console.log('hello');"), 100)
+	if err := os.WriteFile(path, data, 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	precompressStaticFile(path)
+
+	if res, comp, err := StaticFile("/app.min.js", []string{"br", "gz"}); err != nil || res != path+".br" || comp != 1 {
+		t.Errorf("expected a brotli file: %s %d %v", res, comp, err)
+	}
+	if res, comp, err := StaticFile("/app.min.js", []string{"gzip"}); err != nil || res != path+".gz" || comp != 2 {
+		t.Errorf("expected a gzip file: %s %d %v", res, comp, err)
+	}
+	if res, comp, err := StaticFile("/app.min.js", []string{}); err != nil || res != path || comp != 0 {
+		t.Errorf("expected the uncompressed file: %s %d %v", res, comp, err)
+	}
+
+	// no temp files are left behind by the atomic writes
+	files, err := os.ReadDir(compilerConfig.Static)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			t.Errorf("unexpected temp file: %s", file.Name())
+		}
+	}
+
+	// files smaller than PreCompressMinSize remove their old compressed files
+	if err := os.WriteFile(path, []byte("console.log(1);"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	precompressStaticFile(path)
+	if _, err := os.Stat(path + ".br"); !os.IsNotExist(err) {
+		t.Errorf("expected the brotli file to be removed: %v", err)
+	}
+	if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+		t.Errorf("expected the gzip file to be removed: %v", err)
+	}
+}
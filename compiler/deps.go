@@ -0,0 +1,251 @@
+package compiler
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// pageDeps collects the files (components, layouts, and markdown) a page is compiled from,
+// along with the tags added by `<_tag>` functions
+type pageDeps struct {
+	files []string
+	tags  []string
+	mu    sync.Mutex
+}
+
+func (deps *pageDeps) add(path string) {
+	if deps == nil {
+		return
+	}
+
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+
+	if !goutil.Contains(deps.files, path) {
+		deps.files = append(deps.files, path)
+	}
+}
+
+// list returns the dependencies, excluding the page itself
+func (deps *pageDeps) list(page string) []string {
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+
+	list := []string{}
+	for _, file := range deps.files {
+		if file != page {
+			list = append(list, file)
+		}
+	}
+	return list
+}
+
+// addTags adds tags to the page
+func (deps *pageDeps) addTags(tags ...string) {
+	if deps == nil {
+		return
+	}
+
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+
+	deps.tags = mergeTags(deps.tags, tags)
+}
+
+// tagList returns the tags of the page
+func (deps *pageDeps) tagList() []string {
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+
+	return append([]string{}, deps.tags...)
+}
+
+// purgePreCache removes a page from the htmlPreCache, along with its cache files and md5sum in the CacheStore, and its cached fragments
+func purgePreCache(path string, data cacheObj) {
+	htmlPreCacheMU.Lock()
+	htmlPreCache.Del(path)
+	htmlPreCacheMU.Unlock()
+
+	purgeFragments(data.cachePath)
+	for _, file := range data.cachePath {
+		if (data.static && strings.HasPrefix(file, compilerConfig.StaticHTML)) || (!data.static && strings.HasPrefix(file, compilerConfig.CacheDir)) {
+			compilerConfig.CacheStore.Delete(file)
+		}
+	}
+	if len(data.cachePath) != 0 {
+		compilerConfig.CacheStore.Delete(string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(data.cachePath[0]), []byte(".cache.md5sum"))))
+	}
+}
+
+// invalidateDependents removes every cached page that depends on a file
+func invalidateDependents(path string) {
+	htmlPreCache.ForEach(func(page string, data cacheObj) bool {
+		if goutil.Contains(data.deps, path) {
+			purgePreCache(page, data)
+		}
+		return true
+	})
+}
+
+// cacheSum is the content of an md5sum file
+type cacheSum struct {
+	// the dependencies of the page
+	deps []string
+
+	// the checksums of the cache files by file type
+	files map[string]string
+
+	// the tags of the page
+	tags []string
+
+	// the time the page was compiled (zero for md5sum files from an older version)
+	modTime time.Time
+
+	// the `@cacheTime` option of the page in minutes
+	cacheTime int
+
+	// the total size of the cache files (0 if unknown)
+	size int64
+}
+
+// readCacheSum reads an md5sum file, and verifies the checksums of the page and its dependencies
+//
+// md5sum files are a list of file paths (relative to the Root dir), each followed by a line with its checksum.
+// the cache files of the page are listed by their file type (ie: ".html.br"), after the dependencies.
+// the tags of the page are listed as "@tags", followed by a line with the tags separated by spaces
+// the time the page was compiled is listed as "@time", followed by a line with the unix time in milliseconds.
+// the `@cacheTime` option and the size of the cache files are listed as "@cacheTime" and "@size"
+//
+// @*cacheSum: the content of the md5sum file (nil if it could not be read)
+//
+// @bool: true if all of the checksums of the page and its dependencies are still valid
+func readCacheSum(sumPath string) (*cacheSum, bool) {
+	sum, _, err := compilerConfig.CacheStore.Get(sumPath)
+	if err != nil {
+		return nil, false
+	}
+
+	lines := bytes.Split(sum, []byte{'\n'})
+	if len(lines) < 2 || len(lines)%2 != 0 {
+		return nil, false
+	}
+
+	res := cacheSum{
+		deps:  []string{},
+		files: map[string]string{},
+		tags:  []string{},
+	}
+
+	valid := true
+	for i := 0; i < len(lines); i += 2 {
+		if bytes.Equal(lines[i], []byte("@tags")) {
+			res.tags = splitTags(lines[i+1])
+			continue
+		} else if bytes.Equal(lines[i], []byte("@time")) {
+			if ms, err := strconv.ParseInt(string(lines[i+1]), 10, 64); err == nil {
+				res.modTime = time.UnixMilli(ms)
+			}
+			continue
+		} else if bytes.Equal(lines[i], []byte("@cacheTime")) {
+			res.cacheTime, _ = strconv.Atoi(string(lines[i+1]))
+			continue
+		} else if bytes.Equal(lines[i], []byte("@size")) {
+			res.size, _ = strconv.ParseInt(string(lines[i+1]), 10, 64)
+			continue
+		} else if cacheFileExt(string(lines[i])) == string(lines[i]) {
+			res.files[string(lines[i])] = string(lines[i+1])
+			continue
+		}
+
+		file := rootPath(string(lines[i]))
+		if i != 0 {
+			res.deps = append(res.deps, file)
+		}
+
+		if resSum, err := getCheckSumMD5(file); err != nil || !bytes.Equal(lines[i+1], resSum) {
+			valid = false
+		}
+	}
+
+	return &res, valid
+}
+
+// rootRelPath returns a path relative to the Root dir, so an md5sum file can be shared by instances with a different Root
+//
+// paths outside of the Root dir are returned as is
+func rootRelPath(path string) string {
+	if rel, err := filepath.Rel(compilerConfig.Root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// rootPath returns the full path of a path from rootRelPath
+func rootPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(compilerConfig.Root, filepath.FromSlash(path))
+}
+
+// writeCacheSum writes the md5sum file of a page, with the checksums of the page, its dependencies, and its cache files
+//
+// @page: the path of the view
+//
+// @cache: the cache files of the page, with their checksums, dependencies, and tags
+func writeCacheSum(page string, cache cacheObj) error {
+	if len(cache.cachePath) == 0 || regex.Comp(`[\r\n]`).Match([]byte(page)) {
+		return nil
+	}
+
+	// the page is followed by the files it depends on
+	sumData := [][]byte{}
+	for i, file := range append([]string{page}, cache.deps...) {
+		if regex.Comp(`[\r\n]`).Match([]byte(file)) {
+			continue
+		}
+
+		if sum, err := getCheckSumMD5(file); err == nil {
+			sumData = append(sumData, []byte(rootRelPath(file)), sum)
+		} else if i == 0 {
+			return err
+		}
+	}
+
+	for i, file := range cache.cachePath {
+		if i < len(cache.checksum) && cache.checksum[i] != "" {
+			sumData = append(sumData, []byte(cacheFileExt(file)), []byte(cache.checksum[i]))
+		}
+	}
+
+	if len(cache.tags) != 0 {
+		sumData = append(sumData, []byte("@tags"), []byte(strings.Join(cache.tags, " ")))
+	}
+
+	if !cache.modTime.IsZero() {
+		sumData = append(sumData, []byte("@time"), []byte(strconv.FormatInt(cache.modTime.UnixMilli(), 10)))
+	}
+
+	if cache.cacheTime != 0 {
+		sumData = append(sumData, []byte("@cacheTime"), []byte(strconv.Itoa(cache.cacheTime)))
+	}
+
+	if cache.size != 0 {
+		sumData = append(sumData, []byte("@size"), []byte(strconv.FormatInt(cache.size, 10)))
+	}
+
+	sumPath := string(regex.Comp(`\.html(\.(?:cache|gz|br)|)$`).RepStr([]byte(cache.cachePath[0]), []byte(".cache.md5sum")))
+	sum := bytes.Join(sumData, []byte{'\n'})
+	return compilerConfig.CacheStore.Put(sumPath, sum, CacheMeta{
+		Page:     viewName(page),
+		Static:   strings.HasPrefix(sumPath, compilerConfig.StaticHTML),
+		CheckSum: checkSumMD5(sum),
+	})
+}
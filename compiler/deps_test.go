@@ -0,0 +1,97 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInvalidateDependents(t *testing.T) {
+	testConfig(t)
+
+	layout := filepath.Join(compilerConfig.Root, "layout.html")
+	blogPath := filepath.Join(compilerConfig.StaticHTML, "blog.html.html")
+	aboutPath := filepath.Join(compilerConfig.StaticHTML, "about.html.html")
+
+	for _, file := range []string{blogPath, aboutPath} {
+		if err := compilerConfig.CacheStore.Put(file, []byte("<h1>page</h1>"), CacheMeta{Static: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	htmlPreCache.Set("test/blog", cacheObj{cachePath: []string{blogPath}, static: true, deps: []string{layout}})
+	htmlPreCache.Set("test/about", cacheObj{cachePath: []string{aboutPath}, static: true})
+	defer htmlPreCache.Del("test/blog")
+	defer htmlPreCache.Del("test/about")
+
+	invalidateDependents(layout)
+
+	if _, ok := htmlPreCache.Get("test/blog"); ok {
+		t.Error("expected the page that depends on the layout to be removed")
+	}
+	if _, _, err := compilerConfig.CacheStore.Get(blogPath); err == nil {
+		t.Error("expected the cache file of the page to be removed")
+	}
+
+	if _, ok := htmlPreCache.Get("test/about"); !ok {
+		t.Error("expected the other page to be kept")
+	}
+	if _, _, err := compilerConfig.CacheStore.Get(aboutPath); err != nil {
+		t.Errorf("expected the cache file of the other page to be kept: %v", err)
+	}
+}
+
+func TestCacheSumDeps(t *testing.T) {
+	testConfig(t)
+
+	page := filepath.Join(compilerConfig.Root, "index.html")
+	layout := filepath.Join(compilerConfig.Root, "layout.html")
+	for _, file := range []string{page, layout} {
+		if err := os.WriteFile(file, []byte("<body>{{{body}}}</body>"), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cachePath := []string{filepath.Join(compilerConfig.StaticHTML, "index.html.html")}
+	if err := writeCacheSum(page, cacheObj{cachePath: cachePath, checksum: []string{"abc"}, deps: []string{layout}}); err != nil {
+		t.Fatal(err)
+	}
+
+	sumPath := filepath.Join(compilerConfig.StaticHTML, "index.html.cache.md5sum")
+	sum, valid := readCacheSum(sumPath)
+	if sum == nil || !valid {
+		t.Fatal("expected a valid md5sum")
+	}
+	if len(sum.deps) != 1 || sum.deps[0] != layout || sum.files[".html"] != "abc" {
+		t.Errorf("unexpected md5sum: %v %v", sum.deps, sum.files)
+	}
+
+	// paths are stored relative to the Root dir, so instances with a different Root can share the md5sum
+	if data, _, err := compilerConfig.CacheStore.Get(sumPath); err != nil || !strings.HasPrefix(string(data), "index.html\n") || !strings.Contains(string(data), "\nlayout.html\n") {
+		t.Errorf("expected Root relative paths: %q %v", data, err)
+	}
+
+	origRoot := compilerConfig.Root
+	compilerConfig.Root = t.TempDir()
+	if _, valid := readCacheSum(sumPath); valid {
+		t.Error("expected the md5sum to be invalid without the source files")
+	}
+	for _, name := range []string{"index.html", "layout.html"} {
+		if err := os.WriteFile(filepath.Join(compilerConfig.Root, name), []byte("<body>{{{body}}}</body>"), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sum, valid := readCacheSum(sumPath); !valid || sum.deps[0] != filepath.Join(compilerConfig.Root, "layout.html") {
+		t.Errorf("expected the md5sum to be valid with a different Root: %v", sum)
+	}
+	compilerConfig.Root = origRoot
+
+	// a change to a dependency invalidates the page
+	if err := os.WriteFile(layout, []byte("<body><main>{{{body}}}</main></body>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if _, valid := readCacheSum(sumPath); valid {
+		t.Error("expected the md5sum to be invalid after a dependency changed")
+	}
+}
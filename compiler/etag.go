@@ -0,0 +1,159 @@
+package compiler
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Metadata describes the result of CompileMeta, for handling conditional requests
+type Metadata struct {
+	// A strong ETag for the result (including the quotes)
+	//
+	// static pages use the md5 checksum of the cache file, and dynamic pages use the md5 checksum of the compiled output
+	ETag string
+
+	// The time the page was compiled
+	//
+	// pages are compiled again when the view, or a component, layout, markdown file, or asset it depends on changes
+	ModTime time.Time
+
+	// The compression of the result ("br", "gz", or "" for uncompressed)
+	Encoding string
+
+	// Weather or not the result is static html (the same for every request)
+	Static bool
+}
+
+// withMetadata adds the Metadata to the result of a compile
+func withMetadata(path string, cache cacheObj, res []byte, staticPath string, compType uint8, err error) ([]byte, string, uint8, Metadata, error) {
+	if err != nil {
+		return res, staticPath, compType, Metadata{}, err
+	}
+
+	meta := Metadata{
+		ModTime: cache.modTime,
+		Static:  cache.static,
+	}
+
+	if compType == 1 {
+		meta.Encoding = "br"
+	} else if compType == 2 {
+		meta.Encoding = "gz"
+	}
+
+	if staticPath == "" {
+		meta.ETag = `"` + checkSumMD5(res) + `"`
+		return res, staticPath, compType, meta, nil
+	}
+
+	for i, p := range cache.cachePath {
+		if p != staticPath {
+			continue
+		}
+
+		if i < len(cache.checksum) && cache.checksum[i] != "" {
+			meta.ETag = `"` + cache.checksum[i] + `"`
+			break
+		}
+
+		// the checksum may not be known if the file could not be read when the page was added
+		sum, err := getCheckSumMD5(p)
+		if err != nil {
+			break
+		}
+		meta.ETag = `"` + string(sum) + `"`
+
+		updatePreCache(path, cache, func(cur *cacheObj) {
+			checksum := make([]string, len(cur.cachePath))
+			copy(checksum, cur.checksum)
+			checksum[i] = string(sum)
+			cur.checksum = checksum
+		})
+		break
+	}
+
+	return res, staticPath, compType, meta, nil
+}
+
+// sourceModTime returns the latest modification time of a list of files
+//
+// this is used as the compile time of pages loaded from an md5sum file that does not have one
+func sourceModTime(files []string) time.Time {
+	modTime := time.Time{}
+	for _, file := range files {
+		if stat, err := os.Stat(file); err == nil && stat.ModTime().After(modTime) {
+			modTime = stat.ModTime()
+		}
+	}
+	return modTime
+}
+
+// NotModified sets the ETag, Last-Modified, and Vary headers for the result of CompileMeta,
+// and responds with 304 Not Modified if the client already has the result cached
+//
+// the ETag differs for each compression type, so `Vary: Accept-Encoding` is always set
+//
+// If-None-Match is checked first. If-Modified-Since is only used for static pages,
+// because the output of a dynamic page can change without the view being modified.
+//
+// @bool: true if a 304 response was sent (and the result should not be written)
+func NotModified(w http.ResponseWriter, r *http.Request, meta Metadata) bool {
+	addVary(w.Header(), "Accept-Encoding")
+	if meta.ETag != "" {
+		w.Header().Set("ETag", meta.ETag)
+	}
+
+	useModTime := meta.Static && !meta.ModTime.IsZero()
+	if useModTime {
+		w.Header().Set("Last-Modified", meta.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if meta.ETag == "" || !etagMatch(match, meta.ETag) {
+			return false
+		}
+	} else if since := r.Header.Get("If-Modified-Since"); since != "" && useModTime {
+		t, err := http.ParseTime(since)
+		if err != nil || meta.ModTime.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// addVary adds a field to the Vary header, unless it (or `*`) is already listed
+//
+// the header may have been set by the caller or a middleware, with mixed case or comma separated values
+func addVary(header http.Header, field string) {
+	for _, val := range header.Values("Vary") {
+		for _, f := range strings.Split(val, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
+
+// etagMatch returns true if an If-None-Match header contains an ETag (using weak comparison)
+func etagMatch(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
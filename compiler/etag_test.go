@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	meta := Metadata{ETag: `"abc"`, ModTime: modTime, Static: true}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   bool
+	}{
+		{"etag", "If-None-Match", `"abc"`, true},
+		{"weak etag", "If-None-Match", `W/"abc", "def"`, true},
+		{"changed etag", "If-None-Match", `"def"`, false},
+		{"modified since", "If-Modified-Since", modTime.Format(http.TimeFormat), true},
+		{"modified after", "If-Modified-Since", modTime.Add(-time.Minute).Format(http.TimeFormat), false},
+		{"no header", "", "", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+
+		if res := NotModified(w, r, meta); res != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, res)
+		}
+		if test.want && w.Code != http.StatusNotModified {
+			t.Errorf("%s: expected a 304 response, got %d", test.name, w.Code)
+		}
+
+		if w.Header().Get("ETag") != `"abc"` || w.Header().Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+			t.Errorf("%s: unexpected headers: %v", test.name, w.Header())
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: expected the response to vary by encoding: %v", test.name, w.Header())
+		}
+	}
+
+	// an existing Vary header is not duplicated
+	for _, vary := range [][]string{{"Accept-Encoding"}, {"Cookie, accept-encoding"}, {"Cookie", "Accept-Encoding"}, {"*"}} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		for _, v := range vary {
+			w.Header().Add("Vary", v)
+		}
+
+		NotModified(w, r, meta)
+		NotModified(w, r, meta)
+		if got := w.Header().Values("Vary"); len(got) != len(vary) {
+			t.Errorf("expected the Vary header to be unchanged: %q", got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	w.Header().Set("Vary", "Cookie")
+	NotModified(w, httptest.NewRequest(http.MethodGet, "/", nil), meta)
+	if got := w.Header().Values("Vary"); len(got) != 2 || got[1] != "Accept-Encoding" {
+		t.Errorf("expected Accept-Encoding to be added to the Vary header: %q", got)
+	}
+}
+
+func TestCacheSumModTime(t *testing.T) {
+	testConfig(t)
+
+	page := filepath.Join(compilerConfig.Root, "index.html")
+	if err := os.WriteFile(page, []byte("<h1>Hello</h1>"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer htmlPreCache.Del(page)
+
+	// the view is older than the compiled page (ie: the page was compiled again after an asset changed)
+	if err := os.Chtimes(page, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	html := []byte("<h1>Hello</h1>")
+	key := filepath.Join(compilerConfig.StaticHTML, "index.html.html")
+	if err := compilerConfig.CacheStore.Put(key, html, CacheMeta{Page: "index", Static: true, CheckSum: checkSumMD5(html)}); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.UnixMilli(time.Now().UnixMilli())
+	if err := writeCacheSum(page, cacheObj{cachePath: []string{key}, checksum: []string{checkSumMD5(html)}, modTime: modTime}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !addCachePage("index", cacheObj{static: true, cachePath: []string{key}}) {
+		t.Fatal("expected the page to be loaded")
+	}
+	if cache, _ := htmlPreCache.Get(page); !cache.modTime.Equal(modTime) {
+		t.Errorf("expected the compile time from the md5sum: %v != %v", cache.modTime, modTime)
+	}
+}
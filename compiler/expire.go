@@ -0,0 +1,206 @@
+package compiler
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats is a snapshot of the cache counters, returned by the GetCacheStats method
+type CacheStats struct {
+	// The number of times Compile used a page from the cache
+	Hits uint64
+
+	// The number of times Compile had to run PreCompile
+	Misses uint64
+
+	// The number of pages removed from the cache because they expired, went over the CacheMaxSize, or were evicted by the CacheStore
+	Evictions uint64
+
+	// The number of pages in the cache
+	Pages int
+
+	// The total size (in bytes) of the cached files (if known), and the fragments cached by `<_cache>` tags
+	Size int64
+
+	// The number of fragments cached by `<_cache>` tags
+	Fragments int
+}
+
+var cacheHits atomic.Uint64
+var cacheMisses atomic.Uint64
+var cacheEvictions atomic.Uint64
+
+var cacheSizeMU sync.Mutex
+
+// GetCacheStats returns the hit, miss, and eviction counters of the cache, along with its current size
+func GetCacheStats() CacheStats {
+	stats := CacheStats{
+		Hits:      cacheHits.Load(),
+		Misses:    cacheMisses.Load(),
+		Evictions: cacheEvictions.Load(),
+	}
+
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		stats.Pages++
+		stats.Size += data.size
+		return true
+	})
+
+	stats.Fragments = int(htmlFragmentCache.Len())
+	stats.Size += fragmentCacheSize()
+
+	return stats
+}
+
+// htmlPreCacheMU is held while a page in the htmlPreCache is added, removed, or updated,
+// so an update to an old copy of a page cannot overwrite a newer one
+var htmlPreCacheMU sync.Mutex
+
+// setPreCache adds a page to the htmlPreCache, replacing any older version of it
+func setPreCache(path string, cache cacheObj) {
+	htmlPreCacheMU.Lock()
+	defer htmlPreCacheMU.Unlock()
+
+	htmlPreCache.Set(path, cache)
+}
+
+// updatePreCache changes a page in the htmlPreCache, unless it was replaced (or removed) since it was read
+//
+// @cache: the page as it was read from the htmlPreCache
+func updatePreCache(path string, cache cacheObj, update func(cur *cacheObj)) {
+	htmlPreCacheMU.Lock()
+	defer htmlPreCacheMU.Unlock()
+
+	if cur, ok := htmlPreCache.Get(path); ok && cur.modTime.Equal(cache.modTime) && reflect.DeepEqual(cur.cachePath, cache.cachePath) {
+		update(&cur)
+		htmlPreCache.Set(path, cur)
+	}
+}
+
+// touchPreCache updates the last access time of a page in the htmlPreCache
+//
+// the page is only updated once per minute, and not if it was replaced by PreCompile in the meantime
+func touchPreCache(path string, cache cacheObj) {
+	now := int(time.Now().UnixMilli() / 60000)
+	if cache.accessed == now {
+		return
+	}
+
+	updatePreCache(path, cache, func(cur *cacheObj) {
+		cur.accessed = now
+	})
+}
+
+// optCacheTime returns the `@cacheTime` option of a page in minutes (0 = use the CacheTime config)
+//
+// the option can be a number of minutes, or a duration string (ie: "90s", "5m", "2h")
+func optCacheTime(opts map[string]interface{}) int {
+	switch val := opts["@cacheTime"].(type) {
+	case int:
+		return val
+	case int64:
+		return int(val)
+	case float64:
+		return int(math.Ceil(val))
+	case string:
+		if d, err := time.ParseDuration(val); err == nil {
+			return int(math.Ceil(d.Minutes()))
+		}
+	}
+	return 0
+}
+
+// cacheFileSize returns the total size of the cache files of a page on the filesystem
+//
+// files in other cache stores are not read just to get their size, so they return 0 (unknown).
+// the size of these pages is stored in their md5sum file instead
+func cacheFileSize(cachePath []string) int64 {
+	if !usesFileStore() {
+		return 0
+	}
+
+	var size int64
+	for _, file := range cachePath {
+		if stat, err := os.Stat(file); err == nil {
+			size += stat.Size()
+		}
+	}
+	return size
+}
+
+// expirePreCache removes the pages that have not been accessed for longer than their cache time
+//
+// @now: the current time in minutes
+func expirePreCache(now int) {
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		cacheTime := data.cacheTime
+		if cacheTime == 0 {
+			cacheTime = compilerConfig.CacheTime
+		}
+
+		if cacheTime > 0 && now-data.accessed > cacheTime {
+			purgePreCache(path, data)
+			cacheEvictions.Add(1)
+		}
+		return true
+	})
+}
+
+// limitCacheSize removes the least recently used pages until the cache files fit in the CacheMaxSize
+//
+// cached fragments also count towards the CacheMaxSize, and are removed before any pages are
+//
+// @skip: a page that should not be removed (ie: the page that was just precompiled)
+func limitCacheSize(skip string) {
+	if compilerConfig.CacheMaxSize == 0 {
+		return
+	}
+
+	cacheSizeMU.Lock()
+	defer cacheSizeMU.Unlock()
+
+	type cachePage struct {
+		path string
+		data cacheObj
+	}
+
+	var size int64
+	pages := []cachePage{}
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		size += data.size
+		if path != skip {
+			pages = append(pages, cachePage{path, data})
+		}
+		return true
+	})
+
+	// fragments are removed first, since they are cheaper to compile again than a page
+	if size < compilerConfig.CacheMaxSize {
+		removeFragments(compilerConfig.CacheMaxSize - size)
+	} else {
+		removeFragments(0)
+	}
+
+	if size <= compilerConfig.CacheMaxSize {
+		return
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].data.accessed < pages[j].data.accessed
+	})
+
+	for _, page := range pages {
+		if size <= compilerConfig.CacheMaxSize {
+			break
+		}
+
+		purgePreCache(page.path, page.data)
+		cacheEvictions.Add(1)
+		size -= page.data.size
+	}
+}
@@ -0,0 +1,34 @@
+package compiler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTouchPreCache(t *testing.T) {
+	defer htmlPreCache.Del("test/touch")
+
+	old := cacheObj{cachePath: []string{"/html.static/touch.html.html"}, static: true, modTime: time.Now().Add(-time.Minute), tags: []string{"old"}}
+	htmlPreCache.Set("test/touch", old)
+
+	touchPreCache("test/touch", old)
+	if cur, _ := htmlPreCache.Get("test/touch"); cur.accessed != int(time.Now().UnixMilli()/60000) {
+		t.Errorf("expected the access time to be updated: %d", cur.accessed)
+	}
+
+	// a page compiled again to the same files is not replaced by an old copy of it
+	cur := cacheObj{cachePath: old.cachePath, static: true, modTime: time.Now(), tags: []string{"new"}}
+	setPreCache("test/touch", cur)
+
+	touchPreCache("test/touch", old)
+	if res, _ := htmlPreCache.Get("test/touch"); len(res.tags) != 1 || res.tags[0] != "new" || res.accessed != 0 {
+		t.Errorf("expected the new page to be kept: %v", res)
+	}
+
+	// a removed page is not added back
+	purgePreCache("test/touch", cacheObj{})
+	touchPreCache("test/touch", cur)
+	if _, ok := htmlPreCache.Get("test/touch"); ok {
+		t.Error("expected the removed page not to be added back")
+	}
+}
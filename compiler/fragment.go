@@ -0,0 +1,213 @@
+package compiler
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+	"github.com/alphadose/haxmap"
+)
+
+type fragmentObj struct {
+	// the cache file of the page that stored the fragment
+	page string
+
+	// the compiled html (uncompressed, so it can be added to any compressed output)
+	html []byte
+
+	expires time.Time
+
+	// the tags of the fragment, for InvalidateTag
+	tags []string
+
+	// the last time the fragment was used (in unix nanoseconds), for removing the least recently used fragments
+	accessed *atomic.Int64
+}
+
+// size returns the number of bytes a fragment uses in the cache
+func (frag fragmentObj) size(key string) int64 {
+	return int64(len(key) + len(frag.html))
+}
+
+// htmlFragmentCache stores the compiled content of `<_cache>` tags
+//
+// fragments are kept in memory, and are stored uncompressed so a single entry can be
+// written to the brotli, gzip, and uncompressed output of a page.
+// the size of the cache is limited by the FragmentMaxSize and CacheMaxSize configs
+var htmlFragmentCache *haxmap.Map[string, fragmentObj] = haxmap.New[string, fragmentObj]()
+
+// fragmentKey returns the key of a `<_cache>` tag, with any {{vars}} in the key replaced by their values
+//
+// keys are scoped to the page, so different pages can use the same key for different content
+//
+// @page: the cache file of the page
+func fragmentKey(page string, key []byte, opts *map[string]interface{}, eachArgs *[]EachArgs) string {
+	key = regex.Comp(`\{\{\{?([^{}]+)\}\}\}?`).RepFunc(key, func(data func(int) []byte) []byte {
+		return goutil.Conv.ToBytes(GetOpt(data(1), opts, eachArgs, 0, false, true))
+	})
+
+	return page + "\x00" + string(key)
+}
+
+// fragmentTTL returns the `ttl` arg of a `<_cache>` tag
+//
+// the ttl can be a number of minutes, or a duration string (ie: "90s", "5m", "2h").
+// by default, fragments use the CacheTime config
+func fragmentTTL(ttl []byte) time.Duration {
+	if len(ttl) != 0 {
+		if n, err := strconv.ParseFloat(string(ttl), 64); err == nil {
+			return time.Duration(math.Ceil(n * float64(time.Minute)))
+		} else if d, err := time.ParseDuration(string(ttl)); err == nil {
+			return d
+		}
+	}
+
+	return time.Duration(compilerConfig.CacheTime) * time.Minute
+}
+
+// getFragment returns a cached fragment, if it has not expired
+func getFragment(key string) ([]byte, bool) {
+	frag, ok := htmlFragmentCache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	if !frag.expires.IsZero() && time.Now().After(frag.expires) {
+		htmlFragmentCache.Del(key)
+		return nil, false
+	}
+
+	frag.accessed.Store(time.Now().UnixNano())
+	return frag.html, true
+}
+
+// setFragment adds a compiled fragment to the cache
+//
+// @ttl: how long the fragment should be kept (0 = until the page is removed from the cache)
+func setFragment(key string, page string, html []byte, ttl time.Duration, tags []string) {
+	frag := fragmentObj{
+		page: page,
+		html: html,
+		tags: tags,
+
+		accessed: &atomic.Int64{},
+	}
+	frag.accessed.Store(time.Now().UnixNano())
+
+	if ttl > 0 {
+		frag.expires = time.Now().Add(ttl)
+	}
+
+	htmlFragmentCache.Set(key, frag)
+
+	// keys can include request vars, so the number of fragments is not limited by the number of pages
+	limitFragmentSize()
+}
+
+// fragmentCacheSize returns the total size of the fragments in the cache
+func fragmentCacheSize() int64 {
+	var size int64
+	htmlFragmentCache.ForEach(func(key string, frag fragmentObj) bool {
+		size += frag.size(key)
+		return true
+	})
+	return size
+}
+
+// limitFragmentSize removes the least recently used fragments until they fit in the FragmentMaxSize,
+// and until the pages and fragments together fit in the CacheMaxSize
+func limitFragmentSize() {
+	maxSize := compilerConfig.FragmentMaxSize
+	if compilerConfig.CacheMaxSize != 0 {
+		var pageSize int64
+		htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+			pageSize += data.size
+			return true
+		})
+
+		budget := compilerConfig.CacheMaxSize - pageSize
+		if budget < 0 {
+			budget = 0
+		}
+
+		if maxSize <= 0 || budget < maxSize {
+			maxSize = budget
+		}
+	} else if maxSize <= 0 {
+		return
+	}
+
+	cacheSizeMU.Lock()
+	defer cacheSizeMU.Unlock()
+
+	removeFragments(maxSize)
+}
+
+// removeFragments removes the least recently used fragments until they fit in a size
+//
+// cacheSizeMU should be held by the caller
+//
+// @int64: the size of the fragments that were kept
+func removeFragments(maxSize int64) int64 {
+	type cacheFragment struct {
+		key      string
+		size     int64
+		accessed int64
+	}
+
+	var size int64
+	frags := []cacheFragment{}
+	htmlFragmentCache.ForEach(func(key string, frag fragmentObj) bool {
+		fragSize := frag.size(key)
+		size += fragSize
+		frags = append(frags, cacheFragment{key, fragSize, frag.accessed.Load()})
+		return true
+	})
+
+	if size <= maxSize {
+		return size
+	}
+
+	sort.Slice(frags, func(i, j int) bool {
+		return frags[i].accessed < frags[j].accessed
+	})
+
+	for _, frag := range frags {
+		if size <= maxSize {
+			break
+		}
+
+		htmlFragmentCache.Del(frag.key)
+		size -= frag.size
+	}
+
+	return size
+}
+
+// expireFragments removes the fragments that have expired
+func expireFragments(now time.Time) {
+	htmlFragmentCache.ForEach(func(key string, frag fragmentObj) bool {
+		if !frag.expires.IsZero() && now.After(frag.expires) {
+			htmlFragmentCache.Del(key)
+		}
+		return true
+	})
+}
+
+// purgeFragments removes the fragments that were stored by a list of cache files
+func purgeFragments(pages []string) {
+	if len(pages) == 0 {
+		return
+	}
+
+	htmlFragmentCache.ForEach(func(key string, frag fragmentObj) bool {
+		if goutil.Contains(pages, frag.page) {
+			htmlFragmentCache.Del(key)
+		}
+		return true
+	})
+}
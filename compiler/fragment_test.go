@@ -0,0 +1,71 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func clearFragments() {
+	htmlFragmentCache.ForEach(func(key string, frag fragmentObj) bool {
+		htmlFragmentCache.Del(key)
+		return true
+	})
+}
+
+func TestFragmentMaxSize(t *testing.T) {
+	testConfig(t)
+	clearFragments()
+	defer clearFragments()
+
+	html := bytes.Repeat([]byte("a"), 100)
+
+	// each fragment uses 106 bytes (the key and the html)
+	compilerConfig.FragmentMaxSize = 320
+	for i, key := range []string{"test:a", "test:b", "test:c"} {
+		setFragment(key, "", html, 0, nil)
+		frag, _ := htmlFragmentCache.Get(key)
+		frag.accessed.Store(int64(i + 1))
+	}
+
+	if stats := GetCacheStats(); stats.Fragments != 3 || stats.Size != 318 {
+		t.Errorf("expected the fragments to be counted in the cache stats: %+v", stats)
+	}
+
+	// a was used more recently than b, so b is removed to make room for d
+	frag, _ := htmlFragmentCache.Get("test:a")
+	frag.accessed.Store(4)
+	setFragment("test:d", "", html, 0, nil)
+
+	if _, ok := htmlFragmentCache.Get("test:b"); ok {
+		t.Error("expected the least recently used fragment to be removed")
+	}
+	for _, key := range []string{"test:a", "test:c", "test:d"} {
+		if _, ok := htmlFragmentCache.Get(key); !ok {
+			t.Errorf("expected %s to be kept", key)
+		}
+	}
+
+	// fragments count towards the CacheMaxSize, along with the pages
+	compilerConfig.FragmentMaxSize = -1
+	compilerConfig.CacheMaxSize = 300
+	htmlPreCache.Set("test/fragments", cacheObj{size: 100})
+	defer htmlPreCache.Del("test/fragments")
+
+	setFragment("test:e", "", html, 0, nil)
+	if stats := GetCacheStats(); stats.Fragments != 1 || stats.Size > compilerConfig.CacheMaxSize {
+		t.Errorf("expected the fragments to fit in the CacheMaxSize: %+v", stats)
+	}
+	if _, ok := getFragment("test:e"); !ok {
+		t.Error("expected the new fragment to be kept")
+	}
+
+	// fragments are removed before pages
+	compilerConfig.CacheMaxSize = 150
+	limitCacheSize("")
+	if _, ok := htmlPreCache.Get("test/fragments"); !ok {
+		t.Error("expected the page to be kept")
+	}
+	if stats := GetCacheStats(); stats.Fragments != 0 {
+		t.Errorf("expected the fragments to be removed: %+v", stats)
+	}
+}
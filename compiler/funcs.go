@@ -844,3 +844,84 @@ func (funcs *tagFuncs) Join_SYNC(opts *map[string]interface{}, args *htmlArgs, e
 	// append([]byte{1}, []byte("error message")...) = return error
 	return nil
 }
+
+// Cache caches the compiled content of a dynamic part of a page (ie: <_cache key="grid-{{category}}" ttl="5m">)
+//
+// the key can include vars, and the ttl can be a number of minutes, or a duration string.
+// the fragment can also be given tags for InvalidateTag (ie: tags="category:{{category}}").
+// the fragment is looked up and spliced into the page by the compiler, so this method only needs to store it
+func (funcs *tagFuncs) Cache(opts *map[string]interface{}, args *htmlArgs, eachArgs *[]EachArgs, precomp bool) []byte {
+	// args.args first byte:
+	// 0 = normal arg "arg"
+	// 1 = escaped option {{arg}}
+	// 2 = raw option {{{arg}}}
+
+	body := args.args["body"]
+
+	if precomp {
+		// static content does not need to be cached
+		if !bytes.Contains(body, []byte("{{")) {
+			return body
+		}
+
+		return append([]byte{0}, compilerArgs(args)...)
+	}
+
+	// the compiler replaces the key with the page and the values of its vars
+	if key := args.args["key"]; len(key) > 1 && key[0] == 0 {
+		if page, _, ok := bytes.Cut(key[1:], []byte{0}); ok {
+			var ttl []byte
+			if len(args.args["ttl"]) != 0 && args.args["ttl"][0] == 0 {
+				ttl = args.args["ttl"][1:]
+			}
+
+			var tags []string
+			if len(args.args["tags"]) != 0 && args.args["tags"][0] == 0 {
+				tags = splitTags(args.args["tags"][1:])
+			}
+
+			setFragment(string(key[1:]), string(page), body, fragmentTTL(ttl), tags)
+		}
+	}
+
+	if len(body) == 0 {
+		return nil
+	}
+
+	// return nil = return nothing
+	// []byte("result html") = return basic html
+	// append([]byte{0}, []byte("args")...) = pass function to compiler
+	// append([]byte{1}, []byte("error message")...) = return error
+	return body
+}
+
+// compilerArgs converts the args of a tag function back into an args string, for passing the function to the compiler
+func compilerArgs(args *htmlArgs) []byte {
+	// args.args first byte:
+	// 0 = normal arg "arg"
+	// 1 = escaped option {{arg}}
+	// 2 = raw option {{{arg}}}
+
+	res := []byte{}
+	for _, key := range args.ind {
+		val := args.args[key]
+		if len(val) == 0 {
+			continue
+		}
+
+		if val[0] == 1 {
+			val = regex.JoinBytes([]byte("{{"), val[1:], []byte("}}"))
+		} else if val[0] == 2 {
+			val = regex.JoinBytes([]byte("{{{"), val[1:], []byte("}}}"))
+		} else {
+			val = val[1:]
+		}
+
+		if len(res) != 0 {
+			res = append(res, ' ')
+		}
+		res = append(res, regex.JoinBytes([]byte(key), '=', '"', bytes.ReplaceAll(val, []byte{'"'}, []byte("&quot;")), '"')...)
+	}
+
+	return res
+}
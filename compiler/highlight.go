@@ -0,0 +1,756 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// hlLang defines the rules the syntax highlighter uses for a language
+type hlLang struct {
+	lineComment  [][]byte
+	blockComment [][2][]byte
+	quotes       []byte
+	keywords     map[string]bool
+	types        map[string]bool
+	literals     map[string]bool
+
+	// mode: 0 = code, 1 = html, 2 = css, 3 = yaml
+	mode uint8
+}
+
+// hlToken is a single highlighted piece of a code block
+type hlToken struct {
+	class string
+	text  []byte
+}
+
+// HighlightCSSFile is the name of the themable css file that is added to the static dir for highlighted code blocks
+const HighlightCSSFile = "turbx-highlight.css"
+
+func hlWords(words ...string) map[string]bool {
+	res := map[string]bool{}
+	for _, w := range words {
+		res[w] = true
+	}
+	return res
+}
+
+var hlJsKeywords = []string{"break", "case", "catch", "class", "const", "continue", "debugger", "default", "delete", "do", "else", "export", "extends", "finally", "for", "from", "function", "if", "import", "in", "instanceof", "let", "new", "of", "return", "static", "super", "switch", "this", "throw", "try", "typeof", "var", "void", "while", "with", "yield", "async", "await"}
+
+var hlLangList map[string]*hlLang = map[string]*hlLang{
+	"go": {
+		lineComment:  [][]byte{[]byte("//")},
+		blockComment: [][2][]byte{{[]byte("/*"), []byte("*/")}},
+		quotes:       []byte{'"', '\'', '`'},
+		keywords:     hlWords("break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var"),
+		types:        hlWords("any", "bool", "byte", "complex64", "complex128", "error", "float32", "float64", "int", "int8", "int16", "int32", "int64", "rune", "string", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "append", "cap", "close", "copy", "delete", "len", "make", "new", "panic", "print", "println", "recover"),
+		literals:     hlWords("true", "false", "nil", "iota"),
+	},
+	"js": {
+		lineComment:  [][]byte{[]byte("//")},
+		blockComment: [][2][]byte{{[]byte("/*"), []byte("*/")}},
+		quotes:       []byte{'"', '\'', '`'},
+		keywords:     hlWords(hlJsKeywords...),
+		types:        hlWords("Array", "Boolean", "Date", "Error", "JSON", "Map", "Math", "Number", "Object", "Promise", "RegExp", "Set", "String", "Symbol", "console", "document", "window"),
+		literals:     hlWords("true", "false", "null", "undefined", "NaN", "Infinity"),
+	},
+	"ts": {
+		lineComment:  [][]byte{[]byte("//")},
+		blockComment: [][2][]byte{{[]byte("/*"), []byte("*/")}},
+		quotes:       []byte{'"', '\'', '`'},
+		keywords:     hlWords(append([]string{"abstract", "as", "declare", "enum", "implements", "interface", "keyof", "namespace", "private", "protected", "public", "readonly", "type"}, hlJsKeywords...)...),
+		types:        hlWords("any", "boolean", "never", "number", "object", "string", "symbol", "unknown", "void", "Array", "Date", "Error", "JSON", "Map", "Math", "Promise", "Record", "Partial", "Set", "console", "document", "window"),
+		literals:     hlWords("true", "false", "null", "undefined", "NaN", "Infinity"),
+	},
+	"bash": {
+		lineComment: [][]byte{[]byte("#")},
+		quotes:      []byte{'"', '\''},
+		keywords:    hlWords("if", "then", "else", "elif", "fi", "for", "while", "until", "do", "done", "case", "esac", "in", "function", "return", "exit", "export", "local", "readonly", "source"),
+		types:       hlWords("echo", "cd", "ls", "cp", "mv", "rm", "mkdir", "cat", "grep", "sed", "awk", "sudo", "apt", "apt-get", "go", "git", "npm", "test", "set", "unset", "read", "printf"),
+		literals:    hlWords("true", "false"),
+	},
+	"json": {
+		quotes:   []byte{'"'},
+		literals: hlWords("true", "false", "null"),
+	},
+	"css": {
+		blockComment: [][2][]byte{{[]byte("/*"), []byte("*/")}},
+		quotes:       []byte{'"', '\''},
+		mode:         2,
+	},
+	// less and scss also have line comments (css does not, so `url(https://...)` is not a comment)
+	"scss": {
+		lineComment:  [][]byte{[]byte("//")},
+		blockComment: [][2][]byte{{[]byte("/*"), []byte("*/")}},
+		quotes:       []byte{'"', '\''},
+		mode:         2,
+	},
+	"less": {
+		lineComment:  [][]byte{[]byte("//")},
+		blockComment: [][2][]byte{{[]byte("/*"), []byte("*/")}},
+		quotes:       []byte{'"', '\''},
+		mode:         2,
+	},
+	"html": {
+		mode: 1,
+	},
+	"yaml": {
+		lineComment: [][]byte{[]byte("#")},
+		quotes:      []byte{'"', '\''},
+		literals:    hlWords("true", "false", "null", "yes", "no", "on", "off", "~"),
+		mode:        3,
+	},
+}
+
+var hlLangAlias map[string]string = map[string]string{
+	"golang":     "go",
+	"javascript": "js",
+	"jsx":        "js",
+	"mjs":        "js",
+	"typescript": "ts",
+	"tsx":        "ts",
+	"sh":         "bash",
+	"shell":      "bash",
+	"zsh":        "bash",
+	"htm":        "html",
+	"xml":        "html",
+	"svg":        "html",
+	"sass":       "scss",
+	"yml":        "yaml",
+}
+
+// getHighlightLang returns the highlighter rules for a language name (or alias)
+func getHighlightLang(lang []byte) (*hlLang, string) {
+	name := string(bytes.ToLower(bytes.TrimSpace(lang)))
+	if alias, ok := hlLangAlias[name]; ok {
+		name = alias
+	}
+
+	if l, ok := hlLangList[name]; ok {
+		return l, name
+	}
+	return nil, name
+}
+
+// parseHighlightLines parses the line list of a fenced code block (ie: ```go {3,5-7})
+//
+// the list may also include `ln` or `nln` to enable or disable line numbers for that block
+func parseHighlightLines(meta []byte) (map[int]bool, int8) {
+	lines := map[int]bool{}
+	lineNumbers := int8(0)
+
+	meta = bytes.Trim(bytes.TrimSpace(meta), "{}")
+	for _, part := range regex.Comp(`[\s,]+`).Split(meta) {
+		if len(part) == 0 {
+			continue
+		}
+
+		if bytes.Equal(part, []byte("ln")) {
+			lineNumbers = 1
+			continue
+		} else if bytes.Equal(part, []byte("nln")) {
+			lineNumbers = -1
+			continue
+		}
+
+		rng := bytes.SplitN(part, []byte{'-'}, 2)
+		start, err := strconv.Atoi(string(rng[0]))
+		if err != nil {
+			continue
+		}
+
+		end := start
+		if len(rng) == 2 {
+			if end, err = strconv.Atoi(string(rng[1])); err != nil || end < start {
+				end = start
+			}
+		}
+
+		for i := start; i <= end && i-start < 10000; i++ {
+			lines[i] = true
+		}
+	}
+
+	return lines, lineNumbers
+}
+
+// highlightCode runs the syntax highlighter on a code block
+//
+// returns nil if the language is not supported
+func highlightCode(lang []byte, code []byte, hlLines map[int]bool, lineNumbers bool) []byte {
+	rules, name := getHighlightLang(lang)
+	if rules == nil {
+		return nil
+	}
+
+	code = bytes.TrimPrefix(code, []byte{'\r'})
+	code = bytes.TrimPrefix(code, []byte{'\n'})
+	code = bytes.TrimRight(code, " \t\r\n")
+	code = regex.Comp(`\r\n?`).RepStrRef(&code, []byte{'\n'})
+
+	var tokens []hlToken
+	switch rules.mode {
+	case 1:
+		tokens = hlTokenizeHTML(code)
+	case 2:
+		tokens = hlTokenizeCSS(code, rules)
+	default:
+		tokens = hlTokenizeCode(code, rules)
+	}
+
+	return hlRender(tokens, name, hlLines, lineNumbers)
+}
+
+// hlRender merges the tokens into html, splitting any spans that cross a line break
+func hlRender(tokens []hlToken, lang string, hlLines map[int]bool, lineNumbers bool) []byte {
+	wrapLines := lineNumbers || len(hlLines) != 0
+
+	res := regex.JoinBytes([]byte("<pre class=\"hl\"><code lang=\""), goutil.HTML.EscapeArgs([]byte(lang), '"'), []byte("\" class=\"hl-"), goutil.HTML.EscapeArgs([]byte(lang), '"'), []byte("\">"))
+
+	lineNum := 1
+	openLine := func() {
+		if !wrapLines {
+			return
+		}
+
+		if hlLines[lineNum] {
+			res = append(res, []byte("<span class=\"hl-line hl-mark\">")...)
+		} else {
+			res = append(res, []byte("<span class=\"hl-line\">")...)
+		}
+
+		if lineNumbers {
+			res = append(res, regex.JoinBytes([]byte("<span class=\"hl-ln\">"), lineNum, []byte("</span>"))...)
+		}
+	}
+
+	openLine()
+	for _, tok := range tokens {
+		lines := bytes.Split(tok.text, []byte{'\n'})
+		for i, line := range lines {
+			if i != 0 {
+				if wrapLines {
+					res = append(res, []byte("</span>")...)
+				}
+				res = append(res, '\n')
+				lineNum++
+				openLine()
+			}
+
+			if len(line) == 0 {
+				continue
+			}
+
+			if tok.class == "" {
+				res = append(res, hlEscape(line)...)
+			} else {
+				res = append(res, regex.JoinBytes([]byte("<span class=\"hl-"), tok.class, []byte("\">"), hlEscape(line), []byte("</span>"))...)
+			}
+		}
+	}
+
+	if wrapLines {
+		res = append(res, []byte("</span>")...)
+	}
+
+	return append(res, []byte("</code></pre>")...)
+}
+
+// hlEscape escapes html in a code token
+//
+// curly braces are also escaped, so code examples cannot be read as template vars by the compiler
+func hlEscape(b []byte) []byte {
+	b = goutil.HTML.Escape(b)
+	b = bytes.ReplaceAll(b, []byte("{"), []byte("&#123;"))
+	return bytes.ReplaceAll(b, []byte("}"), []byte("&#125;"))
+}
+
+func hlIsWordChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func hlIsDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// hlReadString reads a quoted string starting at code[i]
+func hlReadString(code []byte, i int) int {
+	q := code[i]
+	j := i + 1
+	for j < len(code) && code[j] != q {
+		if code[j] == '\\' {
+			j++
+		} else if code[j] == '\n' && q != '`' {
+			return j
+		}
+		j++
+	}
+	if j < len(code) {
+		j++
+	}
+	return j
+}
+
+// hlReadComment returns the end index of a line or block comment that starts at index i
+//
+// returns i if there is no comment at the index
+func hlReadComment(code []byte, i int, rules *hlLang) int {
+	for _, lc := range rules.lineComment {
+		if bytes.HasPrefix(code[i:], lc) && (lc[0] != '#' || i == 0 || code[i-1] == ' ' || code[i-1] == '\t' || code[i-1] == '\n') {
+			j := bytes.IndexByte(code[i:], '\n')
+			if j == -1 {
+				return len(code)
+			}
+			return i + j
+		}
+	}
+
+	for _, bc := range rules.blockComment {
+		if bytes.HasPrefix(code[i:], bc[0]) {
+			j := bytes.Index(code[i+len(bc[0]):], bc[1])
+			if j == -1 {
+				return len(code)
+			}
+			return i + j + len(bc[0]) + len(bc[1])
+		}
+	}
+
+	return i
+}
+
+// hlTokenizeCode handles c-like languages, bash, json, and yaml
+func hlTokenizeCode(code []byte, rules *hlLang) []hlToken {
+	tokens := []hlToken{}
+	plain := []byte{}
+
+	push := func(class string, text []byte) {
+		if len(plain) != 0 {
+			tokens = append(tokens, hlToken{text: plain})
+			plain = []byte{}
+		}
+		tokens = append(tokens, hlToken{class: class, text: text})
+	}
+
+	lineStart := true
+	for i := 0; i < len(code); {
+		c := code[i]
+
+		// comments
+		if j := hlReadComment(code, i, rules); j != i {
+			push("comment", code[i:j])
+			i = j
+			continue
+		}
+
+		// yaml keys
+		if rules.mode == 3 && lineStart && c != ' ' && c != '\t' && c != '-' {
+			if j := bytes.IndexByte(code[i:], '\n'); j != 0 {
+				line := code[i:]
+				if j != -1 {
+					line = code[i : i+j]
+				}
+				if k := bytes.Index(line, []byte(": ")); k > 0 || bytes.HasSuffix(line, []byte{':'}) {
+					if k <= 0 {
+						k = len(line) - 1
+					}
+					push("attr", code[i:i+k])
+					i += k
+					lineStart = false
+					continue
+				}
+			}
+		}
+
+		if c == '\n' {
+			lineStart = true
+		} else if c != ' ' && c != '\t' && !(rules.mode == 3 && c == '-') {
+			lineStart = false
+		}
+
+		// strings
+		if goutil.Contains(rules.quotes, c) {
+			j := hlReadString(code, i)
+
+			// json object keys
+			class := "string"
+			if rules.mode == 0 && rules.keywords == nil && rules.types == nil {
+				k := j
+				for k < len(code) && (code[k] == ' ' || code[k] == '\t') {
+					k++
+				}
+				if k < len(code) && code[k] == ':' {
+					class = "attr"
+				}
+			}
+
+			push(class, code[i:j])
+			i = j
+			continue
+		}
+
+		// numbers
+		if hlIsDigit(c) && (i == 0 || !hlIsWordChar(code[i-1])) {
+			j := i + 1
+			for j < len(code) && (hlIsWordChar(code[j]) || code[j] == '.') {
+				j++
+			}
+			push("number", code[i:j])
+			i = j
+			continue
+		}
+
+		// bash vars
+		if c == '$' && rules.lineComment != nil && rules.lineComment[0][0] == '#' {
+			j := i + 1
+			if j < len(code) && code[j] == '{' {
+				if k := bytes.IndexByte(code[j:], '}'); k != -1 {
+					j += k + 1
+				}
+			} else {
+				for j < len(code) && hlIsWordChar(code[j]) {
+					j++
+				}
+			}
+			if j > i+1 {
+				push("var", code[i:j])
+				i = j
+				continue
+			}
+		}
+
+		// words
+		if hlIsWordChar(c) {
+			j := i + 1
+			for j < len(code) && (hlIsWordChar(code[j]) || (rules.mode == 0 && code[j] == '-' && rules.lineComment != nil && rules.lineComment[0][0] == '#')) {
+				j++
+			}
+			word := string(code[i:j])
+
+			if rules.keywords[word] {
+				push("keyword", code[i:j])
+			} else if rules.types[word] {
+				push("type", code[i:j])
+			} else if rules.literals[word] {
+				push("literal", code[i:j])
+			} else if j < len(code) && code[j] == '(' {
+				push("func", code[i:j])
+			} else {
+				plain = append(plain, code[i:j]...)
+			}
+
+			i = j
+			continue
+		}
+
+		plain = append(plain, c)
+		i++
+	}
+
+	if len(plain) != 0 {
+		tokens = append(tokens, hlToken{text: plain})
+	}
+
+	return tokens
+}
+
+// hlTokenizeCSS handles css selectors, properties, and values
+func hlTokenizeCSS(code []byte, rules *hlLang) []hlToken {
+	tokens := []hlToken{}
+	plain := []byte{}
+
+	push := func(class string, text []byte) {
+		if len(plain) != 0 {
+			tokens = append(tokens, hlToken{text: plain})
+			plain = []byte{}
+		}
+		tokens = append(tokens, hlToken{class: class, text: text})
+	}
+
+	// 0 = selector, 1 = property, 2 = value
+	state := 0
+	level := 0
+	for i := 0; i < len(code); {
+		c := code[i]
+
+		if j := hlReadComment(code, i, rules); j != i {
+			push("comment", code[i:j])
+			i = j
+			continue
+		}
+
+		if goutil.Contains(rules.quotes, c) {
+			j := hlReadString(code, i)
+			push("string", code[i:j])
+			i = j
+			continue
+		}
+
+		switch c {
+		case '{':
+			level++
+			state = 1
+			plain = append(plain, c)
+			i++
+			continue
+		case '}':
+			level--
+			if level <= 0 {
+				level = 0
+				state = 0
+			} else {
+				state = 1
+			}
+			plain = append(plain, c)
+			i++
+			continue
+		case ';':
+			if level != 0 {
+				state = 1
+			}
+			plain = append(plain, c)
+			i++
+			continue
+		case '@':
+			j := i + 1
+			for j < len(code) && (hlIsWordChar(code[j]) || code[j] == '-') {
+				j++
+			}
+			push("keyword", code[i:j])
+			i = j
+			continue
+		}
+
+		if state == 0 {
+			// selectors run until the next open bracket
+			j := i
+			for j < len(code) && code[j] != '{' && code[j] != '/' && code[j] != '"' && code[j] != '\'' && code[j] != ';' {
+				j++
+			}
+			if j == i {
+				plain = append(plain, c)
+				i++
+				continue
+			}
+
+			sel := code[i:j]
+			trimmed := bytes.TrimRight(sel, " \t\r\n")
+			if len(trimmed) != 0 {
+				push("selector", trimmed)
+			}
+			plain = append(plain, sel[len(trimmed):]...)
+			i = j
+			continue
+		} else if state == 1 {
+			if hlIsWordChar(c) || c == '-' {
+				j := i
+				for j < len(code) && (hlIsWordChar(code[j]) || code[j] == '-') {
+					j++
+				}
+
+				k := j
+				for k < len(code) && (code[k] == ' ' || code[k] == '\t') {
+					k++
+				}
+
+				if k < len(code) && code[k] == ':' {
+					push("attr", code[i:j])
+					plain = append(plain, code[j:k+1]...)
+					i = k + 1
+					state = 2
+				} else {
+					// nested selector (scss/less)
+					for k < len(code) && code[k] != '{' && code[k] != ';' && code[k] != '}' {
+						k++
+					}
+					push("selector", bytes.TrimRight(code[i:k], " \t\r\n"))
+					plain = append(plain, code[i+len(bytes.TrimRight(code[i:k], " \t\r\n")):k]...)
+					i = k
+				}
+				continue
+			}
+		} else if state == 2 {
+			if hlIsDigit(c) || (c == '.' && i+1 < len(code) && hlIsDigit(code[i+1])) || (c == '#' && i+1 < len(code) && hlIsWordChar(code[i+1])) {
+				j := i + 1
+				for j < len(code) && (hlIsWordChar(code[j]) || code[j] == '.' || code[j] == '%') {
+					j++
+				}
+				push("number", code[i:j])
+				i = j
+				continue
+			} else if hlIsWordChar(c) || c == '-' {
+				j := i
+				for j < len(code) && (hlIsWordChar(code[j]) || code[j] == '-') {
+					j++
+				}
+				if j < len(code) && code[j] == '(' {
+					push("func", code[i:j])
+				} else if bytes.Equal(code[i:j], []byte("important")) && i != 0 && code[i-1] == '!' {
+					push("keyword", code[i:j])
+				} else {
+					push("literal", code[i:j])
+				}
+				i = j
+				continue
+			}
+		}
+
+		plain = append(plain, c)
+		i++
+	}
+
+	if len(plain) != 0 {
+		tokens = append(tokens, hlToken{text: plain})
+	}
+
+	return tokens
+}
+
+// hlTokenizeHTML handles html tags, attributes, and comments
+func hlTokenizeHTML(code []byte) []hlToken {
+	tokens := []hlToken{}
+	plain := []byte{}
+
+	push := func(class string, text []byte) {
+		if len(plain) != 0 {
+			tokens = append(tokens, hlToken{text: plain})
+			plain = []byte{}
+		}
+		tokens = append(tokens, hlToken{class: class, text: text})
+	}
+
+	for i := 0; i < len(code); {
+		if bytes.HasPrefix(code[i:], []byte("<!--")) {
+			j := bytes.Index(code[i+4:], []byte("-->"))
+			if j == -1 {
+				j = len(code) - i
+			} else {
+				j += 7
+			}
+			push("comment", code[i:i+j])
+			i += j
+			continue
+		}
+
+		if code[i] == '<' && i+1 < len(code) && (code[i+1] == '/' || code[i+1] == '!' || hlIsWordChar(code[i+1])) {
+			j := i + 1
+			if code[j] == '/' || code[j] == '!' {
+				j++
+			}
+			for j < len(code) && (hlIsWordChar(code[j]) || code[j] == '-' || code[j] == ':' || code[j] == '.') {
+				j++
+			}
+			push("tag", code[i:j])
+			i = j
+
+			// attributes
+			for i < len(code) && code[i] != '>' {
+				c := code[i]
+				if c == '"' || c == '\'' {
+					j := i + 1
+					for j < len(code) && code[j] != c {
+						j++
+					}
+					if j < len(code) {
+						j++
+					}
+					push("string", code[i:j])
+					i = j
+				} else if hlIsWordChar(c) || c == '-' || c == ':' || c == '@' {
+					j := i
+					for j < len(code) && (hlIsWordChar(code[j]) || code[j] == '-' || code[j] == ':' || code[j] == '@' || code[j] == '.') {
+						j++
+					}
+					push("attr", code[i:j])
+					i = j
+				} else if c == '/' && i+1 < len(code) && code[i+1] == '>' {
+					break
+				} else {
+					plain = append(plain, c)
+					i++
+				}
+			}
+
+			if i < len(code) {
+				if code[i] == '/' {
+					push("tag", code[i:i+2])
+					i += 2
+				} else {
+					push("tag", code[i:i+1])
+					i++
+				}
+			}
+			continue
+		}
+
+		plain = append(plain, code[i])
+		i++
+	}
+
+	if len(plain) != 0 {
+		tokens = append(tokens, hlToken{text: plain})
+	}
+
+	return tokens
+}
+
+// highlightThemeCSS is the default theme written to `Config.Static` for highlighted code blocks
+//
+// the file is only written when it does not already exist, so it can be edited to change the theme
+var highlightThemeCSS []byte = []byte(`/* turbx code highlight theme (this file can be edited to change the theme) */
+pre.hl {
+  padding: 0.75em 1em;
+  overflow: auto;
+  background-color: #1e1e2e;
+  color: #cdd6f4;
+  border-radius: 0.5em;
+  line-height: 1.5;
+  tab-size: 2;
+}
+
+pre.hl code {
+  font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace;
+}
+
+pre.hl .hl-line {
+  display: inline-block;
+  min-width: 100%;
+}
+
+pre.hl .hl-mark {
+  background-color: rgba(249, 226, 175, 0.15);
+}
+
+pre.hl .hl-ln {
+  display: inline-block;
+  width: 3ch;
+  margin-right: 1em;
+  color: #6c7086;
+  text-align: right;
+  user-select: none;
+}
+
+pre.hl .hl-comment {color: #6c7086; font-style: italic;}
+pre.hl .hl-keyword {color: #cba6f7;}
+pre.hl .hl-type {color: #f9e2af;}
+pre.hl .hl-literal {color: #fab387;}
+pre.hl .hl-number {color: #fab387;}
+pre.hl .hl-string {color: #a6e3a1;}
+pre.hl .hl-func {color: #89b4fa;}
+pre.hl .hl-var {color: #f38ba8;}
+pre.hl .hl-attr {color: #89dceb;}
+pre.hl .hl-tag {color: #f38ba8;}
+pre.hl .hl-selector {color: #f9e2af;}
+`)
+
+// writeHighlightTheme adds the default highlight theme to the static dir (if it does not already exist)
+func writeHighlightTheme() {
+	if path, err := goutil.FS.JoinPath(compilerConfig.Static, HighlightCSSFile); err == nil {
+		if _, err := os.Stat(path); err != nil {
+			os.WriteFile(path, highlightThemeCSS, 0775)
+		}
+	}
+}
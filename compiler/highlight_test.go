@@ -0,0 +1,62 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHighlightCode(t *testing.T) {
+	tests := []struct {
+		lang string
+		code string
+		want []string
+	}{
+		{"go", `func main() { return nil } // done`, []string{`<code lang="go" class="hl-go">`, `<span class="hl-keyword">func</span>`, `<span class="hl-func">main</span>`, `<span class="hl-literal">nil</span>`, `<span class="hl-comment">// done</span>`}},
+		{"javascript", `let s = "a<b"; x(1)`, []string{`class="hl-js"`, `<span class="hl-keyword">let</span>`, `<span class="hl-string">`, `a&lt;b`, `<span class="hl-number">1</span>`}},
+		{"json", `{"key": true}`, []string{`<span class="hl-attr">`, `<span class="hl-literal">true</span>`}},
+		{"sh", `echo $HOME # comment`, []string{`class="hl-bash"`, `<span class="hl-var">$HOME</span>`, `<span class="hl-comment"># comment</span>`}},
+		{"scss", `a { b: 1; } // note`, []string{`class="hl-scss"`, `<span class="hl-comment">// note</span>`}},
+	}
+
+	for _, test := range tests {
+		res := highlightCode([]byte(test.lang), []byte(test.code), nil, false)
+		if res == nil {
+			t.Errorf("%s: expected the language to be supported", test.lang)
+			continue
+		}
+
+		for _, want := range test.want {
+			if !bytes.Contains(res, []byte(want)) {
+				t.Errorf("%s: expected %s in: %s", test.lang, want, res)
+			}
+		}
+	}
+
+	// css has no line comments, so a url is not highlighted as a comment
+	if res := highlightCode([]byte("css"), []byte(`a { background: url(https://example.com/a.png); } /* note */`), nil, false); bytes.Contains(res, []byte(`<span class="hl-comment">//`)) || !bytes.Contains(res, []byte(`<span class="hl-comment">/* note */</span>`)) {
+		t.Errorf("unexpected css comments: %s", res)
+	}
+
+	if res := highlightCode([]byte("unknownlang"), []byte("code"), nil, false); res != nil {
+		t.Errorf("expected nil for an unsupported language: %s", res)
+	}
+}
+
+func TestHighlightLines(t *testing.T) {
+	lines, lineNumbers := parseHighlightLines([]byte("{2, 4-5 ln}"))
+	if lineNumbers != 1 || len(lines) != 3 || !lines[2] || !lines[4] || !lines[5] {
+		t.Errorf("unexpected lines: %v %d", lines, lineNumbers)
+	}
+
+	if _, lineNumbers := parseHighlightLines([]byte("{nln}")); lineNumbers != -1 {
+		t.Errorf("expected line numbers to be disabled: %d", lineNumbers)
+	}
+
+	res := highlightCode([]byte("go"), []byte("a\nb\nc"), map[int]bool{2: true}, true)
+	want := `<span class="hl-line"><span class="hl-ln">1</span>a</span>` + "\n" +
+		`<span class="hl-line hl-mark"><span class="hl-ln">2</span>b</span>` + "\n" +
+		`<span class="hl-line"><span class="hl-ln">3</span>c</span>`
+	if !bytes.Contains(res, []byte(want)) {
+		t.Errorf("unexpected line wrapping: %s", res)
+	}
+}
@@ -0,0 +1,279 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+	"github.com/alphadose/haxmap"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// imageVariants stores the intrinsic size of a source image, and the responsive variants that were created for it
+type imageVariants struct {
+	width  int
+	height int
+
+	// widths smaller than the original image, that have variants
+	widths []int
+
+	// true if an .avif variant was created
+	avif bool
+}
+
+// staticImages maps source image urls to their responsive variants
+var staticImages *haxmap.Map[string, imageVariants] = haxmap.New[string, imageVariants]()
+
+// probeImageSize returns the width and height of an image
+func probeImageSize(path string) (int, int) {
+	res, err := ffmpeg.Probe(path)
+	if err != nil {
+		return 0, 0
+	}
+
+	info := struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+	}{}
+	if err := json.Unmarshal([]byte(res), &info); err != nil {
+		return 0, 0
+	}
+
+	for _, stream := range info.Streams {
+		if stream.Width != 0 && stream.Height != 0 {
+			return stream.Width, stream.Height
+		}
+	}
+	return 0, 0
+}
+
+// imageVariantPath returns the path of a responsive image variant
+//
+// example: photo.jpg -> photo.640w.webp
+//
+// @width: 0 = original size
+func imageVariantPath(path string, width int, ext string) string {
+	if width == 0 {
+		return string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte("."+ext)))
+	}
+	return string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte("."+strconv.Itoa(width)+"w."+ext)))
+}
+
+// mediaFresh returns true if a converted file exists, and is not older than its source file
+//
+// this allows tryMinifyDir to skip running ffmpeg on files that were already converted before a restart
+func mediaFresh(path string, resPath string) bool {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	resStat, err := os.Stat(resPath)
+	return err == nil && !resStat.IsDir() && resStat.Size() != 0 && !resStat.ModTime().Before(stat.ModTime())
+}
+
+// imageVariantWidths returns the widths of the responsive variants of an image that exist in its directory
+//
+// this is used to rebuild the variant list from the disk, since staticImages is empty after a restart
+func imageVariantWidths(path string) []int {
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return []int{}
+	}
+
+	// example: photo.640w.webp
+	prefix := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "."
+
+	widths := []int{}
+	for _, file := range files {
+		name, ok := strings.CutPrefix(file.Name(), prefix)
+		if !ok {
+			continue
+		}
+
+		if size, ext, ok := strings.Cut(name, "w."); ok && (ext == "webp" || ext == "avif") {
+			if w, err := strconv.Atoi(size); err == nil && w > 0 && !goutil.Contains(widths, w) {
+				widths = append(widths, w)
+			}
+		}
+	}
+
+	sort.Ints(widths)
+	return widths
+}
+
+// tryMinifyImage converts an image to .webp and .avif, and creates smaller variants for each of the ImageWidths
+//
+// files that are newer than the source image are not converted again
+func tryMinifyImage(path string) {
+	width, height := probeImageSize(path)
+
+	variants := imageVariants{width: width, height: height}
+
+	convert := func(resPath string, outType string, w int) bool {
+		if mediaFresh(path, resPath) {
+			return true
+		}
+
+		if err := convertMedia(path, resPath, outType, w); err != nil {
+			os.Remove(resPath)
+			return false
+		}
+		return true
+	}
+
+	// original size
+	resPath := imageVariantPath(path, 0, "webp")
+	if convert(resPath, "webp", 0) {
+		hashStaticFile(path, resPath)
+	}
+
+	resPath = imageVariantPath(path, 0, "avif")
+	if convert(resPath, "avif", 0) {
+		variants.avif = true
+		hashStaticFile(resPath, resPath)
+	}
+
+	// responsive variants
+	if width != 0 {
+		widths := append([]int{}, compilerConfig.ImageWidths...)
+		sort.Ints(widths)
+
+		for _, w := range widths {
+			if w <= 0 || w >= width || goutil.Contains(variants.widths, w) {
+				continue
+			}
+
+			resPath := imageVariantPath(path, w, "webp")
+			if !convert(resPath, "webp", w) {
+				continue
+			}
+			hashStaticFile(resPath, resPath)
+
+			if variants.avif {
+				resPath := imageVariantPath(path, w, "avif")
+				if convert(resPath, "avif", w) {
+					hashStaticFile(resPath, resPath)
+				}
+			}
+
+			variants.widths = append(variants.widths, w)
+		}
+	}
+
+	// remove old variants that are no longer in use
+	for _, w := range imageVariantWidths(path) {
+		if !goutil.Contains(variants.widths, w) {
+			removeImageVariant(imageVariantPath(path, w, "webp"))
+			removeImageVariant(imageVariantPath(path, w, "avif"))
+		}
+	}
+
+	staticImages.Set(staticUrlPath(path), variants)
+}
+
+// removeImageVariants removes all of the variants created for an image
+func removeImageVariants(path string) {
+	removeHashedAsset(path)
+	os.Remove(imageVariantPath(path, 0, "webp"))
+	removeImageVariant(imageVariantPath(path, 0, "avif"))
+
+	staticImages.Del(staticUrlPath(path))
+	for _, w := range imageVariantWidths(path) {
+		removeImageVariant(imageVariantPath(path, w, "webp"))
+		removeImageVariant(imageVariantPath(path, w, "avif"))
+	}
+}
+
+func removeImageVariant(path string) {
+	removeHashedAsset(path)
+	os.Remove(path)
+}
+
+// imageVariantUrl returns the url of an image variant (preferring the content hashed file)
+func imageVariantUrl(src string, width int, ext string) string {
+	url := imageVariantPath(src, width, ext)
+
+	// the original size webp file is stored in the manifest under the source image
+	key := url
+	if width == 0 && ext == "webp" {
+		key = src
+	}
+
+	if hashUrl, ok := staticManifest.Get(key); ok {
+		return hashUrl
+	}
+	return url
+}
+
+// responsiveImage adds `width`, `height`, `srcset`, and `sizes` args to a local `<img>` tag
+//
+// if ImagePicture is enabled, the `<source>` elements for a `<picture>` wrapper will be returned instead of adding a `srcset`
+func responsiveImage(args *htmlArgs) []byte {
+	src, ok := args.args["src"]
+	if !ok || len(src) == 0 || src[0] != '/' || !imageRE.Match(src) {
+		return nil
+	}
+
+	variants, ok := staticImages.Get(string(src))
+	if !ok {
+		return nil
+	}
+
+	setArg := func(key string, val []byte) {
+		if _, ok := args.args[key]; !ok {
+			args.args[key] = val
+			args.ind = append(args.ind, key)
+		}
+	}
+
+	// intrinsic size to prevent layout shift
+	if variants.width != 0 && variants.height != 0 {
+		_, hasWidth := args.args["width"]
+		_, hasHeight := args.args["height"]
+		if !hasWidth && !hasHeight {
+			setArg("width", []byte(strconv.Itoa(variants.width)))
+			setArg("height", []byte(strconv.Itoa(variants.height)))
+		}
+	}
+
+	if len(variants.widths) == 0 {
+		return nil
+	}
+
+	srcset := func(ext string) []byte {
+		list := [][]byte{}
+		for _, w := range variants.widths {
+			list = append(list, []byte(imageVariantUrl(string(src), w, ext)+" "+strconv.Itoa(w)+"w"))
+		}
+		list = append(list, []byte(imageVariantUrl(string(src), 0, ext)+" "+strconv.Itoa(variants.width)+"w"))
+		return bytes.Join(list, []byte(", "))
+	}
+
+	sizes, ok := args.args["sizes"]
+	if !ok {
+		sizes = []byte("(max-width: " + strconv.Itoa(variants.width) + "px) 100vw, " + strconv.Itoa(variants.width) + "px")
+	}
+
+	if !compilerConfig.ImagePicture {
+		setArg("srcset", srcset("webp"))
+		setArg("sizes", sizes)
+		return nil
+	}
+
+	picture := []byte("<picture>")
+	if variants.avif {
+		picture = append(picture, regex.JoinBytes([]byte(`<source type="image/avif" srcset="`), srcset("avif"), []byte(`" sizes="`), goutil.HTML.EscapeArgs(sizes, '"'), []byte(`">`))...)
+	}
+	picture = append(picture, regex.JoinBytes([]byte(`<source type="image/webp" srcset="`), srcset("webp"), []byte(`" sizes="`), goutil.HTML.EscapeArgs(sizes, '"'), []byte(`">`))...)
+
+	return picture
+}
@@ -0,0 +1,50 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImageVariants(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.jpg")
+
+	if res := imageVariantPath(path, 640, "webp"); res != filepath.Join(dir, "photo.640w.webp") {
+		t.Errorf("unexpected variant path: %s", res)
+	}
+	if res := imageVariantPath(path, 0, "avif"); res != filepath.Join(dir, "photo.avif") {
+		t.Errorf("unexpected variant path: %s", res)
+	}
+
+	for _, name := range []string{"photo.jpg", "photo.webp", "photo.1280w.webp", "photo.640w.webp", "photo.640w.avif", "photo.1280w.jpg", "photos.320w.webp", "other.320w.webp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the variant list can be rebuilt from the disk after a restart
+	if widths := imageVariantWidths(path); !reflect.DeepEqual(widths, []int{640, 1280}) {
+		t.Errorf("unexpected widths: %v", widths)
+	}
+
+	resPath := imageVariantPath(path, 0, "webp")
+	if !mediaFresh(path, resPath) {
+		t.Error("expected a converted file with the same modTime to be fresh")
+	}
+
+	// a source file that changed after it was converted needs to be converted again
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if mediaFresh(path, resPath) {
+		t.Error("expected a converted file older than the source to not be fresh")
+	}
+
+	if mediaFresh(path, imageVariantPath(path, 320, "webp")) {
+		t.Error("expected a missing file to not be fresh")
+	}
+}
@@ -0,0 +1,244 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+	"github.com/alphadose/haxmap"
+)
+
+// the amount of the page body (in bytes) to consider above the fold for critical css
+const inlineCriticalSize = 14 * 1024
+
+// inlinePage is a static page that has a static asset inlined into it
+type inlinePage struct {
+	path string
+	opts map[string]interface{}
+}
+
+// inlinedAssets maps static asset urls to the pages they are inlined into
+var inlinedAssets *haxmap.Map[string, []inlinePage] = haxmap.New[string, []inlinePage]()
+
+var inlinedAssetsMU sync.Mutex
+
+// inlineAssets embeds local css and js files into a static html page
+//
+// assets are inlined if the tag has an `inline` arg, or if the `@inline` option is set for the page
+//
+// a value of "critical" will only inline the css used above the fold, and load the full stylesheet asynchronously
+//
+// @path: the page path passed to PreCompile
+func inlineAssets(html []byte, body []byte, path string, opts map[string]interface{}) []byte {
+	inlineAll := false
+	criticalAll := false
+	if val, ok := opts["@inline"]; ok {
+		if b, ok := val.(bool); ok {
+			inlineAll = b
+		} else if str, ok := val.(string); ok && str != "" {
+			inlineAll = true
+			criticalAll = str == "critical"
+		}
+	}
+
+	var critical *cssSelectors
+	inlined := []string{}
+
+	// getAsset returns the contents of a local asset that should be inlined
+	getAsset := func(tag []byte, arg string) ([]byte, string, bool) {
+		val, hasInline := htmlTagArg(tag, "inline")
+		if !hasInline && !inlineAll {
+			return nil, "", false
+		}
+
+		url, _ := htmlTagArg(tag, arg)
+		if len(url) < 2 || url[0] != '/' || url[1] == '/' {
+			return nil, "", false
+		}
+
+		assetPath, err := goutil.FS.JoinPath(compilerConfig.Static, string(url))
+		if err != nil {
+			return nil, "", false
+		}
+
+		content, err := os.ReadFile(assetPath)
+		if err != nil {
+			return nil, "", false
+		}
+
+		// source maps cannot be resolved from an inline asset
+		content = regex.Comp(`\n?(?://|/\*)# sourceMappingURL=[^\n]*$`).RepStr(content, []byte{})
+
+		inlined = append(inlined, string(url))
+		return content, string(url), (hasInline && string(val) == "critical") || (!hasInline && criticalAll)
+	}
+
+	html = regex.Comp(`(?i)<link\s[^>]*>`).RepFunc(html, func(data func(int) []byte) []byte {
+		tag := data(0)
+		if rel, _ := htmlTagArg(tag, "rel"); !bytes.EqualFold(rel, []byte("stylesheet")) {
+			return tag
+		}
+
+		css, url, isCritical := getAsset(tag, "href")
+		if css == nil {
+			return removeInlineArg(tag)
+		}
+		css = rewriteCSSUrls(css, url)
+
+		var media []byte
+		mediaVal, _ := htmlTagArg(tag, "media")
+		if len(mediaVal) != 0 {
+			media = regex.JoinBytes([]byte(` media="`), goutil.HTML.EscapeArgs(mediaVal, '"'), '"')
+		}
+
+		if isCritical {
+			if critical == nil {
+				critical = criticalSelectors(body)
+			}
+
+			css = pruneCSS(css, critical, pruneCSSSafelistRE())
+			if len(css) > compilerConfig.InlineMaxSize {
+				return removeInlineArg(tag)
+			}
+
+			if len(mediaVal) == 0 {
+				mediaVal = []byte("all")
+			}
+
+			return regex.JoinBytes(
+				[]byte("<style"), media, '>', escapeInlineAsset(css, "style"), []byte("</style>"),
+				[]byte(`<link rel="stylesheet" href="`), goutil.HTML.EscapeArgs([]byte(url), '"'), []byte(`" media="print" onload="this.media='`), goutil.HTML.EscapeArgs(goutil.HTML.EscapeArgs(mediaVal, '\''), '"'), []byte(`'">`),
+				[]byte("<noscript>"), removeInlineArg(tag), []byte("</noscript>"),
+			)
+		}
+
+		if len(css) > compilerConfig.InlineMaxSize {
+			return removeInlineArg(tag)
+		}
+		return regex.JoinBytes([]byte("<style"), media, '>', escapeInlineAsset(css, "style"), []byte("</style>"))
+	})
+
+	html = regex.Comp(`(?i)<script\s[^>]*>\s*</script>`).RepFunc(html, func(data func(int) []byte) []byte {
+		tag := data(0)
+
+		// an inline script runs before the rest of the page is parsed, so deferred and async scripts are not inlined
+		// (module scripts are always deferred)
+		typ, _ := htmlTagArg(tag, "type")
+		if !bytes.EqualFold(typ, []byte("module")) {
+			if _, ok := htmlTagArg(tag, "defer"); ok {
+				return removeInlineArg(tag)
+			} else if _, ok := htmlTagArg(tag, "async"); ok {
+				return removeInlineArg(tag)
+			}
+		}
+
+		js, _, _ := getAsset(tag, "src")
+		if js == nil || len(js) > compilerConfig.InlineMaxSize {
+			return removeInlineArg(tag)
+		}
+
+		res := []byte("<script")
+		if len(typ) != 0 {
+			res = append(res, regex.JoinBytes([]byte(` type="`), goutil.HTML.EscapeArgs(typ, '"'), '"')...)
+		}
+		if nonce, ok := htmlTagArg(tag, "nonce"); ok && len(nonce) != 0 {
+			res = append(res, regex.JoinBytes([]byte(` nonce="`), goutil.HTML.EscapeArgs(nonce, '"'), '"')...)
+		}
+
+		return regex.JoinBytes(res, '>', escapeInlineAsset(js, "script"), []byte("</script>"))
+	})
+
+	if len(inlined) != 0 {
+		pageOpts, err := goutil.JSON.DeepCopy(opts)
+		if err != nil {
+			pageOpts = map[string]interface{}{}
+		}
+		delete(pageOpts, "$body")
+
+		inlinedAssetsMU.Lock()
+		for _, url := range inlined {
+			pages, _ := inlinedAssets.Get(url)
+
+			found := false
+			for i, page := range pages {
+				if page.path == path {
+					pages[i].opts = pageOpts
+					found = true
+					break
+				}
+			}
+			if !found {
+				pages = append(pages, inlinePage{path: path, opts: pageOpts})
+			}
+
+			inlinedAssets.Set(url, pages)
+		}
+		inlinedAssetsMU.Unlock()
+	}
+
+	return html
+}
+
+// removeInlineArg removes the `inline` arg from a tag that is not inlined
+func removeInlineArg(tag []byte) []byte {
+	return regex.Comp(`(?i)\sinline(?:\s*=\s*(?:"[^"]*"|'[^']*'))?([\s/>])`).RepFunc(tag, func(data func(int) []byte) []byte {
+		return data(1)
+	})
+}
+
+// criticalSelectors returns the selectors used above the fold of a page body
+func criticalSelectors(body []byte) *cssSelectors {
+	if len(body) > inlineCriticalSize {
+		body = body[:inlineCriticalSize]
+	}
+
+	used := newCSSSelectors()
+	used.tags["html"] = true
+	used.tags["body"] = true
+	used.addHTML(body)
+
+	return used
+}
+
+// rewriteCSSUrls resolves the relative `url()` references in a css file against the url of the file,
+// so they still work when the css is moved into a page
+//
+// example: `url(img/bg.png)` in "/css/style.min.css" -> `url(/css/img/bg.png)`
+func rewriteCSSUrls(css []byte, url string) []byte {
+	dir := path.Dir(url)
+
+	return regex.Comp(`(?i)\burl\(\s*(["']?)([^"'\)]*)["']?\s*\)`).RepFunc(css, func(data func(int) []byte) []byte {
+		link := data(2)
+		if len(link) == 0 || link[0] == '/' || link[0] == '#' || regex.Comp(`^[A-Za-z][\w\+\-\.]*:`).Match(link) {
+			return data(0)
+		}
+
+		return regex.JoinBytes([]byte("url("), data(1), path.Join(dir, string(link)), data(1), ')')
+	})
+}
+
+// escapeInlineAsset prevents the contents of an inline asset from closing its tag early
+func escapeInlineAsset(content []byte, tag string) []byte {
+	return regex.Comp(`(?i)</(%1)`, tag).RepStrComp(content, []byte(`<\/$1`))
+}
+
+// regenerateInlinedPages runs PreCompile again for the pages that an asset is inlined into
+//
+// pages are compiled with precompileOnce, so an asset change does not race with a request (or another asset change) for the same page
+func regenerateInlinedPages(url string) {
+	inlinedAssetsMU.Lock()
+	pages, ok := inlinedAssets.Get(url)
+	inlinedAssets.Del(url)
+	inlinedAssetsMU.Unlock()
+
+	if !ok {
+		return
+	}
+
+	for _, page := range pages {
+		go precompileOnce(page.path, page.opts)
+	}
+}
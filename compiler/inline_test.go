@@ -0,0 +1,79 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteCSSUrls(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`a{background:url(img/bg.png)}`, `a{background:url(/css/img/bg.png)}`},
+		{`a{background:url("../img/bg.png?v=1")}`, `a{background:url("/img/bg.png?v=1")}`},
+		{`@font-face{src:url('fonts/a.woff2') format("woff2")}`, `@font-face{src:url('/css/fonts/a.woff2') format("woff2")}`},
+		{`a{background:url(/img/bg.png)}`, `a{background:url(/img/bg.png)}`},
+		{`a{background:url(https://example.com/bg.png)}`, `a{background:url(https://example.com/bg.png)}`},
+		{`a{background:url(data:image/png;base64,AAAA)}`, `a{background:url(data:image/png;base64,AAAA)}`},
+		{`a{filter:url(#blur)}`, `a{filter:url(#blur)}`},
+	}
+
+	for _, test := range tests {
+		if res := string(rewriteCSSUrls([]byte(test.src), "/css/style.min.css")); res != test.want {
+			t.Errorf("\n got: %s\nwant: %s", res, test.want)
+		}
+	}
+}
+
+func TestInlineAssets(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.InlineMaxSize = 4096
+	defer inlinedAssets.Del("/css/style.min.css")
+	defer inlinedAssets.Del("/app.min.js")
+
+	if err := os.MkdirAll(filepath.Join(compilerConfig.Static, "css"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(compilerConfig.Static, "css", "style.min.css"), []byte(".a{background:url(img/bg.png)}"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(compilerConfig.Static, "app.min.js"), []byte("if(a</script>b){}"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	html := []byte(`<link rel="stylesheet" href="/css/style.min.css" inline><script src="/app.min.js" inline></script><script src="/other.min.js"></script>`)
+	res := string(inlineAssets(html, nil, "index", map[string]interface{}{}))
+
+	want := `<style>.a{background:url(/css/img/bg.png)}</style><script>if(a<\/script>b){}</script><script src="/other.min.js"></script>`
+	if res != want {
+		t.Errorf("\n got: %s\nwant: %s", res, want)
+	}
+
+	// the page is recompiled when an inlined asset changes
+	for _, url := range []string{"/css/style.min.css", "/app.min.js"} {
+		if pages, ok := inlinedAssets.Get(url); !ok || len(pages) != 1 || pages[0].path != "index" {
+			t.Errorf("expected %s to be tracked: %v", url, pages)
+		}
+	}
+	if _, ok := inlinedAssets.Get("/other.min.js"); ok {
+		t.Error("expected assets without an inline arg not to be tracked")
+	}
+
+	// deferred and async scripts are linked as usual, unless they are modules
+	res = string(inlineAssets([]byte(`<script src="/app.min.js" defer inline></script><script src="/app.min.js" inline async></script><script type="module" src="/app.min.js" inline></script>`), nil, "index", map[string]interface{}{}))
+	want = `<script src="/app.min.js" defer></script><script src="/app.min.js" async></script><script type="module">if(a<\/script>b){}</script>`
+	if res != want {
+		t.Errorf("\n got: %s\nwant: %s", res, want)
+	}
+
+	// assets over InlineMaxSize are linked as usual
+	compilerConfig.InlineMaxSize = 10
+	res = string(inlineAssets(html, nil, "index", map[string]interface{}{}))
+	if !strings.HasPrefix(res, `<link rel="stylesheet" href="/css/style.min.css">`) {
+		t.Errorf("expected a large asset not to be inlined: %s", res)
+	}
+}
@@ -0,0 +1,142 @@
+package compiler
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"os"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+	"github.com/alphadose/haxmap"
+)
+
+type integrityObj struct {
+	modTime int64
+	size    int64
+	hash    string
+}
+
+// staticIntegrity caches the subresource integrity hash of static files by url
+var staticIntegrity *haxmap.Map[string, integrityObj] = haxmap.New[string, integrityObj]()
+
+// integrityHash returns the subresource integrity value for a local static file (ie: "sha384-...")
+//
+// the hash is cached until the file is modified
+func integrityHash(url string) string {
+	path, err := goutil.FS.JoinPath(compilerConfig.Static, url)
+	if err != nil {
+		return ""
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil || stat.IsDir() {
+		staticIntegrity.Del(url)
+		return ""
+	}
+
+	if cache, ok := staticIntegrity.Get(url); ok && cache.modTime == stat.ModTime().UnixNano() && cache.size == stat.Size() {
+		return cache.hash
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha512.Sum384(data)
+	hash := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+
+	staticIntegrity.Set(url, integrityObj{
+		modTime: stat.ModTime().UnixNano(),
+		size:    stat.Size(),
+		hash:    hash,
+	})
+
+	return hash
+}
+
+// integrityLink returns the local url that should have an `integrity` arg for an html tag
+//
+// this includes `<script src>` and `<link rel="stylesheet|preload|modulepreload" href>`.
+// only content hashed urls get an `integrity` arg, since the hash is cached with the compiled page,
+// and a file with an unhashed url can change without the page being recompiled
+func integrityLink(args *htmlArgs) []byte {
+	if _, ok := args.args["integrity"]; ok {
+		return nil
+	}
+
+	var link []byte
+	if bytes.Equal(args.tag, []byte("script")) {
+		link = args.args["src"]
+	} else if bytes.Equal(args.tag, []byte("link")) && regex.Comp(`(?i)^(stylesheet|preload|modulepreload)$`).Match(bytes.TrimSpace(args.args["rel"])) {
+		link = args.args["href"]
+	}
+
+	if len(link) < 2 || link[0] != '/' || link[1] == '/' || !isHashedAsset(string(link)) {
+		return nil
+	}
+	return link
+}
+
+// preloadHints returns `<link rel="preload">` and `<link rel="modulepreload">` tags for the local scripts and stylesheets in a page
+//
+// @skip: html that already loads some of the assets (ie: the layout)
+func preloadHints(html []byte, skip []byte) []byte {
+	hints := []byte{}
+	found := map[string]bool{}
+
+	regex.Comp(`(?i)<(script|link)\s[^>]*>`).RepFunc(html, func(data func(int) []byte) []byte {
+		tag := data(0)
+
+		var link []byte
+		var hint []byte
+		if bytes.EqualFold(data(1), []byte("script")) {
+			link, _ = htmlTagArg(tag, "src")
+			if typ, _ := htmlTagArg(tag, "type"); bytes.EqualFold(typ, []byte("module")) {
+				hint = []byte(`<link rel="modulepreload" href="`)
+			} else {
+				hint = []byte(`<link rel="preload" as="script" href="`)
+			}
+		} else if rel, _ := htmlTagArg(tag, "rel"); bytes.EqualFold(rel, []byte("stylesheet")) {
+			link, _ = htmlTagArg(tag, "href")
+			hint = []byte(`<link rel="preload" as="style" href="`)
+		}
+
+		if len(link) < 2 || link[0] != '/' || link[1] == '/' || found[string(link)] || bytes.Contains(skip, regex.JoinBytes('"', link, '"')) {
+			return nil
+		}
+		found[string(link)] = true
+
+		hint = append(hint, regex.JoinBytes(link, '"')...)
+		if integrity, _ := htmlTagArg(tag, "integrity"); len(integrity) != 0 {
+			hint = append(hint, regex.JoinBytes([]byte(` integrity="`), integrity, '"')...)
+		}
+		hints = append(hints, append(hint, '>')...)
+
+		return nil
+	})
+
+	return hints
+}
+
+// addPreloadHints adds preload hints for the assets used by the page body into the `<head>` of the layout
+func addPreloadHints(html []byte, body []byte) []byte {
+	if compilerConfig.DebugMode {
+		return html
+	}
+
+	hints := preloadHints(body, html)
+	if len(hints) == 0 {
+		return html
+	}
+
+	done := false
+	return regex.Comp(`(?i)</head>`).RepFunc(html, func(data func(int) []byte) []byte {
+		if done {
+			return data(0)
+		}
+		done = true
+		return append(hints, data(0)...)
+	})
+}
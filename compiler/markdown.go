@@ -2,21 +2,26 @@ package compiler
 
 import (
 	"bytes"
+	"errors"
 	"strconv"
+	"strings"
 
-	"github.com/AspieSoft/go-liveread"
 	"github.com/AspieSoft/go-regex/v4"
 	"github.com/AspieSoft/goutil/v5"
+	"github.com/alphadose/haxmap"
 )
 
 var reLinkMD *regex.Regexp = regex.Comp(`(\!|)\[((?:"(?:\\[\\"'\']|\.)*"|'(?:\\[\\"'\']|\.)*'|\'(?:\\[\\"'\']|\.)*\'|.)*?)\]\(((?:"(?:\\[\\"'\']|\.)*"|'(?:\\[\\"'\']|\.)*'|\'(?:\\[\\"'\']|\.)*\'|.)*?)\)`)
 
+// reXssMD detects xss injection in links (shared by autolinks and the standalone markdown safe mode)
+var reXssMD *regex.Regexp = regex.Comp(`(?i)(\b)(on\S+)(\s*)=|(javascript|data|vbscript):|(<\s*)(\/*)script|style(\s*)=|(<\s*)meta|\*(.*?)[\r\n]*(.*?)\*`)
+
 type mdListData struct {
 	tab      uint
 	listType byte
 }
 
-func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw ...bool), firstChar *bool, spaces *uint, mdStore *map[string]interface{}) bool {
+func compileMarkdown(reader cacheReader, write *func(b []byte, raw ...bool), firstChar *bool, spaces *uint, mdStore *map[string]interface{}) bool {
 	buf, err := reader.Peek(1)
 	if err == nil {
 
@@ -170,6 +175,17 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 					}
 					reader.Discard(ind)
 
+					// handle task list items (ie: - [ ] todo, - [x] done)
+					liTag := []byte("<li>")
+					if len(cont) >= 3 && cont[0] == '[' && cont[2] == ']' && (cont[1] == ' ' || cont[1] == 'x' || cont[1] == 'X') && (len(cont) == 3 || cont[3] == ' ') {
+						if cont[1] == ' ' {
+							liTag = []byte("<li class=\"task-list-item\"><input type=\"checkbox\" disabled/> ")
+						} else {
+							liTag = []byte("<li class=\"task-list-item\"><input type=\"checkbox\" checked disabled/> ")
+						}
+						cont = bytes.TrimLeft(cont[3:], " ")
+					}
+
 					cont = mdHandleFonts(cont)
 
 					closing := uint8(0)
@@ -250,7 +266,7 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 							(*write)([]byte("<ol reversed>"))
 						}
 
-						(*write)(regex.JoinBytes([]byte("<li>"), cont, []byte("</li>")))
+						(*write)(regex.JoinBytes(liTag, cont, []byte("</li>")))
 					} else {
 						for (*mdStore)["listTab"].([]mdListData)[len((*mdStore)["listTab"].([]mdListData))-1].tab > *spaces {
 							if (*mdStore)["listTab"].([]mdListData)[len((*mdStore)["listTab"].([]mdListData))-1].listType == 0 {
@@ -261,7 +277,7 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 							(*mdStore)["listTab"] = (*mdStore)["listTab"].([]mdListData)[:len((*mdStore)["listTab"].([]mdListData))-1]
 						}
 
-						(*write)(regex.JoinBytes([]byte("<li>"), cont, []byte("</li>")))
+						(*write)(regex.JoinBytes(liTag, cont, []byte("</li>")))
 					}
 
 					if closing == 1 {
@@ -328,11 +344,94 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 
 				return true
 			}
+
+			// handle footnote definitions (ie: [^1]: footnote text)
+			if buf[0] == '[' {
+				if line, _, e := mdPeekLine(reader, 0); e == nil && regex.Comp(`^\[\^([^\]\s]+)\]:\s?`).MatchRef(&line) {
+					label := []byte{}
+					regex.Comp(`^\[\^([^\]\s]+)\]:\s?(.*)$`).RepFuncRef(&line, func(data func(int) []byte) []byte {
+						label = data(1)
+						mdAddFootnote(mdStore, label, mdHandleFonts(bytes.TrimSpace(data(2))))
+						return nil
+					}, true)
+
+					reader.Discard(uint(len(line)))
+					*firstChar = false
+					return true
+				}
+			}
+
+			// handle definition lists (ie: term \n: definition)
+			if buf[0] == ':' {
+				if line, next, e := mdPeekLine(reader, 0); e == nil && len(line) >= 2 && line[1] == ' ' && (*mdStore)["inDefList"] == true {
+					*firstChar = false
+
+					// keep the list open for another definition, or another term followed by a definition
+					keepOpen := false
+					if nextLine, after, e := mdPeekLine(reader, next); e == nil {
+						nextLine = bytes.TrimLeft(nextLine, " \t")
+						if bytes.HasPrefix(nextLine, []byte(": ")) {
+							keepOpen = true
+						} else if len(bytes.TrimSpace(nextLine)) != 0 {
+							if termDef, _, _ := mdPeekLine(reader, after); bytes.HasPrefix(bytes.TrimLeft(termDef, " \t"), []byte(": ")) {
+								keepOpen = true
+							}
+						}
+					}
+
+					(*write)(regex.JoinBytes([]byte("<dd>"), mdHandleFonts(bytes.TrimSpace(line[2:])), []byte("</dd>")))
+					reader.Discard(uint(len(line)))
+
+					if !keepOpen {
+						(*mdStore)["inDefList"] = false
+						(*write)([]byte("</dl>"))
+					}
+
+					return true
+				}
+			} else if buf[0] != '<' && buf[0] != '{' && buf[0] != '|' && mdPeekDefinition(reader) {
+				if line, _, e := mdPeekLine(reader, 0); e == nil && len(bytes.TrimSpace(line)) != 0 {
+					*firstChar = false
+
+					if (*mdStore)["inDefList"] != true {
+						(*mdStore)["inDefList"] = true
+						(*write)([]byte("<dl>"))
+					}
+
+					(*write)(regex.JoinBytes([]byte("<dt>"), mdHandleFonts(bytes.TrimSpace(line)), []byte("</dt>")))
+					reader.Discard(uint(len(line)))
+					return true
+				}
+			}
 		}
 
 		*firstChar = false
 		*spaces = 0
 
+		// handle math (ie: $inline$, $$display$$)
+		if buf[0] == '$' && (*mdStore)["math"] == true {
+			if fn, ok := mdBlockFuncs.Get("math"); ok {
+				if tex, size, display := mdPeekMath(reader); size != 0 {
+					if (*mdStore)["safe"] == true {
+						tex = bytes.ReplaceAll(tex, []byte("&lt;"), []byte{'<'})
+					}
+
+					meta := []byte("inline")
+					if display {
+						meta = []byte("display")
+					}
+
+					if res, err := fn(tex, meta); err == nil {
+						reader.Discard(size)
+						(*write)(res, true)
+						return true
+					} else if compilerConfig.DebugMode {
+						LogErr(err)
+					}
+				}
+			}
+		}
+
 		if buf[0] == '`' {
 			buf, err := reader.Peek(3)
 			if err == nil && buf[1] == '`' && buf[2] == '`' {
@@ -346,6 +445,21 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 					buf, err = reader.Peek(1)
 				}
 
+				// get highlighted lines (ie: ```go {3,5-7})
+				var langMeta []byte = nil
+				if err == nil && buf[0] == '{' {
+					langMeta = []byte{}
+					for err == nil && buf[0] != '}' && buf[0] != '\n' {
+						langMeta = append(langMeta, buf[0])
+						reader.Discard(1)
+						buf, err = reader.Peek(1)
+					}
+					if err == nil && buf[0] == '}' {
+						reader.Discard(1)
+					}
+				}
+				lang = bytes.TrimSpace(lang)
+
 				cont := []byte{}
 				buf, err = reader.Peek(3)
 				for err == nil && !(buf[0] == '`' && buf[1] == '`' && buf[2] == '`') {
@@ -358,10 +472,42 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 					reader.Discard(3)
 				}
 
+				// in safe mode, `<` was escaped before the markdown was compiled.
+				// block funcs and the highlighter escape html on their own, so they get the original code
+				code := cont
+				if (*mdStore)["safe"] == true {
+					code = bytes.ReplaceAll(cont, []byte("&lt;"), []byte{'<'})
+				}
+
+				// fallback for when the code is not rendered by a block func or the highlighter
+				codeFallback := func() []byte {
+					if (*mdStore)["safe"] == true {
+						return regex.JoinBytes([]byte("<code lang=\""), lang, []byte("\">"), hlEscape(code), []byte("</code>"))
+					}
+					return regex.JoinBytes([]byte("<code lang=\""), lang, []byte("\">"), cont, []byte("</code>"))
+				}
+
 				if len(lang) == 0 {
 					(*write)(regex.JoinBytes([]byte("<pre>"), cont, []byte("</pre>")), true)
+				} else if fn, ok := mdBlockFuncs.Get(string(bytes.ToLower(lang))); ok {
+					if res, err := fn(code, langMeta); err == nil {
+						(*write)(res, true)
+					} else {
+						if compilerConfig.DebugMode {
+							LogErr(err)
+							(*write)(regex.JoinBytes([]byte("<!--{{#error: "), err, []byte("}}-->")), true)
+						}
+						(*write)(regex.JoinBytes([]byte("<code lang=\""), lang, []byte("\">"), hlEscape(code), []byte("</code>")), true)
+					}
+				} else if compilerConfig.HighlightCode {
+					hlLines, lineNumbers := parseHighlightLines(langMeta)
+					if res := highlightCode(lang, code, hlLines, (compilerConfig.CodeLineNumbers && lineNumbers != -1) || lineNumbers == 1); res != nil {
+						(*write)(res, true)
+					} else {
+						(*write)(codeFallback(), true)
+					}
 				} else {
-					(*write)(regex.JoinBytes([]byte("<code lang=\""), lang, []byte("\">"), cont, []byte("</code>")), true)
+					(*write)(codeFallback(), true)
 				}
 
 				return true
@@ -405,7 +551,7 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 				}
 
 				// check for xss
-				if regex.Comp(`(?i)(\b)(on\S+)(\s*)=|(javascript|data|vbscript):|(<\s*)(\/*)script|style(\s*)=|(<\s*)meta|\*(.*?)[\r\n]*(.*?)\*`).MatchRef(&link) {
+				if reXssMD.MatchRef(&link) {
 					(*write)([]byte("<!--{{#warning: xss injection was detected}}-->"))
 					return true
 				}
@@ -530,7 +676,7 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 			var htmlArgs []byte = nil
 
 			buf, err = reader.Get(ind, 3)
-			if buf[0] == '{' && buf[1] != '{' && !(buf[1] == '\\' && buf[2] == '{') {
+			if buf[0] == '{' && buf[1] != '{' && !(buf[1] == '\\' && buf[2] == '{') && (*mdStore)["safe"] != true {
 				back := ind
 				args := map[string][]byte{}
 				css := map[string][]byte{}
@@ -708,7 +854,10 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 			}
 
 			// [data1](data2){htmlArgs}
-			if data1 != nil && data2 != nil {
+			if !isEmbed && data2 == nil && len(data1) > 1 && data1[0] == '^' {
+				// footnote reference (ie: [^1])
+				(*write)(mdHandleFootnoteRef(mdStore, data1[1:]))
+			} else if data1 != nil && data2 != nil {
 				if reLinkMD.MatchRef(&data1) {
 					data1 = reLinkMD.RepFuncRef(&data1, func(data func(int) []byte) []byte {
 						d1 := data(2)
@@ -721,7 +870,9 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 					})
 				}
 
-				if isEmbed {
+				if (*mdStore)["safe"] == true && reXssMD.MatchRef(&data2) {
+					(*write)([]byte("<!--{{#warning: xss injection was detected}}-->"))
+				} else if isEmbed {
 					(*write)(mdHandleEmbed(&data1, &data2, &htmlArgs))
 				} else {
 					(*write)(mdHandleLink(&data1, &data2, &htmlArgs))
@@ -750,7 +901,7 @@ func compileMarkdown(reader *liveread.Reader[uint8], write *func(b []byte, raw .
 // markdownCompilerNextLine runs when the main compiler finds a line break
 //
 // note: this method only runs if this is not already set to the firstChar, but is transitioning to the firstChar
-func compileMarkdownNextLine(reader *liveread.Reader[uint8], write *func(b []byte, raw ...bool), firstChar *bool, spaces *uint, mdStore *map[string]interface{}) {
+func compileMarkdownNextLine(reader cacheReader, write *func(b []byte, raw ...bool), firstChar *bool, spaces *uint, mdStore *map[string]interface{}) {
 	*firstChar = false
 	*spaces = 0
 
@@ -760,6 +911,279 @@ func compileMarkdownNextLine(reader *liveread.Reader[uint8], write *func(b []byt
 	}
 }
 
+// MarkdownBlockFunc renders a fenced code block in markdown (ie: ```mermaid)
+//
+// @code: the content of the code block (html is not escaped, even when the markdown is compiled in safe mode)
+//
+// @meta: the optional `{args}` after the language tag (for math, this is set to "inline" or "display")
+//
+// @return: html to add to the precompiled output
+type MarkdownBlockFunc func(code []byte, meta []byte) ([]byte, error)
+
+var mdBlockFuncs *haxmap.Map[string, MarkdownBlockFunc] = haxmap.New[string, MarkdownBlockFunc]()
+
+func init() {
+	mathFN := func(code []byte, meta []byte) ([]byte, error) {
+		return texToMathML(code, !bytes.Equal(meta, []byte("inline"))), nil
+	}
+	mdBlockFuncs.Set("math", mathFN)
+	mdBlockFuncs.Set("latex", mathFN)
+	mdBlockFuncs.Set("tex", mathFN)
+
+	mdBlockFuncs.Set("mermaid", func(code []byte, meta []byte) ([]byte, error) {
+		return regex.JoinBytes([]byte("<pre class=\"mermaid\">"), hlEscape(bytes.TrimSpace(code)), []byte("</pre>")), nil
+	})
+}
+
+// AddMarkdownBlockFN adds a renderer for a fenced code block language tag
+//
+// the result is added by the precompiler, so it will be cached with the rest of the page
+//
+// the built in renderers (math, latex, tex, mermaid) can be replaced by calling RemoveMarkdownBlockFN first
+func AddMarkdownBlockFN(lang string, cb MarkdownBlockFunc) error {
+	lang = strings.ToLower(lang)
+	if _, ok := mdBlockFuncs.Get(lang); ok {
+		return errors.New("the markdown block '" + lang + "' is already in use")
+	}
+
+	mdBlockFuncs.Set(lang, cb)
+	return nil
+}
+
+// RemoveMarkdownBlockFN removes a renderer for a fenced code block language tag
+func RemoveMarkdownBlockFN(lang string) {
+	mdBlockFuncs.Del(strings.ToLower(lang))
+}
+
+// mdPeekMath checks for inline ($tex$) or display ($$tex$$) math, without discarding it
+//
+// @uint: the size to discard (0 if no math was found)
+//
+// @bool: true if display math
+func mdPeekMath(reader cacheReader) ([]byte, uint, bool) {
+	buf, err := reader.Peek(2)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	if buf[1] == '$' {
+		tex := []byte{}
+		ind := uint(2)
+		buf, err = reader.Get(ind, 2)
+		for err == nil && !(buf[0] == '$' && buf[1] == '$') {
+			tex = append(tex, buf[0])
+			if buf[0] == '\\' {
+				tex = append(tex, buf[1])
+				ind++
+			}
+			ind++
+			buf, err = reader.Get(ind, 2)
+		}
+
+		if err != nil || len(bytes.TrimSpace(tex)) == 0 {
+			return nil, 0, false
+		}
+		return tex, ind + 2, true
+	}
+
+	// inline math cannot start or end with a space, and cannot be followed by a digit (ie: $5 and $10)
+	if buf[1] == ' ' || buf[1] == '\t' || buf[1] == '\n' || buf[1] == '\r' {
+		return nil, 0, false
+	}
+
+	tex := []byte{}
+	ind := uint(1)
+	buf, err = reader.Get(ind, 1)
+	for err == nil && buf[0] != '$' && buf[0] != '\n' {
+		tex = append(tex, buf[0])
+		if buf[0] == '\\' {
+			if b, e := reader.Get(ind+1, 1); e == nil && b[0] != '\n' {
+				tex = append(tex, b[0])
+				ind++
+			}
+		}
+		ind++
+		buf, err = reader.Get(ind, 1)
+	}
+
+	if err != nil || buf[0] != '$' || len(tex) == 0 || tex[len(tex)-1] == ' ' || tex[len(tex)-1] == '\t' {
+		return nil, 0, false
+	}
+
+	if b, e := reader.Get(ind+1, 1); e == nil && b[0] >= '0' && b[0] <= '9' {
+		return nil, 0, false
+	}
+
+	return tex, ind + 1, false
+}
+
+// compileMarkdownEnd runs when the main compiler reaches the end of a file
+//
+// this method closes any open markdown elements, and adds the collected footnotes to the end of the document
+func compileMarkdownEnd(write *func(b []byte, raw ...bool), mdStore *map[string]interface{}) {
+	if (*mdStore)["inBlockquote"] == 1 || (*mdStore)["inBlockquote"] == 2 {
+		(*mdStore)["inBlockquote"] = 0
+		(*write)([]byte("</blockquote>"))
+	}
+
+	if (*mdStore)["inDefList"] == true {
+		(*mdStore)["inDefList"] = false
+		(*write)([]byte("</dl>"))
+	}
+
+	footnoteList, ok := (*mdStore)["footnoteList"].([]string)
+	if !ok || len(footnoteList) == 0 {
+		return
+	}
+	footnotes, _ := (*mdStore)["footnotes"].(map[string][]byte)
+
+	res := []byte("\n<section class=\"footnotes\"><hr/><ol>")
+	for _, label := range footnoteList {
+		if _, ok := footnotes[label]; !ok {
+			continue
+		}
+
+		id := mdFootnoteID(label)
+		res = append(res, regex.JoinBytes([]byte("<li id=\"fn-"), id, []byte("\">"), footnotes[label])...)
+
+		// add a back link to each reference of the footnote
+		refCount, _ := (*mdStore)["footnoteRefCount"].(map[string]int)
+		for i := 1; i <= refCount[label]; i++ {
+			if i == 1 {
+				res = append(res, regex.JoinBytes([]byte(" <a href=\"#fnref-"), id, []byte("\" class=\"footnote-back\">&#8617;</a>"))...)
+			} else {
+				res = append(res, regex.JoinBytes([]byte(" <a href=\"#fnref-"), id, '-', i, []byte("\" class=\"footnote-back\">&#8617;<sup>"), i, []byte("</sup></a>"))...)
+			}
+		}
+
+		res = append(res, []byte("</li>")...)
+	}
+	res = append(res, []byte("</ol></section>\n")...)
+
+	(*write)(res)
+}
+
+// mdPeekLine returns the line starting at `ind`, without discarding it
+//
+// @uint: the index of the start of the next line
+func mdPeekLine(reader cacheReader, ind uint) ([]byte, uint, error) {
+	line := []byte{}
+	buf, err := reader.Get(ind, 1)
+	for err == nil && buf[0] != '\n' {
+		if buf[0] != '\r' {
+			line = append(line, buf[0])
+		}
+		ind++
+		buf, err = reader.Get(ind, 1)
+	}
+
+	if err == nil {
+		ind++
+	} else if len(line) != 0 {
+		err = nil
+	}
+
+	return line, ind, err
+}
+
+// mdPeekDefinition returns true if the next line is a definition list description (ie: `: definition`)
+//
+// the current line is not copied, so this is cheap to call at the start of every line
+func mdPeekDefinition(reader cacheReader) bool {
+	ind := uint(0)
+	buf, err := reader.Get(ind, 1)
+	for err == nil && buf[0] != '\n' {
+		ind++
+		buf, err = reader.Get(ind, 1)
+	}
+	if err != nil {
+		return false
+	}
+
+	ind++
+	buf, err = reader.Get(ind, 1)
+	for err == nil && (buf[0] == ' ' || buf[0] == '\t') {
+		ind++
+		buf, err = reader.Get(ind, 1)
+	}
+	if err != nil || buf[0] != ':' {
+		return false
+	}
+
+	buf, err = reader.Get(ind+1, 1)
+	return err == nil && buf[0] == ' '
+}
+
+// mdFootnoteID returns a footnote label that is safe to use as an html id
+func mdFootnoteID(label string) []byte {
+	return regex.Comp(`[^\w_\-]+`).RepStr([]byte(label), []byte{'-'})
+}
+
+// mdAddFootnote stores a footnote definition, to be added to the end of the document
+func mdAddFootnote(mdStore *map[string]interface{}, label []byte, cont []byte) {
+	if (*mdStore)["footnotes"] == nil {
+		(*mdStore)["footnotes"] = map[string][]byte{}
+	}
+	if (*mdStore)["footnoteList"] == nil {
+		(*mdStore)["footnoteList"] = []string{}
+	}
+
+	// referenced footnotes are already in the list
+	if !goutil.Contains((*mdStore)["footnoteList"].([]string), string(label)) {
+		(*mdStore)["footnoteList"] = append((*mdStore)["footnoteList"].([]string), string(label))
+	}
+	(*mdStore)["footnotes"].(map[string][]byte)[string(label)] = cont
+}
+
+// mdHandleFootnoteRef returns a numbered link to a footnote
+//
+// footnotes are numbered in the order they are first referenced,
+// and later references to the same footnote get a numbered id (ie: fnref-1-2)
+func mdHandleFootnoteRef(mdStore *map[string]interface{}, label []byte) []byte {
+	if (*mdStore)["footnoteRefs"] == nil {
+		(*mdStore)["footnoteRefs"] = map[string]int{}
+	}
+	refs := (*mdStore)["footnoteRefs"].(map[string]int)
+
+	num, ok := refs[string(label)]
+	if !ok {
+		num = len(refs) + 1
+		refs[string(label)] = num
+
+		// move referenced footnotes to the front of the list, in the order they were referenced
+		if (*mdStore)["footnoteList"] == nil {
+			(*mdStore)["footnoteList"] = []string{}
+		}
+		list := (*mdStore)["footnoteList"].([]string)
+		for i, l := range list {
+			if l == string(label) {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		(*mdStore)["footnoteList"] = append(list[:num-1:num-1], append([]string{string(label)}, list[num-1:]...)...)
+
+		if (*mdStore)["footnotes"] == nil {
+			(*mdStore)["footnotes"] = map[string][]byte{}
+		}
+	}
+
+	// each reference needs a unique id for the back links (ie: fnref-1, fnref-1-2)
+	if (*mdStore)["footnoteRefCount"] == nil {
+		(*mdStore)["footnoteRefCount"] = map[string]int{}
+	}
+	refCount := (*mdStore)["footnoteRefCount"].(map[string]int)
+	refCount[string(label)]++
+
+	id := mdFootnoteID(string(label))
+	refID := id
+	if refCount[string(label)] > 1 {
+		refID = regex.JoinBytes(id, '-', refCount[string(label)])
+	}
+
+	return regex.JoinBytes([]byte("<sup class=\"footnote-ref\"><a href=\"#fn-"), id, []byte("\" id=\"fnref-"), refID, []byte("\">"), num, []byte("</a></sup>"))
+}
+
 func mdHandleLink(name *[]byte, url *[]byte, htmlArgs *[]byte) []byte {
 	return regex.JoinBytes([]byte("<a href=\""), goutil.HTML.EscapeArgs(*url, '"'), '"', *htmlArgs, '>', *name, []byte("</a>"))
 }
@@ -802,3 +1226,88 @@ func mdHandleFonts(data []byte) []byte {
 
 	return data
 }
+
+// MarkdownOptions are the options for the standalone Markdown method
+type MarkdownOptions struct {
+	// Safe mode is for rendering user content (ie: comments, or CMS fields)
+	//
+	// this disables raw html and the `{htmlArgs}` attribute syntax, and checks links for xss injection
+	Safe bool
+
+	// How many spaces a tab equals
+	//
+	// default: 4
+	TabSize uint
+}
+
+// Markdown renders markdown to html, without the view pipeline
+//
+// unlike the Compile method, this does not need a file in the Root dir, and does not handle template vars or components
+func Markdown(src []byte, opts MarkdownOptions) ([]byte, error) {
+	src = regex.Comp(`\r\n?`).RepStrRef(&src, []byte{'\n'})
+
+	if opts.Safe {
+		src = bytes.ReplaceAll(src, []byte{'<'}, []byte("&lt;"))
+	}
+
+	if opts.TabSize == 0 {
+		opts.TabSize = 4
+	}
+
+	// the source is already in memory, so it is read without a temp file
+	reader := &bytesReader{data: src}
+
+	res := []byte{}
+	write := func(b []byte, raw ...bool) {
+		res = append(res, b...)
+	}
+
+	firstChar := true
+	spaces := uint(0)
+	mdStore := map[string]interface{}{"math": true}
+	if opts.Safe {
+		mdStore["safe"] = true
+	}
+
+	for {
+		b, err := reader.Peek(1)
+		if err != nil || b[0] == 0 {
+			break
+		}
+		buf := b[0]
+
+		if buf == '\n' {
+			if !firstChar {
+				compileMarkdownNextLine(reader, &write, &firstChar, &spaces, &mdStore)
+			}
+
+			write([]byte{'\n'})
+			firstChar = true
+			spaces = 0
+
+			reader.Discard(1)
+			continue
+		} else if firstChar && (buf == ' ' || buf == '\t') {
+			if buf == ' ' {
+				spaces++
+			} else {
+				spaces += opts.TabSize
+			}
+
+			reader.Discard(1)
+			continue
+		}
+
+		if compileMarkdown(reader, &write, &firstChar, &spaces, &mdStore) {
+			continue
+		}
+
+		firstChar = false
+		write([]byte{buf})
+		reader.Discard(1)
+	}
+
+	compileMarkdownEnd(&write, &mdStore)
+
+	return bytes.TrimSpace(res), nil
+}
@@ -0,0 +1,202 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarkdownHighlight(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.HighlightCode = true
+
+	html, err := Markdown([]byte("```go\nfunc main() {\n\treturn\n}\n```\n"), MarkdownOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(html, []byte(`<code lang="go" class="hl-go">`)) || !bytes.Contains(html, []byte(`<span class="hl-keyword">func</span>`)) {
+		t.Errorf("expected a highlighted code block: %s", html)
+	}
+
+	// curly braces are escaped, so the code cannot be read as template vars
+	if bytes.ContainsAny(html, "{}") {
+		t.Errorf("expected curly braces to be escaped: %s", html)
+	}
+
+	html, err = Markdown([]byte("```unknownlang\na < b\n```\n"), MarkdownOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(html, []byte(`<code lang="unknownlang">`)) || bytes.Contains(html, []byte(`class="hl`)) {
+		t.Errorf("expected an unknown language to fall back to a plain code block: %s", html)
+	}
+}
+
+func TestMarkdownSafe(t *testing.T) {
+	testConfig(t)
+
+	AddMarkdownBlockFN("testfail", func(code []byte, meta []byte) ([]byte, error) {
+		return nil, errors.New("test error")
+	})
+	defer RemoveMarkdownBlockFN("testfail")
+
+	tests := []struct {
+		name      string
+		src       string
+		highlight bool
+	}{
+		{"raw html", "hello <script>alert(1)</script>", false},
+		{"code block", "```\n<script>alert(1)</script>\n```", false},
+		{"code block with lang", "```js\n<script>alert(1)</script>\n```", false},
+		{"highlighted code block", "```js\n<script>alert(1)</script>\n```", true},
+		{"highlighted html code block", "```html\n<script>alert(1)</script>\n```", true},
+		{"unknown lang", "```unknownlang\n<script>alert(1)</script>\n```", true},
+		{"unknown lang without highlight", "```unknownlang\n<script>alert(1)</script>\n```", false},
+		{"mermaid", "```mermaid\n<script>alert(1)</script>\n```", false},
+		{"math", "```math\n<script>alert(1)</script>\n```", false},
+		{"failed block func", "```testfail\n<script>alert(1)</script>\n```", false},
+	}
+
+	for _, test := range tests {
+		compilerConfig.HighlightCode = test.highlight
+
+		html, err := Markdown([]byte(test.src), MarkdownOptions{Safe: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(bytes.ToLower(html), []byte("<script")) {
+			t.Errorf("%s: expected html to be escaped in safe mode: %s", test.name, html)
+		}
+	}
+
+	compilerConfig.HighlightCode = true
+
+	// the highlighter gets the original code, so it is not escaped twice
+	html, err := Markdown([]byte("```js\nif (a < b) {}\n```"), MarkdownOptions{Safe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(html, []byte("&lt;")) || bytes.Contains(html, []byte("&amp;lt;")) {
+		t.Errorf("expected `<` to be escaped once: %s", html)
+	}
+
+	html, err = Markdown([]byte("[click](javascript:alert(1))"), MarkdownOptions{Safe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(html, []byte("javascript:")) {
+		t.Errorf("expected xss in links to be removed in safe mode: %s", html)
+	}
+}
+
+func TestMarkdownBlockFN(t *testing.T) {
+	var code []byte
+	if err := AddMarkdownBlockFN("test", func(c []byte, meta []byte) ([]byte, error) {
+		code = c
+		return []byte("<div class=\"test\"></div>"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveMarkdownBlockFN("test")
+
+	if err := AddMarkdownBlockFN("TEST", nil); err == nil {
+		t.Error("expected an error for a block func that is already in use")
+	}
+
+	// block funcs get the original code in safe mode, and escape html on their own
+	html, err := Markdown([]byte("```test\na < b\n```"), MarkdownOptions{Safe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(html, []byte("<div class=\"test\"></div>")) {
+		t.Errorf("unexpected block func output: %s", html)
+	}
+	if !bytes.Contains(code, []byte("a < b")) {
+		t.Errorf("expected the block func to get unescaped code: %q", code)
+	}
+
+	html, err = Markdown([]byte("$$x^2$$"), MarkdownOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(html, []byte("<math")) || !bytes.Contains(html, []byte("<msup>")) {
+		t.Errorf("expected display math to render as mathml: %s", html)
+	}
+}
+
+func TestMarkdownLists(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"task list", "- [ ] todo\n- [x] done\n", "<ul><li class=\"task-list-item\"><input type=\"checkbox\" disabled/> todo</li>\n<li class=\"task-list-item\"><input type=\"checkbox\" checked disabled/> done</li></ul>"},
+		{"definition list", "Term\n: Definition one\n: Definition two\n\nAfter\n", "<dl><dt>Term</dt>\n<dd>Definition one</dd>\n<dd>Definition two</dd></dl>\n\nAfter"},
+		{"not a definition list", "Line one\nLine two\n:not a definition\n", "Line one\nLine two\n:not a definition"},
+	}
+
+	for _, test := range tests {
+		html, err := Markdown([]byte(test.src), MarkdownOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(html) != test.want {
+			t.Errorf("%s: unexpected html:\n%s", test.name, html)
+		}
+	}
+}
+
+func TestMarkdownFootnotes(t *testing.T) {
+	html, err := Markdown([]byte("Some text[^b] and more[^a]. Again[^b].\n\n[^a]: Note A.\n[^b]: Note B.\n[^c]: Note C.\n"), MarkdownOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// footnotes are numbered in the order they are referenced
+	if !bytes.Contains(html, []byte(`<sup class="footnote-ref"><a href="#fn-b" id="fnref-b">1</a></sup>`)) || !bytes.Contains(html, []byte(`<sup class="footnote-ref"><a href="#fn-a" id="fnref-a">2</a></sup>`)) {
+		t.Errorf("unexpected footnote refs: %s", html)
+	}
+
+	// each reference to the same footnote has a unique id
+	if !bytes.Contains(html, []byte(`<sup class="footnote-ref"><a href="#fn-b" id="fnref-b-2">1</a></sup>`)) {
+		t.Errorf("expected a numbered id for the second reference: %s", html)
+	}
+
+	want := `<section class="footnotes"><hr/><ol>` +
+		`<li id="fn-b">Note B. <a href="#fnref-b" class="footnote-back">&#8617;</a> <a href="#fnref-b-2" class="footnote-back">&#8617;<sup>2</sup></a></li>` +
+		`<li id="fn-a">Note A. <a href="#fnref-a" class="footnote-back">&#8617;</a></li>` +
+		`<li id="fn-c">Note C.</li>` +
+		`</ol></section>`
+	if !bytes.HasSuffix(html, []byte(want)) {
+		t.Errorf("unexpected footnotes:\n%s", html)
+	}
+}
+
+func TestMarkdownInHTMLRawText(t *testing.T) {
+	testConfig(t)
+
+	page := filepath.Join(compilerConfig.Root, "index.html")
+	src := "<p>Hello {{name}}, it costs $5 or $10</p>\n<script>\n$(a).x($(b));\nlet s = `# not a heading`\n</script>\n<style>\na{b:c}\n</style>\n<_md>\n$x^2$\n</_md>\n"
+	if err := os.WriteFile(page, []byte(src), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer htmlPreCache.Del(page)
+
+	html, _, _, err := Compile("index", map[string]interface{}{"@layout": "null", "name": "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// js and prices are not compiled as math, and markdown does not run in scripts
+	if !bytes.Contains(html, []byte("$(a).x($(b));")) || !bytes.Contains(html, []byte("it costs $5 or $10")) || !bytes.Contains(html, []byte("`# not a heading`")) {
+		t.Errorf("expected the script to be kept as is: %s", html)
+	}
+
+	// math still runs in <_md> blocks
+	if bytes.Count(html, []byte("<math")) != 1 || !bytes.Contains(html, []byte("<msup>")) {
+		t.Errorf("expected math in the markdown block: %s", html)
+	}
+}
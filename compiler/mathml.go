@@ -0,0 +1,238 @@
+package compiler
+
+import (
+	"bytes"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// list of tex commands that map to a single math symbol
+//
+// @[0]: mathml tag (mi, mo)
+//
+// @[1]: symbol
+var texSymbols map[string][2]string = map[string][2]string{
+	"alpha": {"mi", "α"}, "beta": {"mi", "β"}, "gamma": {"mi", "γ"}, "delta": {"mi", "δ"}, "epsilon": {"mi", "ϵ"}, "varepsilon": {"mi", "ε"},
+	"zeta": {"mi", "ζ"}, "eta": {"mi", "η"}, "theta": {"mi", "θ"}, "vartheta": {"mi", "ϑ"}, "iota": {"mi", "ι"}, "kappa": {"mi", "κ"},
+	"lambda": {"mi", "λ"}, "mu": {"mi", "μ"}, "nu": {"mi", "ν"}, "xi": {"mi", "ξ"}, "pi": {"mi", "π"}, "rho": {"mi", "ρ"},
+	"sigma": {"mi", "σ"}, "tau": {"mi", "τ"}, "upsilon": {"mi", "υ"}, "phi": {"mi", "ϕ"}, "varphi": {"mi", "φ"}, "chi": {"mi", "χ"},
+	"psi": {"mi", "ψ"}, "omega": {"mi", "ω"}, "Gamma": {"mi", "Γ"}, "Delta": {"mi", "Δ"}, "Theta": {"mi", "Θ"}, "Lambda": {"mi", "Λ"},
+	"Xi": {"mi", "Ξ"}, "Pi": {"mi", "Π"}, "Sigma": {"mi", "Σ"}, "Phi": {"mi", "Φ"}, "Psi": {"mi", "Ψ"}, "Omega": {"mi", "Ω"},
+	"infty": {"mi", "∞"}, "partial": {"mi", "∂"}, "nabla": {"mi", "∇"}, "emptyset": {"mi", "∅"}, "hbar": {"mi", "ℏ"}, "ell": {"mi", "ℓ"},
+
+	"times": {"mo", "×"}, "cdot": {"mo", "⋅"}, "div": {"mo", "÷"}, "pm": {"mo", "±"}, "mp": {"mo", "∓"}, "ast": {"mo", "∗"},
+	"leq": {"mo", "≤"}, "le": {"mo", "≤"}, "geq": {"mo", "≥"}, "ge": {"mo", "≥"}, "neq": {"mo", "≠"}, "ne": {"mo", "≠"},
+	"approx": {"mo", "≈"}, "equiv": {"mo", "≡"}, "sim": {"mo", "∼"}, "propto": {"mo", "∝"}, "ll": {"mo", "≪"}, "gg": {"mo", "≫"},
+	"in": {"mo", "∈"}, "notin": {"mo", "∉"}, "subset": {"mo", "⊂"}, "subseteq": {"mo", "⊆"}, "supset": {"mo", "⊃"}, "cup": {"mo", "∪"},
+	"cap": {"mo", "∩"}, "forall": {"mo", "∀"}, "exists": {"mo", "∃"}, "neg": {"mo", "¬"}, "land": {"mo", "∧"}, "lor": {"mo", "∨"},
+	"to": {"mo", "→"}, "rightarrow": {"mo", "→"}, "leftarrow": {"mo", "←"}, "Rightarrow": {"mo", "⇒"}, "Leftarrow": {"mo", "⇐"},
+	"leftrightarrow": {"mo", "↔"}, "iff": {"mo", "⇔"}, "implies": {"mo", "⇒"}, "mapsto": {"mo", "↦"},
+	"sum": {"mo", "∑"}, "prod": {"mo", "∏"}, "int": {"mo", "∫"}, "iint": {"mo", "∬"}, "oint": {"mo", "∮"},
+	"ldots": {"mo", "…"}, "cdots": {"mo", "⋯"}, "vdots": {"mo", "⋮"}, "ddots": {"mo", "⋱"},
+	"langle": {"mo", "⟨"}, "rangle": {"mo", "⟩"}, "lbrace": {"mo", "{"}, "rbrace": {"mo", "}"}, "{": {"mo", "{"}, "}": {"mo", "}"},
+}
+
+// list of tex commands for named functions (ie: \sin x)
+var texFuncNames []string = []string{"sin", "cos", "tan", "sec", "csc", "cot", "arcsin", "arccos", "arctan", "sinh", "cosh", "tanh", "log", "ln", "exp", "lim", "max", "min", "sup", "inf", "det", "gcd", "deg", "dim", "ker", "arg"}
+
+// texToMathML converts a subset of tex into mathml
+//
+// the original tex is kept in an annotation, so a client side renderer can still be used for anything this does not support
+func texToMathML(tex []byte, display bool) []byte {
+	tex = bytes.TrimSpace(tex)
+
+	pos := 0
+	body := texParseRow(tex, &pos, 0)
+
+	mode := []byte("inline")
+	if display {
+		mode = []byte("block")
+	}
+
+	return regex.JoinBytes(
+		[]byte("<math display=\""), mode, []byte("\" class=\"math math-"), mode, []byte("\"><semantics><mrow>"), body, []byte("</mrow>"),
+		[]byte("<annotation encoding=\"application/x-tex\">"), hlEscape(tex), []byte("</annotation></semantics></math>"),
+	)
+}
+
+// texParseRow parses tex until the end of the group
+//
+// @end: the closing char for the current group (0 = end of tex)
+func texParseRow(tex []byte, pos *int, end byte) []byte {
+	res := []byte{}
+	for *pos < len(tex) {
+		c := tex[*pos]
+		if end != 0 && c == end {
+			*pos++
+			break
+		}
+
+		atom := texParseAtom(tex, pos)
+		if atom == nil {
+			continue
+		}
+
+		// handle sub and super scripts
+		var sub, sup []byte
+		for *pos < len(tex) && (tex[*pos] == '_' || tex[*pos] == '^') {
+			isSub := tex[*pos] == '_'
+			*pos++
+			texSkipSpace(tex, pos)
+
+			script := texParseAtom(tex, pos)
+			if script == nil {
+				script = []byte("<mrow></mrow>")
+			}
+
+			if isSub {
+				sub = script
+			} else {
+				sup = script
+			}
+		}
+
+		if sub != nil && sup != nil {
+			atom = regex.JoinBytes([]byte("<msubsup>"), atom, sub, sup, []byte("</msubsup>"))
+		} else if sub != nil {
+			atom = regex.JoinBytes([]byte("<msub>"), atom, sub, []byte("</msub>"))
+		} else if sup != nil {
+			atom = regex.JoinBytes([]byte("<msup>"), atom, sup, []byte("</msup>"))
+		}
+
+		res = append(res, atom...)
+	}
+
+	return res
+}
+
+// texParseAtom parses a single element of tex
+//
+// returns nil if nothing should be added to the result
+func texParseAtom(tex []byte, pos *int) []byte {
+	if *pos >= len(tex) {
+		return nil
+	}
+
+	c := tex[*pos]
+
+	if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+		*pos++
+		return nil
+	} else if c == '{' {
+		*pos++
+		return regex.JoinBytes([]byte("<mrow>"), texParseRow(tex, pos, '}'), []byte("</mrow>"))
+	} else if c == '}' {
+		// unbalanced closing bracket
+		*pos++
+		return nil
+	} else if c >= '0' && c <= '9' || (c == '.' && *pos+1 < len(tex) && tex[*pos+1] >= '0' && tex[*pos+1] <= '9') {
+		start := *pos
+		for *pos < len(tex) && ((tex[*pos] >= '0' && tex[*pos] <= '9') || tex[*pos] == '.') {
+			*pos++
+		}
+		return regex.JoinBytes([]byte("<mn>"), tex[start:*pos], []byte("</mn>"))
+	} else if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		*pos++
+		return regex.JoinBytes([]byte("<mi>"), c, []byte("</mi>"))
+	} else if c == '\\' {
+		*pos++
+		if *pos >= len(tex) {
+			return nil
+		}
+
+		start := *pos
+		for *pos < len(tex) && ((tex[*pos] >= 'a' && tex[*pos] <= 'z') || (tex[*pos] >= 'A' && tex[*pos] <= 'Z')) {
+			*pos++
+		}
+		if *pos == start {
+			// single char command (ie: \, \{ \\)
+			*pos++
+		}
+		cmd := string(tex[start:*pos])
+
+		if sym, ok := texSymbols[cmd]; ok {
+			return regex.JoinBytes('<', sym[0], '>', hlEscape([]byte(sym[1])), []byte("</"), sym[0], '>')
+		} else if goutil.Contains(texFuncNames, cmd) {
+			return regex.JoinBytes([]byte("<mi>"), cmd, []byte("</mi><mo>&#x2061;</mo>"))
+		}
+
+		switch cmd {
+		case "frac", "dfrac", "tfrac":
+			texSkipSpace(tex, pos)
+			num := texParseAtom(tex, pos)
+			texSkipSpace(tex, pos)
+			den := texParseAtom(tex, pos)
+			return regex.JoinBytes([]byte("<mfrac>"), texOrEmpty(num), texOrEmpty(den), []byte("</mfrac>"))
+		case "sqrt":
+			texSkipSpace(tex, pos)
+			if *pos < len(tex) && tex[*pos] == '[' {
+				*pos++
+				index := texParseRow(tex, pos, ']')
+				texSkipSpace(tex, pos)
+				base := texParseAtom(tex, pos)
+				return regex.JoinBytes([]byte("<mroot>"), texOrEmpty(base), []byte("<mrow>"), index, []byte("</mrow></mroot>"))
+			}
+			return regex.JoinBytes([]byte("<msqrt>"), texOrEmpty(texParseAtom(tex, pos)), []byte("</msqrt>"))
+		case "text", "mathrm", "textrm", "operatorname":
+			texSkipSpace(tex, pos)
+			if *pos < len(tex) && tex[*pos] == '{' {
+				start := *pos + 1
+				level := 0
+				for *pos < len(tex) {
+					if tex[*pos] == '{' {
+						level++
+					} else if tex[*pos] == '}' {
+						level--
+						if level == 0 {
+							break
+						}
+					}
+					*pos++
+				}
+				text := tex[start:*pos]
+				*pos++
+
+				if cmd == "text" || cmd == "textrm" {
+					return regex.JoinBytes([]byte("<mtext>"), hlEscape(text), []byte("</mtext>"))
+				}
+				return regex.JoinBytes([]byte("<mi mathvariant=\"normal\">"), hlEscape(text), []byte("</mi>"))
+			}
+			return nil
+		case "mathbf", "mathit", "mathbb", "mathcal":
+			variant := map[string]string{"mathbf": "bold", "mathit": "italic", "mathbb": "double-struck", "mathcal": "script"}[cmd]
+			texSkipSpace(tex, pos)
+			return regex.JoinBytes([]byte("<mstyle mathvariant=\""), variant, []byte("\">"), texOrEmpty(texParseAtom(tex, pos)), []byte("</mstyle>"))
+		case "left", "right", "big", "Big", "bigg", "Bigg":
+			// sizing commands are handled by the browser
+			texSkipSpace(tex, pos)
+			if *pos < len(tex) && tex[*pos] == '.' {
+				*pos++
+			}
+			return nil
+		case ",", ";", ":", "!", " ", "quad", "qquad":
+			return regex.JoinBytes([]byte("<mspace width=\""), map[string]string{",": "0.17em", ";": "0.28em", ":": "0.22em", "!": "0", " ": "0.25em", "quad": "1em", "qquad": "2em"}[cmd], []byte("\"/>"))
+		case "\\":
+			return []byte("<mspace linebreak=\"newline\"/>")
+		}
+
+		// unknown commands are kept as text
+		return regex.JoinBytes([]byte("<mtext>\\"), hlEscape([]byte(cmd)), []byte("</mtext>"))
+	}
+
+	*pos++
+	return regex.JoinBytes([]byte("<mo>"), hlEscape([]byte{c}), []byte("</mo>"))
+}
+
+func texSkipSpace(tex []byte, pos *int) {
+	for *pos < len(tex) && (tex[*pos] == ' ' || tex[*pos] == '\t' || tex[*pos] == '\r' || tex[*pos] == '\n') {
+		*pos++
+	}
+}
+
+func texOrEmpty(b []byte) []byte {
+	if b == nil {
+		return []byte("<mrow></mrow>")
+	}
+	return b
+}
@@ -0,0 +1,190 @@
+package compiler
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// MediaProfile sets the ffmpeg encoder settings for an output type
+//
+// output types: "webp", "avif", "webm", "weba", "poster"
+type MediaProfile struct {
+	// The ffmpeg codec to encode with
+	// example: "libvpx-vp9"
+	Codec string
+
+	// Codec specific quality level (0 = codec default)
+	//
+	// this is used as `-crf` for .webm and .avif files, `-q:v` for .webp and poster files, and `-q:a` for .weba files
+	Quality int
+
+	// The target bitrate
+	// example: "1M", "128k"
+	Bitrate string
+
+	// Max width and height to scale down to (0 = no limit)
+	//
+	// the aspect ratio is always kept
+	MaxWidth  int
+	MaxHeight int
+}
+
+// default file types for image, video, and audio files
+var defaultImageExts []string = []string{"png", "jpg", "jpeg", "gif", "bmp", "tiff", "tif"}
+var defaultVideoExts []string = []string{"mp4", "mov", "mkv", "avi", "wmv", "flv", "m4v", "mpg", "mpeg"}
+var defaultAudioExts []string = []string{"mp3", "wav", "ogg", "flac", "m4a", "aac", "wma", "aiff"}
+
+// mediaExtRE returns a regex selector for a list of file extensions
+func mediaExtRE(exts []string) *regex.Regexp {
+	list := []string{}
+	for _, ext := range exts {
+		ext = strings.TrimPrefix(ext, ".")
+		if ext != "" {
+			list = append(list, regex.Escape(ext))
+		}
+	}
+	return regex.Comp(`(?i)\.(` + strings.Join(list, "|") + `)$`)
+}
+
+// posterPath returns the path of the poster frame for a video
+//
+// example: clip.mp4 -> clip.poster.webp
+func posterPath(path string) string {
+	return string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte(".poster.webp")))
+}
+
+// convertMedia runs ffmpeg with the MediaProfile of an output type
+//
+// @width: scale the output to this width (0 = original size)
+func convertMedia(path string, resPath string, outType string, width int, inputArgs ...ffmpeg.KwArgs) error {
+	args := ffmpeg.KwArgs{}
+	scale := []string{}
+
+	if width != 0 {
+		scale = append(scale, "scale="+strconv.Itoa(width)+":-2")
+	}
+
+	if profile, ok := compilerConfig.MediaProfiles[outType]; ok {
+		isAudio := outType == "weba"
+
+		if profile.Codec != "" {
+			if isAudio {
+				args["c:a"] = profile.Codec
+			} else {
+				args["c:v"] = profile.Codec
+			}
+		}
+
+		if profile.Quality != 0 {
+			if isAudio {
+				args["q:a"] = strconv.Itoa(profile.Quality)
+			} else if outType == "webm" || outType == "avif" {
+				args["crf"] = strconv.Itoa(profile.Quality)
+			} else {
+				args["q:v"] = strconv.Itoa(profile.Quality)
+			}
+		}
+
+		if profile.Bitrate != "" {
+			if isAudio {
+				args["b:a"] = profile.Bitrate
+			} else {
+				args["b:v"] = profile.Bitrate
+			}
+		}
+
+		if !isAudio && profile.MaxWidth > 0 && profile.MaxHeight > 0 {
+			scale = append(scale, "scale='min(iw,"+strconv.Itoa(profile.MaxWidth)+")':'min(ih,"+strconv.Itoa(profile.MaxHeight)+")':force_original_aspect_ratio=decrease")
+		} else if !isAudio && profile.MaxWidth > 0 {
+			scale = append(scale, "scale='min(iw,"+strconv.Itoa(profile.MaxWidth)+")':-2")
+		} else if !isAudio && profile.MaxHeight > 0 {
+			scale = append(scale, "scale=-2:'min(ih,"+strconv.Itoa(profile.MaxHeight)+")'")
+		}
+	}
+
+	if outType == "poster" {
+		args["frames:v"] = "1"
+	}
+
+	if len(scale) != 0 {
+		args["vf"] = strings.Join(scale, ",")
+	}
+
+	return ffmpeg.Input(path, inputArgs...).Output(resPath, args).OverWriteOutput().Run()
+}
+
+// tryMinifyVideo converts a video to .webm, and creates a .webp poster frame
+//
+// files that are newer than the source video are not converted again
+func tryMinifyVideo(path string) {
+	resPath := string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte(".webm")))
+	if mediaFresh(path, resPath) {
+		hashStaticFile(path, resPath)
+	} else if err := convertMedia(path, resPath, "webm", 0); err != nil {
+		os.Remove(resPath)
+	} else {
+		hashStaticFile(path, resPath)
+	}
+
+	// use a frame 1 second in, to skip any fade in from black
+	resPath = posterPath(path)
+	if !mediaFresh(path, resPath) {
+		if err := convertMedia(path, resPath, "poster", 0, ffmpeg.KwArgs{"ss": "1"}); err != nil {
+			if err := convertMedia(path, resPath, "poster", 0); err != nil {
+				os.Remove(resPath)
+				return
+			}
+		}
+	}
+	hashStaticFile(resPath, resPath)
+}
+
+// tryMinifyAudio converts an audio file to .weba
+func tryMinifyAudio(path string) {
+	resPath := string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte(".weba")))
+	if mediaFresh(path, resPath) {
+		hashStaticFile(path, resPath)
+	} else if err := convertMedia(path, resPath, "weba", 0); err != nil {
+		os.Remove(resPath)
+	} else {
+		hashStaticFile(path, resPath)
+	}
+}
+
+// removeVideoVariants removes the .webm and poster files created for a video
+func removeVideoVariants(path string) {
+	removeHashedAsset(path)
+	os.Remove(string(regex.Comp(`\.([\w_-]+)$`).RepStr([]byte(path), []byte(".webm"))))
+
+	removeHashedAsset(posterPath(path))
+	os.Remove(posterPath(path))
+}
+
+// videoPoster adds a `poster` arg to a local `<video>` tag
+func videoPoster(args *htmlArgs) {
+	if _, ok := args.args["poster"]; ok {
+		return
+	}
+
+	src, ok := args.args["src"]
+	if !ok || len(src) == 0 || src[0] != '/' || !videoRE.Match(src) {
+		return
+	}
+
+	url := posterPath(string(src))
+	if hashUrl, ok := staticManifest.Get(url); ok {
+		url = hashUrl
+	}
+
+	if path, err := goutil.FS.JoinPath(compilerConfig.Static, url); err == nil {
+		if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
+			args.args["poster"] = []byte(url)
+			args.ind = append(args.ind, "poster")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMediaExts(t *testing.T) {
+	videoRE := mediaExtRE([]string{"mp4", ".mkv", ""})
+
+	for path, want := range map[string]bool{
+		"/clip.mp4":      true,
+		"/clip.MKV":      true,
+		"/clip.mov":      false,
+		"/clip.mp4.webm": false,
+		"/clipmp4":       false,
+	} {
+		if videoRE.Match([]byte(path)) != want {
+			t.Errorf("%s: expected match to be %v", path, want)
+		}
+	}
+
+	if res := posterPath("/media/clip.mp4"); res != "/media/clip.poster.webp" {
+		t.Errorf("unexpected poster path: %s", res)
+	}
+}
+
+func TestVideoPoster(t *testing.T) {
+	testConfig(t)
+
+	args := htmlArgs{args: map[string][]byte{"src": []byte("/clip.mp4")}, ind: []string{"src"}}
+	videoPoster(&args)
+	if _, ok := args.args["poster"]; ok {
+		t.Errorf("expected no poster for a video without a poster file: %q", args.args["poster"])
+	}
+
+	if err := os.WriteFile(filepath.Join(compilerConfig.Static, "clip.poster.webp"), []byte("data"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	videoPoster(&args)
+	if string(args.args["poster"]) != "/clip.poster.webp" || args.ind[len(args.ind)-1] != "poster" {
+		t.Errorf("expected a poster arg: %q", args.args["poster"])
+	}
+
+	// an existing poster arg is kept
+	args.args["poster"] = []byte("/custom.jpg")
+	videoPoster(&args)
+	if string(args.args["poster"]) != "/custom.jpg" {
+		t.Errorf("expected the poster arg to be kept: %q", args.args["poster"])
+	}
+}
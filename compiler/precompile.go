@@ -0,0 +1,198 @@
+package compiler
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// PrecompileResult is the result of precompiling a view with PrecompileAll
+type PrecompileResult struct {
+	// The view path passed to PreCompile (ie: "blog/post")
+	Path string
+
+	// Weather or not the view was compiled to static html
+	Static bool
+
+	// The error returned by PreCompile (if Err != nil, Static should be ignored)
+	Err error
+}
+
+// precompileCall is a PreCompile that is still running, for precompileOnce
+type precompileCall struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+var precompileCalls map[string]*precompileCall = map[string]*precompileCall{}
+var precompileCallsMU sync.Mutex
+
+// precompileOnce runs PreCompile for a view, unless another request is already precompiling it
+//
+// concurrent requests for the same view wait for the first one to finish, and share its result
+//
+// @bool: true if the view was precompiled by another request
+func precompileOnce(path string, opts map[string]interface{}) (bool, error) {
+	precompileCallsMU.Lock()
+	if call, ok := precompileCalls[path]; ok {
+		precompileCallsMU.Unlock()
+		call.wg.Wait()
+		return true, call.err
+	}
+
+	call := &precompileCall{}
+	call.wg.Add(1)
+	precompileCalls[path] = call
+	precompileCallsMU.Unlock()
+
+	defer func() {
+		precompileCallsMU.Lock()
+		delete(precompileCalls, path)
+		precompileCallsMU.Unlock()
+		call.wg.Done()
+	}()
+
+	call.err = PreCompile(path, opts)
+	return false, call.err
+}
+
+// PrecompileAll runs PreCompile for every view in the Root dir, to warm up the cache (ie: after a deploy)
+//
+// components (names starting with a capital letter) and layouts are skipped.
+// with a DomainFolder, these are checked relative to each domain root.
+//
+// views are precompiled concurrently by PrecompileWorkers at a time.
+// if the context is canceled, the remaining views are skipped and the context error is returned.
+//
+// @opts: the options to pass to PreCompile for each view
+func PrecompileAll(ctx context.Context, opts map[string]interface{}) ([]PrecompileResult, error) {
+	views, err := listViews()
+	if err != nil {
+		LogErr(err)
+		return nil, err
+	}
+
+	workers := compilerConfig.PrecompileWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]PrecompileResult, len(views))
+	done := make([]bool, len(views))
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ind := range queue {
+				// PreCompile modifies the options, so each view needs its own copy
+				viewOpts, err := goutil.JSON.DeepCopy(opts)
+				if err != nil || viewOpts == nil {
+					viewOpts = map[string]interface{}{}
+				}
+
+				// views are compiled with precompileOnce, so they do not race with requests for the same view
+				res := PrecompileResult{Path: views[ind]}
+				if _, res.Err = precompileOnce(views[ind], viewOpts); res.Err == nil {
+					// markdown views are cached by PreCompile under the same path as a view with the Ext
+					res.Static, _ = HasStaticCompile(views[ind])
+				}
+
+				results[ind] = res
+				done[ind] = true
+			}
+		}()
+	}
+
+	var ctxErr error
+	for ind := range views {
+		select {
+		case queue <- ind:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		}
+
+		if ctxErr != nil {
+			break
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+
+	// remove the views that were skipped
+	res := []PrecompileResult{}
+	for ind, result := range results {
+		if done[ind] {
+			res = append(res, result)
+		}
+	}
+
+	return res, ctxErr
+}
+
+// listViews returns the views in the Root dir that can be compiled as a page (without the extention)
+func listViews() ([]string, error) {
+	views := []string{}
+	found := map[string]bool{}
+
+	err := filepath.WalkDir(compilerConfig.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		var name string
+		if strings.HasSuffix(path, "."+compilerConfig.Ext) {
+			name = strings.TrimSuffix(path, "."+compilerConfig.Ext)
+		} else if compilerConfig.IncludeMD && strings.HasSuffix(path, ".md") {
+			name = strings.TrimSuffix(path, ".md")
+		} else {
+			return nil
+		}
+
+		name, err = filepath.Rel(compilerConfig.Root, name)
+		if err != nil {
+			return nil
+		}
+		name = filepath.ToSlash(name)
+
+		if found[name] || !isPageView(name) {
+			return nil
+		}
+		found[name] = true
+
+		views = append(views, name)
+		return nil
+	})
+
+	return views, err
+}
+
+// isPageView returns false if a view is a component or layout
+func isPageView(name string) bool {
+	parts := strings.Split(name, "/")
+
+	// components and layouts are relative to the domain root
+	if compilerConfig.DomainFolder != 0 && len(parts) > int(compilerConfig.DomainFolder) {
+		parts = parts[compilerConfig.DomainFolder:]
+	}
+
+	if len(parts) == 1 && parts[0] == "layout" {
+		return false
+	}
+
+	for _, part := range parts {
+		if part != "" && part[0] >= 'A' && part[0] <= 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,100 @@
+package compiler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestListViews(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.IncludeMD = true
+
+	files := []string{
+		"index.html", "layout.html", "Card.html", "about.md", "about.html", "readme.txt",
+		"blog/post.html", "blog/layout.html", "blog/Widgets/list.html",
+		"example.com/index.html", "example.com/layout.html", "example.com/Nav.html", "example.com/docs/intro.md",
+	}
+	for _, file := range files {
+		path := filepath.Join(compilerConfig.Root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("<p>"+file+"</p>"), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		domainFolder uint
+		want         []string
+	}{
+		{0, []string{"about", "blog/layout", "blog/post", "example.com/docs/intro", "example.com/index", "example.com/layout", "index"}},
+		{1, []string{"about", "blog/post", "example.com/docs/intro", "example.com/index", "index"}},
+	}
+
+	for _, test := range tests {
+		compilerConfig.DomainFolder = test.domainFolder
+
+		views, err := listViews()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(views)
+
+		if strings.Join(views, ",") != strings.Join(test.want, ",") {
+			t.Errorf("DomainFolder %d:\n got: %v\nwant: %v", test.domainFolder, views, test.want)
+		}
+	}
+}
+
+func TestIsPageView(t *testing.T) {
+	testConfig(t)
+
+	tests := []struct {
+		name         string
+		domainFolder uint
+		want         bool
+	}{
+		{"index", 0, true},
+		{"layout", 0, false},
+		{"Card", 0, false},
+		{"blog/layout", 0, true},
+		{"blog/Widgets/list", 0, false},
+		{"example.com/index", 1, true},
+		{"example.com/layout", 1, false},
+		{"example.com/Nav", 1, false},
+		{"Example.com/index", 1, true},
+		{"layout", 1, false},
+	}
+
+	for _, test := range tests {
+		compilerConfig.DomainFolder = test.domainFolder
+		if res := isPageView(test.name); res != test.want {
+			t.Errorf("%s (DomainFolder %d): expected %v, got %v", test.name, test.domainFolder, test.want, res)
+		}
+	}
+}
+
+func TestPrecompileAllMarkdown(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.IncludeMD = true
+
+	if err := os.WriteFile(filepath.Join(compilerConfig.Root, "readme.md"), []byte("# Hello"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer htmlPreCache.Del(filepath.Join(compilerConfig.Root, "readme.html"))
+
+	res, err := PrecompileAll(context.Background(), map[string]interface{}{"@layout": "null"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Path != "readme" || res[0].Err != nil || !res[0].Static {
+		t.Errorf("expected the markdown view to be static: %v", res)
+	}
+}
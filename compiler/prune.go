@@ -0,0 +1,471 @@
+package compiler
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// cssSelectors is a list of the class names, ids, and tag names used by the views
+type cssSelectors struct {
+	classes map[string]bool
+	ids     map[string]bool
+	tags    map[string]bool
+
+	// the static part of dynamic class names and ids (ie: class="btn-{{type}}" -> "btn-")
+	prefixes []string
+
+	// true if a class name or id is only a var (ie: class="{{type}}"), so every class name or id has to be kept
+	anyClass bool
+	anyID    bool
+}
+
+var usedSelectors *cssSelectors
+var usedSelectorsMU sync.Mutex
+
+// selectorFiles stores the selectors of each view and static js file
+//
+// this allows a file change to update the used selectors without reading every file again
+var selectorFiles map[string]*cssSelectors = map[string]*cssSelectors{}
+
+// class names created by the compiler (markdown, code highlighting, and math)
+var pruneCSSSafelist []string = []string{"hl", "hl-*", "task-list-item", "mermaid", "math", "math-*", "footnote*", "footnotes"}
+
+// html tags that can be created by markdown
+var pruneCSSTags []string = []string{"html", "head", "body", "p", "h1", "h2", "h3", "h4", "h5", "h6", "a", "em", "strong", "del", "code", "pre", "blockquote", "hr", "br", "img", "ul", "ol", "li", "input", "table", "thead", "tbody", "tr", "th", "td", "sup", "section", "span", "div", "math", "mi", "mn", "mo", "ms", "mtext", "mrow", "mfrac", "msqrt", "mroot", "msub", "msup", "msubsup", "munder", "mover", "munderover", "mtable", "mtr", "mtd", "semantics", "annotation"}
+
+// at rules that contain other css rules
+var pruneCSSGroupRules []string = []string{"media", "supports", "layer", "container", "document", "scope"}
+
+func newCSSSelectors() *cssSelectors {
+	return &cssSelectors{
+		classes: map[string]bool{},
+		ids:     map[string]bool{},
+		tags:    map[string]bool{},
+	}
+}
+
+// collectSelectors finds the class names, ids, and tag names used by the views in the Root dir
+//
+// strings in the static js files are also included, for classes that are added by scripts
+func collectSelectors() *cssSelectors {
+	files := map[string]*cssSelectors{}
+
+	filepath.WalkDir(compilerConfig.Root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if used := fileSelectors(path); used != nil {
+				files[path] = used
+			}
+		}
+		return nil
+	})
+
+	filepath.WalkDir(compilerConfig.Static, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if used := fileSelectors(path); used != nil {
+				files[path] = used
+			}
+		}
+		return nil
+	})
+
+	selectorFiles = files
+	return mergeSelectors(files)
+}
+
+// fileSelectors returns the selectors used by a view or a static js file
+//
+// @return: nil if the file is not a view or js file, or could not be read
+func fileSelectors(path string) *cssSelectors {
+	isView := strings.HasPrefix(path, compilerConfig.Root+string(filepath.Separator)) && (strings.HasSuffix(path, "."+compilerConfig.Ext) || strings.HasSuffix(path, ".md"))
+	isJS := strings.HasPrefix(path, compilerConfig.Static+string(filepath.Separator)) && regex.Comp(`\.([jt]sx?|mts|mjs)$`).Match([]byte(path)) && minifyRE.Match([]byte(path))
+	if !isView && !isJS {
+		return nil
+	}
+
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	used := newCSSSelectors()
+	if isView {
+		used.addHTML(code)
+	} else {
+		used.addJS(code)
+	}
+	return used
+}
+
+// mergeSelectors combines the selectors of each file, along with the tags that can be created by markdown
+func mergeSelectors(files map[string]*cssSelectors) *cssSelectors {
+	used := newCSSSelectors()
+
+	for _, tag := range pruneCSSTags {
+		used.tags[tag] = true
+	}
+
+	for _, file := range files {
+		for name := range file.classes {
+			used.classes[name] = true
+		}
+		for name := range file.ids {
+			used.ids[name] = true
+		}
+		for name := range file.tags {
+			used.tags[name] = true
+		}
+		for _, prefix := range file.prefixes {
+			if !goutil.Contains(used.prefixes, prefix) {
+				used.prefixes = append(used.prefixes, prefix)
+			}
+		}
+		used.anyClass = used.anyClass || file.anyClass
+		used.anyID = used.anyID || file.anyID
+	}
+
+	// keep the order consistent, so the result can be compared with the last one
+	sort.Strings(used.prefixes)
+
+	return used
+}
+
+// addHTML adds the class names, ids, and tag names used by an html template
+func (used *cssSelectors) addHTML(html []byte) {
+	addWords := func(val []byte, list map[string]bool, any *bool) {
+		for _, word := range bytes.Fields(val) {
+			if i := bytes.Index(word, []byte("{{")); i != -1 {
+				if i == 0 {
+					// the name is only known when the page is compiled
+					*any = true
+				} else if !goutil.Contains(used.prefixes, string(word[:i])) {
+					used.prefixes = append(used.prefixes, string(word[:i]))
+				}
+				continue
+			}
+			list[string(word)] = true
+		}
+	}
+
+	regex.Comp(`<([A-Za-z][\w_\-\.]*)`).RepFunc(html, func(data func(int) []byte) []byte {
+		used.tags[strings.ToLower(string(data(1)))] = true
+		return nil
+	}, true)
+
+	regex.Comp(`(?i)\s(class|id)\s*=\s*(["'\'])((?:\\[\\"'\']|.)*?)\2`).RepFunc(html, func(data func(int) []byte) []byte {
+		if bytes.EqualFold(data(1), []byte("id")) {
+			addWords(data(3), used.ids, &used.anyID)
+		} else {
+			addWords(data(3), used.classes, &used.anyClass)
+		}
+		return nil
+	}, true)
+}
+
+// addJS adds the words in the strings of a js file, for classes that are added by scripts
+func (used *cssSelectors) addJS(code []byte) {
+	regex.Comp("([\"'`])((?:\\\\[\\\\\"'`]|.)*?)\\1").RepFunc(code, func(data func(int) []byte) []byte {
+		regex.Comp(`[\w_\-]+`).RepFunc(data(2), func(word func(int) []byte) []byte {
+			used.classes[string(word(0))] = true
+			used.ids[string(word(0))] = true
+			used.tags[strings.ToLower(string(word(0)))] = true
+			return nil
+		}, true)
+		return nil
+	}, true)
+}
+
+// getUsedSelectors returns the selectors used by the views, and collects them on the first call
+func getUsedSelectors() *cssSelectors {
+	usedSelectorsMU.Lock()
+	defer usedSelectorsMU.Unlock()
+
+	if usedSelectors == nil {
+		usedSelectors = collectSelectors()
+	}
+	return usedSelectors
+}
+
+// updateUsedSelectors reads the selectors of a view or static js file that changed (or was removed),
+// and queues the static css files to be minified if the used selectors changed
+func updateUsedSelectors(path string) {
+	usedSelectorsMU.Lock()
+
+	// the selectors have not been collected yet, so the file will be read when they are
+	if usedSelectors == nil {
+		usedSelectorsMU.Unlock()
+		return
+	}
+
+	if used := fileSelectors(path); used != nil {
+		selectorFiles[path] = used
+	} else {
+		delete(selectorFiles, path)
+	}
+
+	used := mergeSelectors(selectorFiles)
+	changed := !reflect.DeepEqual(usedSelectors, used)
+	usedSelectors = used
+	usedSelectorsMU.Unlock()
+
+	if !changed {
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	filepath.WalkDir(compilerConfig.Static, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && minifyRE.Match([]byte(path)) && regex.Comp(`\.(css|less|s[ac]ss)$`).Match([]byte(path)) {
+			staticChangeQueue.Set(path, now)
+		}
+		return nil
+	})
+}
+
+// pruneCSSFile removes the css rules that are not used by any views from a minified css file
+func pruneCSSFile(path string) {
+	css, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	writeFileAtomic(path, pruneCSS(css, getUsedSelectors(), pruneCSSSafelistRE()), 0775)
+}
+
+// pruneCSSSafelistRE returns the compiler safelist and CSSSafelist as regex selectors
+func pruneCSSSafelistRE() []*regex.Regexp {
+	safelist := []*regex.Regexp{}
+	for _, name := range append(append([]string{}, pruneCSSSafelist...), compilerConfig.CSSSafelist...) {
+		safelist = append(safelist, regex.Comp(`^`+strings.ReplaceAll(regex.Escape(name), `\*`, `.*`)+`$`))
+	}
+	return safelist
+}
+
+// pruneCSS removes the rules with selectors that are not used
+func pruneCSS(css []byte, used *cssSelectors, safelist []*regex.Regexp) []byte {
+	res := []byte{}
+
+	// skip moves forward to the next `{`, `;`, or `}` outside of strings and parentheses
+	skip := func(i int) int {
+		level := 0
+		for ; i < len(css); i++ {
+			c := css[i]
+			if c == '"' || c == '\'' {
+				for i++; i < len(css) && css[i] != c; i++ {
+					if css[i] == '\\' {
+						i++
+					}
+				}
+			} else if c == '(' || c == '[' {
+				level++
+			} else if c == ')' || c == ']' {
+				level--
+			} else if level <= 0 && (c == '{' || c == ';' || c == '}') {
+				return i
+			}
+		}
+		return len(css)
+	}
+
+	// matchClose returns the index of the `}` that closes a block
+	matchClose := func(i int) int {
+		level := 0
+		for ; i < len(css); i++ {
+			c := css[i]
+			if c == '"' || c == '\'' {
+				for i++; i < len(css) && css[i] != c; i++ {
+					if css[i] == '\\' {
+						i++
+					}
+				}
+			} else if c == '{' {
+				level++
+			} else if c == '}' {
+				level--
+				if level == 0 {
+					return i
+				}
+			}
+		}
+		return len(css) - 1
+	}
+
+	for i := 0; i < len(css); {
+		if css[i] == ' ' || css[i] == '\t' || css[i] == '\r' || css[i] == '\n' {
+			res = append(res, css[i])
+			i++
+			continue
+		}
+
+		if bytes.HasPrefix(css[i:], []byte("/*")) {
+			if end := bytes.Index(css[i+2:], []byte("*/")); end != -1 {
+				res = append(res, css[i:i+end+4]...)
+				i += end + 4
+			} else {
+				res = append(res, css[i:]...)
+				i = len(css)
+			}
+			continue
+		}
+
+		end := skip(i)
+		if end >= len(css) || css[end] != '{' {
+			// statements (ie: @import) and stray characters are kept
+			if end < len(css) {
+				end++
+			}
+			res = append(res, css[i:end]...)
+			i = end
+			continue
+		}
+
+		prelude := css[i:end]
+		blockEnd := matchClose(end)
+		block := css[end : blockEnd+1]
+		i = blockEnd + 1
+
+		if prelude[0] == '@' {
+			name := ""
+			regex.Comp(`^@(?:-[a-z]+-)?([\w_\-]+)`).RepFunc(prelude, func(data func(int) []byte) []byte {
+				name = strings.ToLower(string(data(1)))
+				return nil
+			}, true)
+
+			if !goutil.Contains(pruneCSSGroupRules, name) {
+				res = append(res, prelude...)
+				res = append(res, block...)
+				continue
+			}
+
+			inner := pruneCSS(block[1:len(block)-1], used, safelist)
+			if len(bytes.TrimSpace(inner)) != 0 {
+				res = append(res, prelude...)
+				res = append(res, '{')
+				res = append(res, inner...)
+				res = append(res, '}')
+			}
+			continue
+		}
+
+		selectors := [][]byte{}
+		for _, sel := range splitSelectors(prelude) {
+			if selectorUsed(sel, used, safelist) {
+				selectors = append(selectors, sel)
+			}
+		}
+
+		if len(selectors) != 0 {
+			res = append(res, bytes.Join(selectors, []byte{','})...)
+			res = append(res, block...)
+		}
+	}
+
+	return res
+}
+
+// splitSelectors splits a selector list by commas, outside of parentheses and strings
+func splitSelectors(prelude []byte) [][]byte {
+	list := [][]byte{}
+	level := 0
+	start := 0
+	for i := 0; i < len(prelude); i++ {
+		c := prelude[i]
+		if c == '"' || c == '\'' {
+			for i++; i < len(prelude) && prelude[i] != c; i++ {
+				if prelude[i] == '\\' {
+					i++
+				}
+			}
+		} else if c == '(' || c == '[' {
+			level++
+		} else if c == ')' || c == ']' {
+			level--
+		} else if c == ',' && level == 0 {
+			list = append(list, prelude[start:i])
+			start = i + 1
+		}
+	}
+	return append(list, prelude[start:])
+}
+
+// selectorUsed returns false if a selector has a class, id, or tag name that is not used by any view
+//
+// selectors inside pseudo classes (ie: `:not(.a)`) and attribute selectors are ignored
+func selectorUsed(sel []byte, used *cssSelectors, safelist []*regex.Regexp) bool {
+	// remove strings, attribute selectors, and pseudo classes with arguments
+	level := 0
+	simple := []byte{}
+	for i := 0; i < len(sel); i++ {
+		c := sel[i]
+		if c == '\\' && i+1 < len(sel) {
+			if level == 0 {
+				simple = append(simple, c, sel[i+1])
+			}
+			i++
+		} else if c == '"' || c == '\'' {
+			for i++; i < len(sel) && sel[i] != c; i++ {
+				if sel[i] == '\\' {
+					i++
+				}
+			}
+		} else if c == '(' || c == '[' {
+			level++
+		} else if c == ')' || c == ']' {
+			level--
+			if level == 0 {
+				simple = append(simple, ' ')
+			}
+		} else if level == 0 {
+			simple = append(simple, c)
+		}
+	}
+	simple = regex.Comp(`::?[\w_\-]+`).RepStr(simple, []byte{' '})
+
+	isSafe := func(name string) bool {
+		for _, re := range safelist {
+			if re.Match([]byte(name)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	hasPrefix := func(name string) bool {
+		for _, prefix := range used.prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	isUsed := true
+	regex.Comp(`([\.#]?)((?:\\.|[\w_\-])+)`).RepFunc(simple, func(data func(int) []byte) []byte {
+		if !isUsed {
+			return nil
+		}
+
+		name := string(regex.Comp(`\\(.)`).RepStrComp(data(2), []byte("$1")))
+		switch string(data(1)) {
+		case ".":
+			isUsed = used.anyClass || used.classes[name] || hasPrefix(name) || isSafe(name)
+		case "#":
+			isUsed = used.anyID || used.ids[name] || hasPrefix(name) || isSafe(name)
+		default:
+			// custom elements and numbers (ie: keyframe percentages) are always kept
+			name = strings.ToLower(name)
+			isUsed = used.tags[name] || strings.Contains(name, "-") || (name[0] >= '0' && name[0] <= '9') || isSafe(name)
+		}
+		return nil
+	}, true)
+
+	return isUsed
+}
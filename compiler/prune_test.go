@@ -0,0 +1,111 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPruneCSS(t *testing.T) {
+	css := []byte(".btn{a:1}.btn-primary{a:2}.card{a:3}#main{a:4}#other{a:5}p{a:6}@media (min-width:1px){.card{a:7}.btn{a:8}}")
+
+	used := newCSSSelectors()
+	used.addHTML([]byte(`<div id="main" class="btn btn-{{type}}"><p>text</p></div>`))
+
+	res := string(pruneCSS(css, used, pruneCSSSafelistRE()))
+	for _, want := range []string{".btn{a:1}", ".btn-primary{a:2}", "#main{a:4}", "p{a:6}", ".btn{a:8}"} {
+		if !strings.Contains(res, want) {
+			t.Errorf("expected %s to be kept: %s", want, res)
+		}
+	}
+	for _, unused := range []string{".card", "#other"} {
+		if strings.Contains(res, unused) {
+			t.Errorf("expected %s to be removed: %s", unused, res)
+		}
+	}
+
+	// a class name that is only a var could be anything, so every class is kept
+	used = newCSSSelectors()
+	used.addHTML([]byte(`<div class="{{type}}"></div>`))
+	if !used.anyClass || used.anyID {
+		t.Fatalf("expected only dynamic class names: %v %v", used.anyClass, used.anyID)
+	}
+
+	res = string(pruneCSS(css, used, pruneCSSSafelistRE()))
+	for _, want := range []string{".btn{a:1}", ".btn-primary{a:2}", ".card{a:3}"} {
+		if !strings.Contains(res, want) {
+			t.Errorf("expected %s to be kept: %s", want, res)
+		}
+	}
+	if strings.Contains(res, "#main") {
+		t.Errorf("expected unused ids to be removed: %s", res)
+	}
+}
+
+func TestUpdateUsedSelectors(t *testing.T) {
+	testConfig(t)
+
+	origSelectors, origFiles := usedSelectors, selectorFiles
+	defer func() {
+		usedSelectors, selectorFiles = origSelectors, origFiles
+	}()
+
+	usedSelectors = nil
+
+	indexPath := filepath.Join(compilerConfig.Root, "index.html")
+	if err := os.WriteFile(indexPath, []byte(`<div class="a"></div>`), 0775); err != nil {
+		t.Fatal(err)
+	}
+	cssPath := filepath.Join(compilerConfig.Static, "style.css")
+	if err := os.WriteFile(cssPath, []byte(".a{}.b{}"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer staticChangeQueue.Del(cssPath)
+
+	if used := getUsedSelectors(); !used.classes["a"] || used.classes["b"] {
+		t.Fatalf("unexpected classes: %v", used.classes)
+	}
+
+	// a new view adds its selectors, and queues the css files to be pruned again
+	pagePath := filepath.Join(compilerConfig.Root, "page.html")
+	if err := os.WriteFile(pagePath, []byte(`<div class="b"></div>`), 0775); err != nil {
+		t.Fatal(err)
+	}
+	updateUsedSelectors(pagePath)
+	if used := getUsedSelectors(); !used.classes["a"] || !used.classes["b"] {
+		t.Errorf("expected the new view to be included: %v", used.classes)
+	}
+	if _, ok := staticChangeQueue.Get(cssPath); !ok {
+		t.Error("expected the css file to be queued")
+	}
+
+	// a js file adds the words in its strings
+	jsPath := filepath.Join(compilerConfig.Static, "app.js")
+	if err := os.WriteFile(jsPath, []byte(`el.classList.add("c")`), 0775); err != nil {
+		t.Fatal(err)
+	}
+	updateUsedSelectors(jsPath)
+	if used := getUsedSelectors(); !used.classes["c"] {
+		t.Errorf("expected the js file to be included: %v", used.classes)
+	}
+
+	// the same selectors do not queue the css files again
+	staticChangeQueue.Del(cssPath)
+	updateUsedSelectors(indexPath)
+	if _, ok := staticChangeQueue.Get(cssPath); ok {
+		t.Error("expected the css file not to be queued when nothing changed")
+	}
+
+	// a removed view removes its selectors
+	if err := os.Remove(pagePath); err != nil {
+		t.Fatal(err)
+	}
+	updateUsedSelectors(pagePath)
+	if used := getUsedSelectors(); !used.classes["a"] || used.classes["b"] {
+		t.Errorf("expected the removed view to be excluded: %v", used.classes)
+	}
+	if _, ok := staticChangeQueue.Get(cssPath); !ok {
+		t.Error("expected the css file to be queued")
+	}
+}
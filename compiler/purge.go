@@ -0,0 +1,137 @@
+package compiler
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheEntry describes a page in the cache, returned by the CacheEntries method
+type CacheEntry struct {
+	// The view path of the page (ie: "blog/post")
+	Path string
+
+	// The keys of the cache files in the CacheStore
+	CachePath []string
+
+	// Weather or not the page is static html
+	Static bool
+
+	// The total size (in bytes) of the cache files (0 if unknown)
+	Size int64
+
+	// The last time the page was compiled or served from the cache (to the nearest minute)
+	Accessed time.Time
+
+	// The tags of the page, for InvalidateTag
+	Tags []string
+}
+
+// CacheEntries returns a list of the pages in the cache, sorted by path
+func CacheEntries() []CacheEntry {
+	entries := []CacheEntry{}
+
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		entries = append(entries, CacheEntry{
+			Path:      viewName(path),
+			CachePath: append([]string{}, data.cachePath...),
+			Static:    data.static,
+			Size:      data.size,
+			Accessed:  time.UnixMilli(int64(data.accessed) * 60000),
+			Tags:      append([]string{}, data.tags...),
+		})
+		return true
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries
+}
+
+// Purge removes a page from the cache, along with its files in the CacheStore
+//
+// pages that use the view as a component or layout are also removed.
+// this can be used to clear a page after content changes that did not modify a template file
+//
+// @path: the view path (ie: "blog/post")
+func Purge(path string) error {
+	path = strings.Trim(path, "/")
+
+	return purgeViews(func(page string) bool {
+		return page == path
+	})
+}
+
+// PurgePrefix removes every page in a directory from the cache, along with their files in the CacheStore
+//
+// pages that use a component or layout from the directory are also removed
+//
+// @dir: the view directory (ie: "blog")
+func PurgePrefix(dir string) error {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return PurgeAll()
+	}
+
+	return purgeViews(func(page string) bool {
+		return page == dir || strings.HasPrefix(page, dir+"/")
+	})
+}
+
+// PurgeAll removes every page from the cache, along with all of the files in the CacheStore
+func PurgeAll() error {
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		purgePreCache(path, data)
+		return true
+	})
+
+	htmlFragmentCache.ForEach(func(key string, frag fragmentObj) bool {
+		htmlFragmentCache.Del(key)
+		return true
+	})
+
+	list, err := compilerConfig.CacheStore.List()
+	if err != nil {
+		return err
+	}
+
+	for key := range list {
+		compilerConfig.CacheStore.Delete(key)
+	}
+
+	return nil
+}
+
+// purgeViews removes the pages with a view name that matches a filter (or depend on a matching view) from the cache
+//
+// pages in the CacheStore that are not in the htmlPreCache (ie: precompiled by another instance) are also removed
+func purgeViews(match func(page string) bool) error {
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		purge := match(viewName(path))
+		for _, dep := range data.deps {
+			if !purge && match(strings.TrimSuffix(viewName(dep), ".md")) {
+				purge = true
+			}
+		}
+
+		if purge {
+			purgePreCache(path, data)
+		}
+		return true
+	})
+
+	list, err := compilerConfig.CacheStore.List()
+	if err != nil {
+		return err
+	}
+
+	for key, meta := range list {
+		if meta.Page != "" && match(meta.Page) {
+			compilerConfig.CacheStore.Delete(key)
+		}
+	}
+
+	return nil
+}
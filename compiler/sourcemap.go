@@ -0,0 +1,340 @@
+package compiler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/AspieSoft/go-regex/v4"
+)
+
+// SourceMaps modes for Config.SourceMaps
+const (
+	// no source maps are created
+	SourceMapsOff = "off"
+
+	// a `.map` file is written next to each `.min.*` file
+	SourceMapsExternal = "external"
+
+	// the source map is embedded into each `.min.*` file as a data url
+	SourceMapsInline = "inline"
+
+	// external source maps are only created in DebugMode
+	SourceMapsDebug = "debug"
+)
+
+// the number of source tokens to search ahead for a matching output token
+const sourceMapWindow = 200
+
+const base64VLQ = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// sourceMapToken is a token of a source or minified file, with its position
+type sourceMapToken struct {
+	val  string
+	line int
+	col  int
+}
+
+// sourceMapTokens splits code into tokens for aligning a minified file with its sources
+//
+// whitespace and comments are skipped, and strings are kept as a single token
+func sourceMapTokens(code []byte, css bool) []sourceMapToken {
+	toks := []sourceMapToken{}
+	line, col := 0, 0
+
+	// advance moves the position forward to the index i
+	pos := 0
+	advance := func(i int) {
+		for pos < i {
+			r, size := utf8.DecodeRune(code[pos:])
+			if r == '\n' {
+				line++
+				col = 0
+			} else if r > 0xFFFF {
+				// source map columns are counted in utf16 code units
+				col += 2
+			} else {
+				col++
+			}
+			pos += size
+		}
+	}
+
+	for i := 0; i < len(code); {
+		c := code[i]
+
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			i++
+			continue
+		}
+
+		if c == '/' && i+1 < len(code) && code[i+1] == '*' {
+			if end := strings.Index(string(code[i+2:]), "*/"); end != -1 {
+				i += end + 4
+			} else {
+				i = len(code)
+			}
+			continue
+		}
+
+		if !css && c == '/' && i+1 < len(code) && code[i+1] == '/' {
+			for i < len(code) && code[i] != '\n' {
+				i++
+			}
+			continue
+		}
+
+		start := i
+		if c == '"' || c == '\'' || c == '`' {
+			for i++; i < len(code) && code[i] != c; i++ {
+				if code[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		} else if sourceMapWord(c, css) {
+			for i < len(code) && sourceMapWord(code[i], css) {
+				i++
+			}
+		} else {
+			i++
+		}
+		if i > len(code) {
+			i = len(code)
+		}
+
+		advance(start)
+		toks = append(toks, sourceMapToken{val: string(code[start:i]), line: line, col: col})
+	}
+
+	return toks
+}
+
+// sourceMapWord returns true if a character can be part of a word token
+func sourceMapWord(c byte, css bool) bool {
+	return c == '_' || c == '$' || (css && c == '-') || c >= 0x80 || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// encodeVLQ appends a base64 vlq value to a source map mappings string
+func encodeVLQ(buf *strings.Builder, n int) {
+	v := n << 1
+	if n < 0 {
+		v = (-n << 1) | 1
+	}
+
+	for {
+		digit := v & 31
+		v >>= 5
+		if v != 0 {
+			digit |= 32
+		}
+		buf.WriteByte(base64VLQ[digit])
+		if v == 0 {
+			break
+		}
+	}
+}
+
+// sourceMapSupported returns true if a source map can be created by matching the tokens of a minified file
+//
+// less and sass are compiled before they are minified, so the output is too different
+// from the source to match its tokens (sass files use the source map from libsass, and less files do not get a source map)
+func sourceMapSupported(path string) bool {
+	return regex.Comp(`\.([jt]sx?|mts|css)$`).Match([]byte(path))
+}
+
+// buildSourceMap creates a version 3 source map for a minified file
+//
+// minifiers do not report the positions they moved code from, so each output token is matched
+// with the next identical token in the sources (renamed variables will map to the nearest matched token).
+//
+// note: this is a best effort map. when the same token repeats within the search window
+// (ie: a common variable name), it may be mapped to the wrong line of the source
+func buildSourceMap(file string, res []byte, sources []string, contents [][]byte, css bool) ([]byte, error) {
+	srcToks := make([][]sourceMapToken, len(contents))
+	for i, code := range contents {
+		srcToks[i] = sourceMapTokens(code, css)
+	}
+	ptr := make([]int, len(contents))
+
+	mappings := strings.Builder{}
+	outLine := 0
+	prevCol, prevSrc, prevLine, prevSrcCol := 0, 0, 0, 0
+	lineStart := true
+	curSrc := 0
+
+	for _, tok := range sourceMapTokens(res, css) {
+		// only map words and strings, punctuation is too common to match reliably
+		if c := tok.val[0]; !sourceMapWord(c, css) && c != '"' && c != '\'' && c != '`' {
+			continue
+		}
+
+		// find the closest match, preferring the current source
+		bestSrc, bestInd, bestDist := -1, 0, sourceMapWindow
+		for n := 0; n < len(srcToks); n++ {
+			s := (curSrc + n) % len(srcToks)
+			for i := ptr[s]; i < len(srcToks[s]) && i-ptr[s] < bestDist; i++ {
+				if srcToks[s][i].val == tok.val {
+					bestSrc, bestInd, bestDist = s, i, i-ptr[s]
+					break
+				}
+			}
+		}
+		if bestSrc == -1 {
+			continue
+		}
+		curSrc = bestSrc
+		ptr[bestSrc] = bestInd + 1
+		src := srcToks[bestSrc][bestInd]
+
+		for outLine < tok.line {
+			mappings.WriteByte(';')
+			outLine++
+			prevCol = 0
+			lineStart = true
+		}
+		if !lineStart {
+			mappings.WriteByte(',')
+		}
+		lineStart = false
+
+		encodeVLQ(&mappings, tok.col-prevCol)
+		encodeVLQ(&mappings, bestSrc-prevSrc)
+		encodeVLQ(&mappings, src.line-prevLine)
+		encodeVLQ(&mappings, src.col-prevSrcCol)
+		prevCol, prevSrc, prevLine, prevSrcCol = tok.col, bestSrc, src.line, src.col
+	}
+
+	sourcesContent := make([]string, len(contents))
+	for i, code := range contents {
+		sourcesContent[i] = string(code)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"version":        3,
+		"file":           file,
+		"sources":        sources,
+		"sourcesContent": sourcesContent,
+		"names":          []string{},
+		"mappings":       mappings.String(),
+	})
+}
+
+// sourceMapMode returns the source map mode to use, based on Config.SourceMaps and DebugMode
+func sourceMapMode() string {
+	mode := compilerConfig.SourceMaps
+	if mode == SourceMapsDebug {
+		if compilerConfig.DebugMode {
+			return SourceMapsExternal
+		}
+		return SourceMapsOff
+	}
+
+	if mode != SourceMapsExternal && mode != SourceMapsInline {
+		return SourceMapsOff
+	}
+	return mode
+}
+
+// writeSourceMap adds a source map to a minified file, based on Config.SourceMaps
+//
+// @sources: the original files the minified file was created from
+func writeSourceMap(resPath string, sources []string) {
+	if sourceMapMode() == SourceMapsOff {
+		os.Remove(resPath + ".map")
+		return
+	}
+
+	res, err := os.ReadFile(resPath)
+	if err != nil {
+		return
+	}
+
+	urls := []string{}
+	contents := [][]byte{}
+	for _, path := range sources {
+		if code, err := os.ReadFile(path); err == nil {
+			urls = append(urls, compilerConfig.StaticUrl+staticUrlPath(path))
+			contents = append(contents, code)
+		}
+	}
+
+	sourceMap, err := buildSourceMap(filepath.Base(resPath), removeSourceMapURL(res), urls, contents, strings.HasSuffix(resPath, ".css"))
+	if err != nil {
+		return
+	}
+
+	attachSourceMap(resPath, res, sourceMap)
+}
+
+// writeSassSourceMap adds the source map created by libsass to a minified file
+//
+// libsass writes the sources relative to the `.map` file, so they are changed to static urls
+func writeSassSourceMap(resPath string, sourceMap []byte) {
+	if sourceMapMode() == SourceMapsOff {
+		os.Remove(resPath + ".map")
+		return
+	}
+
+	res, err := os.ReadFile(resPath)
+	if err != nil {
+		return
+	}
+
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(sourceMap, &data); err != nil {
+		return
+	}
+
+	if sources, ok := data["sources"].([]interface{}); ok {
+		for i, src := range sources {
+			if src, ok := src.(string); ok {
+				if !filepath.IsAbs(src) {
+					src = filepath.Join(filepath.Dir(resPath), filepath.FromSlash(src))
+				}
+				sources[i] = compilerConfig.StaticUrl + staticUrlPath(src)
+			}
+		}
+	}
+	data["file"] = filepath.Base(resPath)
+	delete(data, "sourceRoot")
+
+	if sourceMap, err = json.Marshal(data); err != nil {
+		return
+	}
+
+	attachSourceMap(resPath, res, sourceMap)
+}
+
+// removeSourceMapURL removes an old source map comment from a minified file
+func removeSourceMapURL(res []byte) []byte {
+	return regex.Comp(`\n(?://|/\*)# sourceMappingURL=[^\n]*$`).RepStr(res, []byte{})
+}
+
+// attachSourceMap writes a source map for a minified file, and adds a comment with its url to the file
+func attachSourceMap(resPath string, res []byte, sourceMap []byte) {
+	mapPath := resPath + ".map"
+	res = removeSourceMapURL(res)
+
+	var url string
+	if sourceMapMode() == SourceMapsInline {
+		os.Remove(mapPath)
+		url = "data:application/json;charset=utf-8;base64," + base64.StdEncoding.EncodeToString(sourceMap)
+	} else {
+		if err := writeFileAtomic(mapPath, sourceMap, 0775); err != nil {
+			return
+		}
+		url = filepath.Base(mapPath)
+	}
+
+	if strings.HasSuffix(resPath, ".css") {
+		res = append(res, []byte("\n/*# sourceMappingURL="+url+" */")...)
+	} else {
+		res = append(res, []byte("\n//# sourceMappingURL="+url)...)
+	}
+
+	writeFileAtomic(resPath, res, 0775)
+}
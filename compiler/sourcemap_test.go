@@ -0,0 +1,114 @@
+package compiler
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncodeVLQ(t *testing.T) {
+	for n, want := range map[int]string{0: "A", 1: "C", -1: "D", 15: "e", 16: "gB", -16: "hB", 1000: "w+B"} {
+		buf := strings.Builder{}
+		encodeVLQ(&buf, n)
+		if buf.String() != want {
+			t.Errorf("%d: expected %s, got %s", n, want, buf.String())
+		}
+	}
+}
+
+func TestBuildSourceMap(t *testing.T) {
+	src := []byte("function add(first, second) {\n  // comment\n  return first + second;\n}\nadd(1, 2);\n")
+	res := []byte("function add(a,b){return a+b}\nadd(1,2)")
+
+	sourceMap, err := buildSourceMap("app.min.js", res, []string{"/app.js"}, [][]byte{src}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := struct {
+		Version        int
+		File           string
+		Sources        []string
+		SourcesContent []string
+		Mappings       string
+	}{}
+	if err := json.Unmarshal(sourceMap, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Version != 3 || data.File != "app.min.js" || len(data.Sources) != 1 || data.Sources[0] != "/app.js" || data.SourcesContent[0] != string(src) {
+		t.Errorf("unexpected source map: %s", sourceMap)
+	}
+
+	// `function` and `add` map to line 1, `return` maps to line 3, and `add(1, 2)` maps to line 5
+	// the renamed params (a, b) are not in the source, so they are not mapped
+	if data.Mappings != "AAAA,SAAS,SAEP;AAEF,IAAI,EAAG" {
+		t.Errorf("unexpected mappings: %s", data.Mappings)
+	}
+}
+
+func TestSourceMapSupported(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/app.js":     true,
+		"/app.tsx":    true,
+		"/style.css":  true,
+		"/style.less": false,
+		"/style.scss": false,
+		"/style.sass": false,
+		"/icon.svg":   false,
+	} {
+		if sourceMapSupported(path) != want {
+			t.Errorf("%s: expected %v", path, want)
+		}
+	}
+}
+
+func TestWriteSassSourceMap(t *testing.T) {
+	testConfig(t)
+
+	compilerConfig.SourceMaps = SourceMapsExternal
+
+	resPath := filepath.Join(compilerConfig.Static, "style.min.css")
+	if err := os.WriteFile(resPath, []byte("a{color:red}"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	// a request that is reading the file while it is rewritten should get the old file, and not a partly written one
+	reader, err := os.Open(resPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// libsass writes the sources relative to the map file
+	writeSassSourceMap(resPath, []byte(`{"version":3,"file":"style.min.css","sourceRoot":"/root","sources":["style.scss","partials/_colors.scss"],"names":[],"mappings":"AAAA"}`))
+
+	sourceMap, err := os.ReadFile(resPath + ".map")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := struct {
+		File       string
+		SourceRoot string
+		Sources    []string
+		Mappings   string
+	}{}
+	if err := json.Unmarshal(sourceMap, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.File != "style.min.css" || data.SourceRoot != "" || strings.Join(data.Sources, ",") != "/style.scss,/partials/_colors.scss" || data.Mappings != "AAAA" {
+		t.Errorf("unexpected source map: %s", sourceMap)
+	}
+
+	if res, _ := os.ReadFile(resPath); string(res) != "a{color:red}\n/*# sourceMappingURL=style.min.css.map */" {
+		t.Errorf("expected a source map url: %s", res)
+	}
+
+	if res, _ := io.ReadAll(reader); string(res) != "a{color:red}" {
+		t.Errorf("expected the file to be replaced, and not rewritten in place: %s", res)
+	}
+}
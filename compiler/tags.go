@@ -0,0 +1,172 @@
+package compiler
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// InvalidateTag removes every cached page and fragment with a tag (ie: after "product:42" is changed in a CMS)
+//
+// pages are tagged by the `@tags` option and the `<_tag>` functions they use when they are precompiled.
+// fragments are tagged by the `tags` arg of a `<_cache>` tag, and the `<_tag>` functions inside of it
+//
+// pages in the CacheStore that are not in the htmlPreCache (ie: precompiled by another instance) are found by the tags in their md5sum files
+//
+// @int: the number of pages and fragments that were removed
+func InvalidateTag(tag string) int {
+	count := 0
+
+	htmlPreCache.ForEach(func(path string, data cacheObj) bool {
+		if goutil.Contains(data.tags, tag) {
+			purgePreCache(path, data)
+			count++
+		}
+		return true
+	})
+
+	if list, err := compilerConfig.CacheStore.List(); err == nil {
+		for key := range list {
+			if !strings.HasSuffix(key, ".cache.md5sum") {
+				continue
+			}
+
+			if sum, _ := readCacheSum(key); sum == nil || !goutil.Contains(sum.tags, tag) {
+				continue
+			}
+
+			storePath := strings.TrimSuffix(key, ".cache.md5sum")
+			for _, ext := range []string{".html.br", ".html.gz", ".html.cache", ".html"} {
+				if _, ok := list[storePath+ext]; ok {
+					compilerConfig.CacheStore.Delete(storePath + ext)
+				}
+			}
+			compilerConfig.CacheStore.Delete(key)
+			count++
+		}
+	} else {
+		LogErr(err)
+	}
+
+	htmlFragmentCache.ForEach(func(key string, frag fragmentObj) bool {
+		if goutil.Contains(frag.tags, tag) {
+			htmlFragmentCache.Del(key)
+			count++
+		}
+		return true
+	})
+
+	return count
+}
+
+// optTags returns the `@tags` option of a page
+//
+// the option can be a list of tags, or a string with the tags separated by spaces or commas
+func optTags(opts map[string]interface{}) []string {
+	tags := []string{}
+
+	switch val := opts["@tags"].(type) {
+	case []string:
+		for _, tag := range val {
+			tags = mergeTags(tags, splitTags([]byte(tag)))
+		}
+	case []interface{}:
+		for _, tag := range val {
+			tags = mergeTags(tags, splitTags(goutil.Conv.ToBytes(tag)))
+		}
+	case string:
+		tags = splitTags([]byte(val))
+	}
+
+	return tags
+}
+
+// splitTags splits a list of tags by spaces and commas
+func splitTags(b []byte) []string {
+	tags := []string{}
+	for _, tag := range bytes.FieldsFunc(b, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	}) {
+		tags = mergeTags(tags, []string{string(tag)})
+	}
+	return tags
+}
+
+// mergeTags adds the tags from a second list to the first one, without duplicates
+func mergeTags(tags []string, add []string) []string {
+	res := append([]string{}, tags...)
+	for _, tag := range add {
+		if tag != "" && !goutil.Contains(res, tag) {
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+// tagArgs returns the tags in the args of a `<_tag>` function
+//
+// @bool: false if the precompiler could not get the value of a var (the tags need to be added by the compiler)
+func tagArgs(args *htmlArgs, opts *map[string]interface{}, eachArgs *[]EachArgs, precomp bool) ([]string, bool) {
+	tags := []string{}
+	resolved := true
+
+	for _, key := range args.ind {
+		argTags, ok := tagValue(args.args[key], opts, eachArgs, precomp)
+		if !ok {
+			resolved = false
+		}
+		tags = mergeTags(tags, argTags)
+	}
+
+	return tags, resolved
+}
+
+// tagValue returns the tags in an arg, with any {{vars}} replaced by their values
+//
+// @bool: false if the precompiler could not get the value of a var
+func tagValue(arg []byte, opts *map[string]interface{}, eachArgs *[]EachArgs, precomp bool) ([]string, bool) {
+	// args.args first byte:
+	// 0 = normal arg "arg"
+	// 1 = escaped option {{arg}}
+	// 2 = raw option {{{arg}}}
+
+	if len(arg) == 0 {
+		return []string{}, true
+	}
+
+	resolved := true
+	getVar := func(name []byte) []byte {
+		val := GetOpt(name, opts, eachArgs, 0, precomp, true)
+		if goutil.IsZeroOfUnderlyingType(val) {
+			return []byte{}
+		}
+
+		b := goutil.Conv.ToBytes(val)
+		if precomp && len(b) != 0 && b[0] == 0 {
+			resolved = false
+			return []byte{}
+		}
+		return b
+	}
+
+	var val []byte
+	if arg[0] == 0 {
+		val = regex.Comp(`\{\{\{?([^{}]+)\}\}\}?`).RepFunc(arg[1:], func(data func(int) []byte) []byte {
+			return getVar(data(1))
+		})
+	} else {
+		val = getVar(arg[1:])
+	}
+
+	if !resolved {
+		return []string{}, false
+	}
+	return splitTags(val), true
+}
+
+// joinTags joins a list of tags into an arg for a `<_cache>` tag
+func joinTags(tags []string) []byte {
+	return append([]byte{0}, strings.Join(tags, " ")...)
+}
@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptTags(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  interface{}
+		want []string
+	}{
+		{"string", "product:42, sale news", []string{"product:42", "sale", "news"}},
+		{"list", []string{"a b", "c", "a"}, []string{"a", "b", "c"}},
+		{"interface list", []interface{}{"a", "b,c"}, []string{"a", "b", "c"}},
+		{"none", nil, []string{}},
+	}
+
+	for _, test := range tests {
+		if res := optTags(map[string]interface{}{"@tags": test.opt}); strings.Join(res, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, res)
+		}
+	}
+}
+
+// testTagPages precompiles each view with a `<_tag>` function for its tag, and a "page" tag from the `@tags` option
+func testTagPages(t *testing.T, views map[string]string) {
+	testConfig(t)
+
+	for name, tag := range views {
+		path := filepath.Join(compilerConfig.Root, name+".html")
+		if err := os.WriteFile(path, []byte(`<_tag "`+tag+`"/><h1>`+name+`</h1>`), 0775); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			htmlPreCache.Del(path)
+		})
+
+		if err := PreCompile(name, map[string]interface{}{"@layout": "null", "@tags": "page"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrecompileTags(t *testing.T) {
+	testTagPages(t, map[string]string{"post": "product:42"})
+
+	cache, ok := htmlPreCache.Get(filepath.Join(compilerConfig.Root, "post.html"))
+	if !ok {
+		t.Fatal("expected the page to be precompiled")
+	}
+	if strings.Join(cache.tags, ",") != "page,product:42" {
+		t.Errorf("expected the tags from the option and the tag function: %v", cache.tags)
+	}
+
+	// the tags are stored in the md5sum, so they are restored when the page is loaded from the CacheStore
+	sumPath := filepath.Join(compilerConfig.StaticHTML, "post.html.cache.md5sum")
+	if sum, _ := readCacheSum(sumPath); sum == nil || strings.Join(sum.tags, ",") != "page,product:42" {
+		t.Errorf("expected the tags in the md5sum: %v", sum)
+	}
+}
+
+func TestInvalidateTag(t *testing.T) {
+	testTagPages(t, map[string]string{"a": "product:42", "b": "product:42", "c": "product:43"})
+
+	// b was precompiled by another instance sharing the CacheStore
+	htmlPreCache.Del(filepath.Join(compilerConfig.Root, "b.html"))
+
+	setFragment("test:product:42", "", []byte("<p>42</p>"), time.Minute, []string{"product:42"})
+	setFragment("test:product:43", "", []byte("<p>43</p>"), time.Minute, []string{"product:43"})
+	defer htmlFragmentCache.Del("test:product:43")
+
+	if count := InvalidateTag("product:42"); count != 3 {
+		t.Errorf("expected 2 pages and 1 fragment to be removed, got %d", count)
+	}
+
+	if _, ok := htmlPreCache.Get(filepath.Join(compilerConfig.Root, "a.html")); ok {
+		t.Error("expected the tagged page to be removed")
+	}
+	if _, ok := htmlPreCache.Get(filepath.Join(compilerConfig.Root, "c.html")); !ok {
+		t.Error("expected the other page to be kept")
+	}
+	if _, ok := getFragment("test:product:42"); ok {
+		t.Error("expected the tagged fragment to be removed")
+	}
+	if _, ok := getFragment("test:product:43"); !ok {
+		t.Error("expected the other fragment to be kept")
+	}
+
+	list, err := compilerConfig.CacheStore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, meta := range list {
+		if meta.Page != "c" {
+			t.Errorf("expected only the files of the other page to be kept: %s", key)
+		}
+	}
+	if len(list) == 0 {
+		t.Error("expected the files of the other page to be kept")
+	}
+}
@@ -0,0 +1,1333 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/AspieSoft/go-regex/v4"
+	"github.com/AspieSoft/goutil/v5"
+)
+
+// JSXFactory is the function used to create elements when transpiling .tsx files
+var JSXFactory string = "React.createElement"
+
+// JSXFragment is the component used for fragments (<></>) when transpiling .tsx files
+var JSXFragment string = "React.Fragment"
+
+// tsToken is a single token of typescript source
+type tsToken struct {
+	// kind: 0 = space, 1 = comment, 2 = word, 3 = punctuation, 4 = string, 5 = template, 6 = number, 7 = regex, 8 = raw js (compiled jsx)
+	kind uint8
+	val  []byte
+
+	// nl is true if a space token contains a line break
+	nl bool
+}
+
+// list of multi char punctuation, sorted by size
+//
+// note: '>' is always tokenized on its own, so nested generics (ie: Array<Array<T>>) can be closed one at a time
+var tsPunct [][]byte = [][]byte{
+	[]byte("..."), []byte("==="), []byte("!=="), []byte("**="), []byte("<<="), []byte("&&="), []byte("||="), []byte("??="),
+	[]byte("=>"), []byte("=="), []byte("!="), []byte("<="), []byte("&&"), []byte("||"), []byte("??"), []byte("?."), []byte("++"), []byte("--"),
+	[]byte("+="), []byte("-="), []byte("*="), []byte("/="), []byte("%="), []byte("&="), []byte("|="), []byte("^="), []byte("**"), []byte("<<"),
+}
+
+// keywords that may be followed by an expression (ie: `return /regex/`, `return <div/>`)
+var tsExprKeywords []string = []string{"return", "typeof", "instanceof", "in", "of", "new", "delete", "void", "throw", "case", "do", "else", "yield", "await", "extends"}
+
+// keywords that cannot end an expression
+var tsKeywords []string = []string{"return", "typeof", "instanceof", "in", "of", "new", "delete", "void", "throw", "case", "do", "else", "yield", "await", "extends", "if", "for", "while", "switch", "catch", "with", "function", "class", "let", "const", "var", "import", "export", "default", "async"}
+
+// typescript only class member modifiers
+var tsModifiers []string = []string{"public", "private", "protected", "readonly", "override", "abstract", "declare"}
+
+// transpileTypeScript strips types from typescript, and returns javascript
+//
+// if jsx is true, jsx elements will also be compiled with the JSXFactory
+func transpileTypeScript(code []byte, jsx bool) ([]byte, error) {
+	code = bytes.TrimPrefix(code, []byte("\xef\xbb\xbf"))
+
+	toks, _, err := tsTokenize(code, 0, jsx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return tsStrip(toks), nil
+}
+
+func tsIsWordStart(c byte) bool {
+	return c == '_' || c == '$' || c == '#' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func tsIsWordChar(c byte) bool {
+	return tsIsWordStart(c) || (c >= '0' && c <= '9')
+}
+
+// tsExprStart returns true if the last token allows an expression to start (for regex and jsx detection)
+func tsExprStart(prev *tsToken) bool {
+	if prev == nil {
+		return true
+	}
+
+	switch prev.kind {
+	case 3:
+		return !(bytes.Equal(prev.val, []byte(")")) || bytes.Equal(prev.val, []byte("]")) || bytes.Equal(prev.val, []byte("}")))
+	case 2:
+		return goutil.Contains(tsExprKeywords, string(prev.val))
+	case 4, 5, 6, 7, 8:
+		return false
+	}
+
+	return true
+}
+
+// tsTokenize splits typescript source into tokens
+//
+// if stopAtBrace is true, this method will return when an unmatched '}' is found (used for `${}` and jsx `{}` expressions)
+//
+// @int: the position where tokenizing stopped
+func tsTokenize(code []byte, i int, jsx bool, stopAtBrace bool) ([]tsToken, int, error) {
+	toks := []tsToken{}
+	var prev *tsToken
+	depth := 0
+
+	push := func(tok tsToken) {
+		toks = append(toks, tok)
+		if tok.kind > 1 {
+			prev = &toks[len(toks)-1]
+		}
+	}
+
+	for i < len(code) {
+		c := code[i]
+
+		// space
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			start := i
+			for i < len(code) && (code[i] == ' ' || code[i] == '\t' || code[i] == '\r' || code[i] == '\n') {
+				i++
+			}
+			push(tsToken{kind: 0, val: code[start:i], nl: bytes.IndexByte(code[start:i], '\n') != -1})
+			continue
+		}
+
+		// comments
+		if c == '/' && i+1 < len(code) && code[i+1] == '/' {
+			start := i
+			for i < len(code) && code[i] != '\n' {
+				i++
+			}
+			push(tsToken{kind: 1, val: code[start:i]})
+			continue
+		} else if c == '/' && i+1 < len(code) && code[i+1] == '*' {
+			end := bytes.Index(code[i+2:], []byte("*/"))
+			if end == -1 {
+				return nil, i, errors.New("typescript: unclosed comment")
+			}
+			push(tsToken{kind: 1, val: code[i : i+end+4]})
+			i += end + 4
+			continue
+		}
+
+		// strings
+		if c == '"' || c == '\'' {
+			start := i
+			i++
+			for i < len(code) && code[i] != c {
+				if code[i] == '\\' {
+					i++
+				} else if code[i] == '\n' {
+					return nil, i, errors.New("typescript: unclosed string")
+				}
+				i++
+			}
+			if i >= len(code) {
+				return nil, i, errors.New("typescript: unclosed string")
+			}
+			i++
+			push(tsToken{kind: 4, val: code[start:i]})
+			continue
+		}
+
+		// template strings
+		if c == '`' {
+			val := []byte{'`'}
+			i++
+			for i < len(code) && code[i] != '`' {
+				if code[i] == '\\' && i+1 < len(code) {
+					val = append(val, code[i], code[i+1])
+					i += 2
+					continue
+				} else if code[i] == '$' && i+1 < len(code) && code[i+1] == '{' {
+					expr, end, err := tsTokenize(code, i+2, jsx, true)
+					if err != nil {
+						return nil, end, err
+					}
+					val = append(val, '$', '{')
+					val = append(val, tsStrip(expr)...)
+					val = append(val, '}')
+					i = end + 1
+					continue
+				}
+
+				val = append(val, code[i])
+				i++
+			}
+			if i >= len(code) {
+				return nil, i, errors.New("typescript: unclosed template string")
+			}
+			val = append(val, '`')
+			i++
+			push(tsToken{kind: 5, val: val})
+			continue
+		}
+
+		// numbers
+		if (c >= '0' && c <= '9') || (c == '.' && i+1 < len(code) && code[i+1] >= '0' && code[i+1] <= '9') {
+			start := i
+			for i < len(code) && (tsIsWordChar(code[i]) || code[i] == '.' || ((code[i] == '+' || code[i] == '-') && (code[i-1] == 'e' || code[i-1] == 'E') && !(code[start] == '0' && start+1 < len(code) && (code[start+1] == 'x' || code[start+1] == 'X')))) {
+				i++
+			}
+			push(tsToken{kind: 6, val: code[start:i]})
+			continue
+		}
+
+		// words
+		if tsIsWordStart(c) {
+			start := i
+			i++
+			for i < len(code) && tsIsWordChar(code[i]) {
+				i++
+			}
+			push(tsToken{kind: 2, val: code[start:i]})
+			continue
+		}
+
+		// regex
+		if c == '/' && tsExprStart(prev) {
+			start := i
+			i++
+			inClass := false
+			for i < len(code) && (code[i] != '/' || inClass) {
+				if code[i] == '\\' {
+					i++
+				} else if code[i] == '[' {
+					inClass = true
+				} else if code[i] == ']' {
+					inClass = false
+				} else if code[i] == '\n' {
+					return nil, i, errors.New("typescript: unclosed regex")
+				}
+				i++
+			}
+			if i >= len(code) {
+				return nil, i, errors.New("typescript: unclosed regex")
+			}
+			i++
+			for i < len(code) && tsIsWordChar(code[i]) {
+				i++
+			}
+			push(tsToken{kind: 7, val: code[start:i]})
+			continue
+		}
+
+		// jsx
+		if jsx && c == '<' && tsExprStart(prev) && i+1 < len(code) && (tsIsWordStart(code[i+1]) || code[i+1] == '>') && !tsIsGenericArrow(code, i) {
+			res, end, err := tsCompileJSX(code, i)
+			if err != nil {
+				return nil, end, err
+			}
+			push(tsToken{kind: 8, val: res})
+			i = end
+			continue
+		}
+
+		// brackets
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			if stopAtBrace && depth == 0 {
+				return toks, i, nil
+			}
+			depth--
+		}
+
+		// punctuation
+		found := false
+		for _, p := range tsPunct {
+			if bytes.HasPrefix(code[i:], p) {
+				push(tsToken{kind: 3, val: p})
+				i += len(p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			push(tsToken{kind: 3, val: code[i : i+1]})
+			i++
+		}
+	}
+
+	if stopAtBrace {
+		return nil, i, errors.New("typescript: unclosed expression")
+	}
+
+	return toks, i, nil
+}
+
+// tsIsGenericArrow detects a generic arrow function in tsx (ie: <T,>(a: T) => a)
+func tsIsGenericArrow(code []byte, i int) bool {
+	i++
+	for i < len(code) && tsIsWordChar(code[i]) {
+		i++
+	}
+	for i < len(code) && (code[i] == ' ' || code[i] == '\t') {
+		i++
+	}
+	return i < len(code) && (code[i] == ',' || bytes.HasPrefix(code[i:], []byte("extends ")))
+}
+
+// tsCompileJSX compiles a jsx element into a call to the JSXFactory
+//
+// @int: the position after the element
+func tsCompileJSX(code []byte, i int) ([]byte, int, error) {
+	// <tag
+	i++
+	start := i
+	for i < len(code) && (tsIsWordChar(code[i]) || code[i] == '.' || code[i] == '-' || code[i] == ':') {
+		i++
+	}
+	name := code[start:i]
+
+	var tag []byte
+	if len(name) == 0 {
+		tag = []byte(JSXFragment)
+	} else if (name[0] >= 'a' && name[0] <= 'z') || bytes.ContainsAny(name, "-:") {
+		tag = tsQuote(name)
+	} else {
+		tag = name
+	}
+
+	// attributes
+	props := [][]byte{}
+	selfClose := false
+	for {
+		for i < len(code) && (code[i] == ' ' || code[i] == '\t' || code[i] == '\r' || code[i] == '\n') {
+			i++
+		}
+		if i >= len(code) {
+			return nil, i, errors.New("typescript: unclosed jsx tag")
+		}
+
+		if code[i] == '>' {
+			i++
+			break
+		} else if code[i] == '/' && i+1 < len(code) && code[i+1] == '>' {
+			i += 2
+			selfClose = true
+			break
+		} else if code[i] == '{' {
+			// spread props {...props}
+			expr, end, err := tsTokenize(code, i+1, true, true)
+			if err != nil {
+				return nil, end, err
+			}
+			props = append(props, bytes.TrimSpace(tsStrip(expr)))
+			i = end + 1
+			continue
+		}
+
+		start := i
+		for i < len(code) && (tsIsWordChar(code[i]) || code[i] == '-' || code[i] == ':') {
+			i++
+		}
+		if i == start {
+			return nil, i, errors.New("typescript: invalid jsx attribute")
+		}
+		key := code[start:i]
+		if bytes.ContainsAny(key, "-:") {
+			key = tsQuote(key)
+		}
+
+		if i >= len(code) || code[i] != '=' {
+			props = append(props, regex.JoinBytes(key, []byte(": true")))
+			continue
+		}
+		i++
+
+		if i < len(code) && (code[i] == '"' || code[i] == '\'') {
+			q := code[i]
+			end := bytes.IndexByte(code[i+1:], q)
+			if end == -1 {
+				return nil, i, errors.New("typescript: unclosed jsx attribute")
+			}
+			props = append(props, regex.JoinBytes(key, []byte(": "), tsQuote(code[i+1:i+1+end])))
+			i += end + 2
+		} else if i < len(code) && code[i] == '{' {
+			expr, end, err := tsTokenize(code, i+1, true, true)
+			if err != nil {
+				return nil, end, err
+			}
+			props = append(props, regex.JoinBytes(key, []byte(": "), bytes.TrimSpace(tsStrip(expr))))
+			i = end + 1
+		} else if i < len(code) && code[i] == '<' {
+			res, end, err := tsCompileJSX(code, i)
+			if err != nil {
+				return nil, end, err
+			}
+			props = append(props, regex.JoinBytes(key, []byte(": "), res))
+			i = end
+		} else {
+			return nil, i, errors.New("typescript: invalid jsx attribute value")
+		}
+	}
+
+	propStr := []byte("null")
+	if len(props) != 0 {
+		propStr = regex.JoinBytes([]byte("{"), bytes.Join(props, []byte(", ")), []byte("}"))
+	}
+
+	children := [][]byte{}
+	if !selfClose {
+		text := []byte{}
+		addText := func() {
+			if t := tsJSXText(text); len(t) != 0 {
+				children = append(children, tsQuote(t))
+			}
+			text = []byte{}
+		}
+
+		for {
+			if i >= len(code) {
+				return nil, i, errors.New("typescript: unclosed jsx element")
+			}
+
+			if code[i] == '<' && i+1 < len(code) && code[i+1] == '/' {
+				addText()
+				end := bytes.IndexByte(code[i:], '>')
+				if end == -1 {
+					return nil, i, errors.New("typescript: unclosed jsx element")
+				}
+				if closeName := bytes.TrimSpace(code[i+2 : i+end]); !bytes.Equal(closeName, name) {
+					return nil, i, errors.New("typescript: expected jsx closing tag for '" + string(name) + "'")
+				}
+				i += end + 1
+				break
+			} else if code[i] == '<' {
+				addText()
+				res, end, err := tsCompileJSX(code, i)
+				if err != nil {
+					return nil, end, err
+				}
+				children = append(children, res)
+				i = end
+			} else if code[i] == '{' {
+				addText()
+				expr, end, err := tsTokenize(code, i+1, true, true)
+				if err != nil {
+					return nil, end, err
+				}
+				if res := bytes.TrimSpace(tsStrip(expr)); len(res) != 0 {
+					children = append(children, res)
+				}
+				i = end + 1
+			} else {
+				text = append(text, code[i])
+				i++
+			}
+		}
+	}
+
+	res := regex.JoinBytes([]byte(JSXFactory), '(', tag, []byte(", "), propStr)
+	for _, child := range children {
+		res = append(res, ',', ' ')
+		res = append(res, child...)
+	}
+	res = append(res, ')')
+
+	return res, i, nil
+}
+
+// tsJSXText trims jsx text the same way react does
+//
+// lines are trimmed, empty lines are removed, and the remaining lines are joined with a space
+func tsJSXText(text []byte) []byte {
+	if bytes.IndexByte(text, '\n') == -1 {
+		return text
+	}
+
+	lines := bytes.Split(bytes.ReplaceAll(text, []byte{'\r'}, []byte{}), []byte{'\n'})
+	res := [][]byte{}
+	for i, line := range lines {
+		if i != 0 {
+			line = bytes.TrimLeft(line, " \t")
+		}
+		if i != len(lines)-1 {
+			line = bytes.TrimRight(line, " \t")
+		}
+		if len(line) != 0 {
+			res = append(res, line)
+		}
+	}
+
+	return bytes.Join(res, []byte{' '})
+}
+
+// tsQuote returns a double quoted js string
+func tsQuote(b []byte) []byte {
+	return []byte(strconv.Quote(string(b)))
+}
+
+// tsStrip removes typescript types from a list of tokens, and returns javascript
+func tsStrip(toks []tsToken) []byte {
+	drop := make([]bool, len(toks))
+	insert := map[int][]byte{}
+
+	// next returns the index of the next significant token (skipping spaces and comments)
+	next := func(i int) int {
+		for i++; i < len(toks); i++ {
+			if toks[i].kind > 1 {
+				return i
+			}
+		}
+		return len(toks)
+	}
+
+	prevSig := func(i int) int {
+		for i--; i >= 0; i-- {
+			if toks[i].kind > 1 && !drop[i] {
+				return i
+			}
+		}
+		return -1
+	}
+
+	is := func(i int, val string) bool {
+		return i >= 0 && i < len(toks) && toks[i].kind > 1 && toks[i].kind != 4 && toks[i].kind != 5 && string(toks[i].val) == val
+	}
+
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(toks) && toks[i].kind == 2
+	}
+
+	// hasNL returns true if there is a line break between two tokens
+	hasNL := func(a int, b int) bool {
+		for i := a + 1; i < b && i < len(toks); i++ {
+			if toks[i].nl || (toks[i].kind == 1 && bytes.IndexByte(toks[i].val, '\n') != -1) {
+				return true
+			}
+		}
+		return false
+	}
+
+	dropRange := func(a int, b int) {
+		for i := a; i < b && i < len(toks); i++ {
+			drop[i] = true
+		}
+	}
+
+	// matchClose returns the index of the bracket that closes toks[i]
+	matchClose := func(i int) int {
+		if is(i, "<") {
+			level := 0
+			for ; i < len(toks); i++ {
+				if is(i, "<") {
+					level++
+				} else if is(i, ">") {
+					level--
+					if level == 0 {
+						return i
+					}
+				} else if is(i, ";") || is(i, "{") && level == 0 {
+					return -1
+				}
+			}
+			return -1
+		}
+
+		level := 0
+		for ; i < len(toks); i++ {
+			if is(i, "(") || is(i, "[") || is(i, "{") {
+				level++
+			} else if is(i, ")") || is(i, "]") || is(i, "}") {
+				level--
+				if level == 0 {
+					return i
+				}
+			}
+		}
+		return len(toks) - 1
+	}
+
+	// exprEnd returns true if a token can end an expression
+	exprEnd := func(i int) bool {
+		if i < 0 {
+			return false
+		}
+		switch toks[i].kind {
+		case 2:
+			return !goutil.Contains(tsKeywords, string(toks[i].val))
+		case 4, 5, 6, 7, 8:
+			return true
+		case 3:
+			return is(i, ")") || is(i, "]") || is(i, "}")
+		}
+		return false
+	}
+
+	// looksLikeTypeArgs returns true if the tokens between '<' and '>' only contain type syntax
+	looksLikeTypeArgs := func(i int) int {
+		end := matchClose(i)
+		if end == -1 {
+			return -1
+		}
+		for j := i + 1; j < end; j++ {
+			if toks[j].kind <= 2 || toks[j].kind == 4 || toks[j].kind == 6 {
+				continue
+			}
+			if toks[j].kind == 3 && goutil.Contains([]string{"<", ">", ",", ".", "[", "]", "|", "&", "{", "}", ":", ";", "(", ")", "=>", "?", "=", "..."}, string(toks[j].val)) {
+				continue
+			}
+			return -1
+		}
+		return end
+	}
+
+	// parseType returns the index after a type, starting at toks[i]
+	var parseType func(i int) int
+	var parsePrimary func(i int) int
+
+	parsePrimary = func(i int) int {
+		if i >= len(toks) {
+			return i
+		}
+
+		switch {
+		case is(i, "("):
+			end := matchClose(i)
+			if n := next(end); is(n, "=>") {
+				return parseType(next(n))
+			}
+			return next(end)
+		case is(i, "{") || is(i, "["):
+			return next(matchClose(i))
+		case is(i, "<"):
+			// generic function type
+			end := matchClose(i)
+			if end == -1 {
+				return next(i)
+			}
+			return parsePrimary(next(end))
+		case is(i, "-"):
+			return next(next(i))
+		case is(i, "new") || is(i, "abstract"):
+			return parsePrimary(next(i))
+		case is(i, "typeof"):
+			i = next(i)
+			for isWord(i) {
+				i = next(i)
+				if !is(i, ".") {
+					break
+				}
+				i = next(i)
+			}
+			return i
+		case is(i, "keyof") || is(i, "readonly") || is(i, "unique") || is(i, "infer") || is(i, "asserts"):
+			return parsePrimary(next(i))
+		case toks[i].kind == 4 || toks[i].kind == 5 || toks[i].kind == 6:
+			return next(i)
+		case isWord(i):
+			i = next(i)
+			for is(i, ".") && isWord(next(i)) {
+				i = next(next(i))
+			}
+			if is(i, "<") {
+				if end := matchClose(i); end != -1 {
+					i = next(end)
+				}
+			}
+			if is(i, "is") {
+				return parseType(next(i))
+			}
+			return i
+		}
+
+		return next(i)
+	}
+
+	parseType = func(i int) int {
+		if is(i, "|") || is(i, "&") {
+			i = next(i)
+		}
+
+		for {
+			i = parsePrimary(i)
+
+			// array and indexed access types
+			for is(i, "[") && !hasNL(prevSig(i), i) {
+				i = next(matchClose(i))
+			}
+
+			if is(i, "|") || is(i, "&") {
+				i = next(i)
+				continue
+			}
+
+			// conditional types
+			if is(i, "extends") {
+				i = parseType(next(i))
+				if is(i, "?") {
+					i = parseType(next(i))
+					if is(i, ":") {
+						i = parseType(next(i))
+					}
+				}
+			}
+
+			return i
+		}
+	}
+
+	// dropStatement drops tokens from a to the end of the statement
+	dropStatement := func(a int) int {
+		level := 0
+		last := -1
+		i := a
+		for ; i < len(toks); i++ {
+			if toks[i].kind <= 1 {
+				continue
+			}
+			if level == 0 && last != -1 && hasNL(last, i) && !tsExprContinues(toks, last, i) {
+				break
+			}
+			last = i
+
+			if is(i, "{") || is(i, "(") || is(i, "[") {
+				level++
+			} else if is(i, "}") || is(i, ")") || is(i, "]") {
+				level--
+				if level < 0 {
+					break
+				}
+			} else if level == 0 && is(i, ";") {
+				i++
+				break
+			}
+		}
+		dropRange(a, i)
+		return i
+	}
+
+	// stmtStart returns the index of an `export` or `declare` keyword before a statement (or -1 if not at the start of a statement)
+	stmtStart := func(i int) int {
+		start := i
+		p := prevSig(i)
+		for is(p, "export") || is(p, "declare") || is(p, "default") {
+			start = p
+			p = prevSig(p)
+		}
+		if p == -1 || is(p, ";") || is(p, "{") || is(p, "}") || hasNL(p, start) && !tsExprContinues(toks, p, start) {
+			return start
+		}
+		return -1
+	}
+
+	// parseParams handles a function parameter list
+	//
+	// @ctor: the name of parameter properties in a constructor will be added to this list
+	parseParams := func(open int, ctor *[]string) int {
+		end := matchClose(open)
+		i := next(open)
+		for i < end {
+			// this param
+			if is(i, "this") && is(next(i), ":") {
+				j := parseType(next(next(i)))
+				if is(j, ",") {
+					j = next(j)
+				}
+				dropRange(i, j)
+				i = j
+				continue
+			}
+
+			// parameter properties
+			isProp := false
+			for isWord(i) && goutil.Contains(tsModifiers, string(toks[i].val)) && (isWord(next(i)) || is(next(i), "{") || is(next(i), "[")) {
+				isProp = true
+				dropRange(i, next(i))
+				i = next(i)
+			}
+			if isProp && ctor != nil && isWord(i) {
+				*ctor = append(*ctor, string(toks[i].val))
+			}
+
+			if is(i, "...") {
+				i = next(i)
+			}
+
+			// name or destructure pattern
+			if is(i, "{") || is(i, "[") {
+				i = next(matchClose(i))
+			} else {
+				i = next(i)
+			}
+
+			if is(i, "?") {
+				drop[i] = true
+				i = next(i)
+			}
+
+			if is(i, ":") {
+				j := parseType(next(i))
+				dropRange(i, j)
+				i = j
+			}
+
+			// skip default value
+			level := 0
+			for i < end && !(level == 0 && is(i, ",")) {
+				if is(i, "(") || is(i, "[") || is(i, "{") {
+					level++
+				} else if is(i, ")") || is(i, "]") || is(i, "}") {
+					level--
+				}
+				i = next(i)
+			}
+			if is(i, ",") {
+				i = next(i)
+			}
+		}
+		return end
+	}
+
+	// dropReturnType drops a return type after a parameter list, and returns the next index
+	dropReturnType := func(close int) int {
+		i := next(close)
+		if is(i, ":") {
+			j := parseType(next(i))
+			dropRange(i, j)
+			return j
+		}
+		return i
+	}
+
+	// bracket context: 'c' = class body, 'i' = import/export list, 'b' = other
+	ctx := []byte{}
+	ctxPos := []int{}
+	pendingClass := false
+	pendingImport := false
+	ctorProps := map[int][]string{}
+	declDepth := -1
+
+	for i := next(-1); i < len(toks); i = next(i) {
+		if drop[i] {
+			continue
+		}
+
+		inClass := len(ctx) != 0 && ctx[len(ctx)-1] == 'c'
+		p := prevSig(i)
+
+		// class members
+		if inClass && (is(p, "{") && ctxPos[len(ctxPos)-1] == p || is(p, ";") || is(p, "}") || hasNL(p, i) && !tsExprContinues(toks, p, i)) && !is(i, "}") && !is(i, ";") {
+			start := i
+			isAbstract := false
+			isCtor := false
+			for isWord(i) && goutil.Contains(append(tsModifiers, "static", "async", "get", "set", "accessor"), string(toks[i].val)) {
+				n := next(i)
+				if is(n, "(") || is(n, "=") || is(n, ":") || is(n, ";") || is(n, "?") || is(n, "!") || is(n, "<") || is(n, "}") {
+					break
+				}
+				if goutil.Contains(tsModifiers, string(toks[i].val)) {
+					if is(i, "abstract") || is(i, "declare") {
+						isAbstract = true
+					}
+					dropRange(i, n)
+				}
+				i = n
+			}
+
+			if isAbstract {
+				dropStatement(start)
+				continue
+			}
+
+			// index signature
+			if is(i, "[") {
+				end := matchClose(i)
+				isIndex := false
+				for j := next(i); j < end; j = next(j) {
+					if is(j, ":") {
+						isIndex = true
+						break
+					}
+				}
+				if isIndex && is(next(end), ":") {
+					dropStatement(start)
+					continue
+				}
+			}
+
+			if is(i, "*") {
+				i = next(i)
+			}
+
+			if is(i, "constructor") {
+				isCtor = true
+			}
+
+			if isWord(i) || toks[i].kind == 4 || toks[i].kind == 6 {
+				i = next(i)
+			} else if is(i, "[") {
+				i = next(matchClose(i))
+			} else {
+				i = prevSig(i + 1)
+				goto normal
+			}
+
+			if is(i, "?") || is(i, "!") {
+				drop[i] = true
+				i = next(i)
+			}
+
+			if is(i, "<") {
+				if end := matchClose(i); end != -1 {
+					dropRange(i, end+1)
+					i = next(end)
+				}
+			}
+
+			if is(i, "(") {
+				props := []string{}
+				end := parseParams(i, &props)
+				j := dropReturnType(end)
+				if !is(j, "{") {
+					// method overload
+					dropStatement(start)
+					continue
+				}
+				if isCtor && len(props) != 0 {
+					ctorProps[j] = props
+				}
+				i = prevSig(j)
+				continue
+			} else if is(i, ":") {
+				j := parseType(next(i))
+				dropRange(i, j)
+				i = prevSig(j)
+				continue
+			}
+
+			i = prevSig(i)
+			continue
+		}
+
+	normal:
+		v := string(toks[i].val)
+		if toks[i].kind == 4 || toks[i].kind == 5 {
+			v = ""
+		}
+
+		switch {
+		case v == "{":
+			if pendingClass {
+				ctx = append(ctx, 'c')
+				pendingClass = false
+			} else if pendingImport {
+				ctx = append(ctx, 'i')
+				pendingImport = false
+			} else {
+				ctx = append(ctx, 'b')
+			}
+			ctxPos = append(ctxPos, i)
+
+			// assign constructor parameter properties
+			if props, ok := ctorProps[i]; ok {
+				assign := []byte{}
+				for _, prop := range props {
+					assign = append(assign, regex.JoinBytes("this.", prop, " = ", prop, ";")...)
+				}
+
+				// add after super call if one exists
+				at := i
+				end := matchClose(i)
+				for j := next(i); j < end; j = next(j) {
+					if is(j, "super") && is(next(j), "(") {
+						at = matchClose(next(j))
+						if is(next(at), ";") {
+							at = next(at)
+						}
+						break
+					}
+				}
+				insert[at] = append(insert[at], assign...)
+			}
+			continue
+		case v == "}":
+			if len(ctx) != 0 {
+				ctx = ctx[:len(ctx)-1]
+				ctxPos = ctxPos[:len(ctxPos)-1]
+			}
+			continue
+		case v == ";":
+			declDepth = -1
+			continue
+		}
+
+		// statements
+		if isWord(i) {
+			if start := stmtStart(i); start != -1 {
+				n := next(i)
+				switch v {
+				case "interface":
+					if isWord(n) {
+						j := n
+						for j < len(toks) && !is(j, "{") {
+							j = next(j)
+						}
+						end := matchClose(j)
+						dropRange(start, end+1)
+						i = end
+						continue
+					}
+				case "type":
+					if isWord(n) && (is(next(n), "=") || is(next(n), "<")) {
+						j := next(n)
+						if is(j, "<") {
+							j = next(matchClose(j))
+						}
+						j = parseType(next(j))
+						if is(j, ";") {
+							j++
+						}
+						dropRange(start, j)
+						i = prevSig(j)
+						continue
+					} else if is(n, "{") && is(start, "export") {
+						dropStatement(start)
+						continue
+					}
+				case "declare":
+					dropStatement(start)
+					continue
+				case "abstract":
+					if is(n, "class") {
+						drop[i] = true
+						continue
+					}
+				case "enum", "const":
+					if v == "const" && !is(n, "enum") {
+						break
+					}
+					enumStart := i
+					if v == "const" {
+						i = n
+						n = next(i)
+					}
+					if isWord(n) && is(next(n), "{") {
+						name := toks[n].val
+						open := next(n)
+						end := matchClose(open)
+						insert[enumStart] = tsCompileEnum(toks[open+1:end], name)
+						dropRange(enumStart, end+1)
+						i = end
+						continue
+					}
+				case "import":
+					if is(n, "type") && !is(next(n), "from") && !is(next(n), ",") {
+						dropStatement(start)
+						continue
+					}
+					pendingImport = true
+				case "export":
+					if is(n, "{") {
+						pendingImport = true
+					} else if is(n, "type") && is(next(n), "{") {
+						dropStatement(i)
+						continue
+					}
+				}
+			}
+
+			switch v {
+			case "class":
+				// class name, generics, extends, and implements
+				j := next(i)
+				if isWord(j) && !is(j, "extends") && !is(j, "implements") {
+					j = next(j)
+				}
+				if is(j, "<") {
+					if end := matchClose(j); end != -1 {
+						dropRange(j, end+1)
+						j = next(end)
+					}
+				}
+				if is(j, "extends") {
+					j = next(j)
+					for j < len(toks) && !is(j, "{") && !is(j, "implements") && !is(j, "<") {
+						j = next(j)
+					}
+					if is(j, "<") {
+						if end := matchClose(j); end != -1 {
+							dropRange(j, end+1)
+							j = next(end)
+						}
+					}
+				}
+				if is(j, "implements") {
+					k := j
+					for k < len(toks) && !is(k, "{") {
+						k = next(k)
+					}
+					dropRange(j, k)
+					j = k
+				}
+				pendingClass = true
+				i = prevSig(j)
+				continue
+			case "function":
+				start := stmtStart(i)
+				j := next(i)
+				if is(j, "*") {
+					j = next(j)
+				}
+				if isWord(j) {
+					j = next(j)
+				}
+				if is(j, "<") {
+					if end := matchClose(j); end != -1 {
+						dropRange(j, end+1)
+						j = next(end)
+					}
+				}
+				if is(j, "(") {
+					end := parseParams(j, nil)
+					k := dropReturnType(end)
+					if !is(k, "{") && start != -1 {
+						// function overload
+						dropStatement(start)
+						continue
+					}
+					i = prevSig(k)
+				}
+				continue
+			case "catch":
+				if n := next(i); is(n, "(") {
+					end := parseParams(n, nil)
+					i = end
+				}
+				continue
+			case "let", "const", "var":
+				declDepth = len(ctx)
+				j := next(i)
+				if is(j, "{") || is(j, "[") {
+					j = next(matchClose(j))
+				} else if isWord(j) {
+					j = next(j)
+				}
+				if is(j, "!") {
+					drop[j] = true
+					j = next(j)
+				}
+				if is(j, ":") {
+					k := parseType(next(j))
+					dropRange(j, k)
+					j = k
+				}
+				i = prevSig(j)
+				continue
+			case "type":
+				// type only import specifiers (ie: import { type A, B } from './mod')
+				if len(ctx) != 0 && ctx[len(ctx)-1] == 'i' && (is(p, "{") || is(p, ",")) && isWord(next(i)) {
+					j := next(next(i))
+					if is(j, "as") {
+						j = next(next(j))
+					}
+					if is(j, ",") {
+						j = next(j)
+					} else if is(p, ",") {
+						drop[p] = true
+					}
+					dropRange(i, j)
+					i = prevSig(j)
+					continue
+				}
+			case "as", "satisfies":
+				if (len(ctx) == 0 || ctx[len(ctx)-1] != 'i') && exprEnd(p) && !is(p, "*") {
+					j := next(i)
+					if is(j, "const") {
+						j = next(j)
+					} else {
+						j = parseType(j)
+					}
+					dropRange(i, j)
+					i = prevSig(j)
+					continue
+				}
+			}
+		}
+
+		// declarations with multiple vars (ie: let a: number = 1, b: string = '')
+		if is(i, ",") && declDepth == len(ctx) {
+			j := next(i)
+			if is(j, "{") || is(j, "[") {
+				j = next(matchClose(j))
+			} else if isWord(j) {
+				j = next(j)
+			}
+			if is(j, "!") {
+				drop[j] = true
+				j = next(j)
+			}
+			if is(j, ":") {
+				k := parseType(next(j))
+				dropRange(j, k)
+				j = k
+			}
+			i = prevSig(j)
+			continue
+		}
+
+		// arrow functions
+		if is(i, "(") {
+			end := matchClose(i)
+			n := next(end)
+			isArrow := is(n, "=>")
+			if !isArrow && is(n, ":") && !is(p, "?") {
+				if k := parseType(next(n)); is(k, "=>") {
+					isArrow = true
+				}
+			}
+			if isArrow && !(isWord(p) && !goutil.Contains(tsKeywords, string(toks[p].val))) {
+				end = parseParams(i, nil)
+				dropReturnType(end)
+				i = end
+				continue
+			}
+		}
+
+		// generics (ie: foo<T>(), new Map<string, number>(), <T>(a: T) => a)
+		if is(i, "<") {
+			if (isWord(p) && !goutil.Contains(tsKeywords, string(toks[p].val)) && !hasNL(p, i) && i == p+1) || !exprEnd(p) {
+				if end := looksLikeTypeArgs(i); end != -1 {
+					n := next(end)
+					if !exprEnd(p) || is(n, "(") || (n < len(toks) && toks[n].kind == 5) {
+						dropRange(i, end+1)
+						i = end
+						continue
+					}
+				}
+			}
+		}
+
+		// non null assertions (ie: foo!.bar, foo! + 1)
+		//
+		// a '!' cannot follow the end of an expression in javascript ('!=' and '!==' are separate tokens).
+		// '}' is skipped, because it may be the end of a block (ie: `if(a){b()}!c&&d()`)
+		if is(i, "!") && exprEnd(p) && !is(p, "}") && i == p+1 {
+			drop[i] = true
+			continue
+		}
+	}
+
+	res := []byte{}
+	for i, tok := range toks {
+		if ins, ok := insert[i]; ok && drop[i] {
+			res = append(res, ins...)
+		}
+		if !drop[i] {
+			res = append(res, tok.val...)
+		}
+		if ins, ok := insert[i]; ok && !drop[i] {
+			res = append(res, ins...)
+		}
+	}
+
+	return res
+}
+
+// tsExprContinues returns true if an expression continues across a line break (ie: a line ending with an operator)
+func tsExprContinues(toks []tsToken, prev int, nextTok int) bool {
+	if prev < 0 || nextTok >= len(toks) {
+		return false
+	}
+
+	if toks[prev].kind == 3 {
+		p := string(toks[prev].val)
+		if p != ")" && p != "]" && p != "}" && p != ";" && p != "++" && p != "--" {
+			return true
+		}
+	}
+
+	if toks[nextTok].kind == 3 {
+		n := string(toks[nextTok].val)
+		if n == "." || n == "?." || n == "=>" || n == "|" || n == "&" || n == "&&" || n == "||" || n == "??" || n == "?" || n == ":" || n == "," || n == "=" || n == "<" || n == ">" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tsCompileEnum compiles an enum into javascript
+func tsCompileEnum(body []tsToken, name []byte) []byte {
+	res := regex.JoinBytes("var ", name, "; (function (", name, ") {")
+
+	members := [][]tsToken{{}}
+	level := 0
+	for _, tok := range body {
+		if tok.kind <= 1 {
+			continue
+		}
+		if tok.kind == 3 && (tok.val[0] == '(' || tok.val[0] == '[' || tok.val[0] == '{') {
+			level++
+		} else if tok.kind == 3 && (tok.val[0] == ')' || tok.val[0] == ']' || tok.val[0] == '}') {
+			level--
+		}
+
+		if level == 0 && tok.kind == 3 && tok.val[0] == ',' {
+			members = append(members, []tsToken{})
+			continue
+		}
+		members[len(members)-1] = append(members[len(members)-1], tok)
+	}
+
+	num := 0
+	prevKey := []byte{}
+	for _, m := range members {
+		if len(m) == 0 {
+			continue
+		}
+
+		key := m[0].val
+		if m[0].kind != 4 {
+			key = tsQuote(key)
+		}
+
+		if len(m) > 2 && m[1].kind == 3 && m[1].val[0] == '=' {
+			val := []byte{}
+			for _, tok := range m[2:] {
+				val = append(val, tok.val...)
+			}
+
+			if n, err := strconv.Atoi(string(val)); err == nil {
+				num = n + 1
+				res = append(res, regex.JoinBytes(name, "[", name, "[", key, "] = ", val, "] = ", key, ";")...)
+			} else if m[2].kind == 4 || m[2].kind == 5 {
+				res = append(res, regex.JoinBytes(name, "[", key, "] = ", val, ";")...)
+			} else {
+				res = append(res, regex.JoinBytes(name, "[", name, "[", key, "] = ", val, "] = ", key, ";")...)
+			}
+		} else if len(prevKey) != 0 && num == 0 {
+			res = append(res, regex.JoinBytes(name, "[", name, "[", key, "] = ", name, "[", prevKey, "] + 1] = ", key, ";")...)
+		} else {
+			res = append(res, regex.JoinBytes(name, "[", name, "[", key, "] = ", strconv.Itoa(num), "] = ", key, ";")...)
+			num++
+		}
+
+		prevKey = key
+	}
+
+	return append(res, regex.JoinBytes("})(", name, " || (", name, " = {}));")...)
+}
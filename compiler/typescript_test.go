@@ -0,0 +1,83 @@
+package compiler
+
+import (
+	"testing"
+)
+
+func TestTranspileTypeScript(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		// generics vs comparisons
+		{"generic function", "function f<T>(a: T, b?: number): T { return a < b ? a : b > 1 }", "function f(a, b){ return a < b ? a : b > 1 }"},
+		{"nested generics", "const m = new Map<string, Array<number>>();", "const m = new Map();"},
+		{"generic call", "const x = f<string>(1);", "const x = f(1);"},
+		{"comparison", "let x = a < b && c > d;", "let x = a < b && c > d;"},
+		{"comparison with parens", "if (a < b && c > (d)) {}", "if (a < b && c > (d)) {}"},
+		{"comparison or", "let y = a < b || c > d;", "let y = a < b || c > d;"},
+		{"loop", "for (let i = 0; i < n; i++) { if (i > 2) break; }", "for (let i = 0; i < n; i++) { if (i > 2) break; }"},
+		{"type assertion", "let o = { a: 1 as number, b: <any>2 };", "let o = { a: 1 , b: 2 };"},
+
+		// enums
+		{"enum", "enum Color { Red, Green = 5, Blue }", `var Color; (function (Color) {Color[Color["Red"] = 0] = "Red";Color[Color["Green"] = 5] = "Green";Color[Color["Blue"] = 6] = "Blue";})(Color || (Color = {}));`},
+		{"string enum", "const enum E { A = 'a', B = 'b' }", `var E; (function (E) {E["A"] = 'a';E["B"] = 'b';})(E || (E = {}));`},
+
+		// as and satisfies
+		{"as", "if (a < b) { x = y as string; }", "if (a < b) { x = y ; }"},
+		{"chained as", "let v = x as unknown as string[];", "let v = x ;"},
+		{"satisfies", "let v = conf satisfies Config;", "let v = conf ;"},
+
+		// non null assertions
+		{"non null", "let n = el!.value; let b = !ok;", "let n = el.value; let b = !ok;"},
+		{"non null operator", "let c = a! + 1; if(a){b()}!c&&d();", "let c = a + 1; if(a){b()}!c&&d();"},
+		{"not equal", "let d = a!==b || a != c;", "let d = a!==b || a != c;"},
+
+		// params
+		{"optional params", "function f(a?: string, b: number = 2, ...rest: any[]): void {}", "function f(a, b= 2, ...rest){}"},
+		{"optional arrow params", "const p = (a?: number, b = 1) => a;", "const p = (a, b = 1) => a;"},
+		{"this param", "function g(this: Window, a?) {}", "function g(a) {}"},
+		{"arrow return type", "const fn = (a: number): number => a * 2;", "const fn = (a)=> a * 2;"},
+		{"ternary", "let c = cond ? a : b;", "let c = cond ? a : b;"},
+
+		// declarations
+		{"class", "class A<T> implements B { private x: number = 1; constructor(public y: string, readonly z?: T) { super(); } abstract m(): void; }", "class A { x= 1; constructor(y, z) { super();this.y = y;this.z = z; }  }"},
+		{"types", "interface I { a: string }\ntype T = { a: number } | string;\nexport type { I };\nconst q = 1;", "\n\n\nconst q = 1;"},
+		{"type imports", "import type { A } from './a';\nimport { b, type C } from './b';", "\nimport { b } from './b';"},
+		{"declare", "declare const X: string;\nlet y = 1;", "\nlet y = 1;"},
+	}
+
+	for _, test := range tests {
+		res, err := transpileTypeScript([]byte(test.src), false)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if string(res) != test.want {
+			t.Errorf("%s:\n got: %q\nwant: %q", test.name, res, test.want)
+		}
+	}
+}
+
+func TestTranspileJSX(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"element", `const el = <div className="a" id={x}>Hello {name}<br/></div>;`, `const el = React.createElement("div", {className: "a", id: x}, "Hello ", name, React.createElement("br", null));`},
+		{"fragment", "const f = <><A b /></>;", `const f = React.createElement(React.Fragment, null, React.createElement(A, {b: true}));`},
+		{"spread props", `const e = <Comp {...props} key="1" />;`, `const e = React.createElement(Comp, {...props, key: "1"});`},
+		{"comparison in expression", `const e = <div>{a < b ? 'x' : 'y'}</div>;`, `const e = React.createElement("div", null, a < b ? 'x' : 'y');`},
+		{"generic arrow", "const g = <T,>(a: T) => a;", "const g = (a) => a;"},
+		{"comparison and regex", "let r = a < b; let s = /<div>/g.test(x);", "let r = a < b; let s = /<div>/g.test(x);"},
+	}
+
+	for _, test := range tests {
+		res, err := transpileTypeScript([]byte(test.src), true)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if string(res) != test.want {
+			t.Errorf("%s:\n got: %q\nwant: %q", test.name, res, test.want)
+		}
+	}
+}